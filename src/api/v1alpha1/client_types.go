@@ -0,0 +1,160 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Client resources are applied and client pod is ready
+	ClientConditionReady = "Ready"
+)
+
+type WireguardClientSpec struct {
+	// +kubebuilder:validation:Required
+
+	// Required. IP address of the client in the tunnel
+	Address `json:"address,omitempty"`
+
+	// +kubebuilder:validation:Required
+
+	// Required. External wireguard server to connect to
+	Server WireguardServer `json:"server"`
+
+	// Remote hosts exposed to the cluster as ports of the client service
+	Ports []ClientPort `json:"ports,omitempty"`
+
+	// Routes traffic of the selected pods to remote networks through the
+	// client, without changes to the pods
+	Egress *Egress `json:"egress,omitempty"`
+
+	// Extra labels for all resources created
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Affinity configuration
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+}
+
+type WireguardServer struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MaxLength=44
+	// +kubebuilder:validation:MinLength=44
+	// +kubebuilder:example="WsFemZZdyC+ajbvOtKA7dltaNCaPOusKmkJffjMOMmg="
+
+	// Required. Public key of the server
+	PublicKey string `json:"publicKey"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:example="vpn.example.com:51820"
+
+	// Required. Address of the server, including port
+	Endpoint string `json:"endpoint"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:example={"10.0.0.0/8"}
+
+	// Required. Remote networks routed through the tunnel
+	AllowedIPs []string `json:"allowedIPs"`
+
+	// Reference to the secret key holding preshared key of the server
+	PresharedKeyRef *corev1.SecretKeySelector `json:"presharedKeyRef,omitempty"`
+
+	// +kubebuilder:default=25
+	// +kubebuilder:validation:Minimum=0
+
+	// Interval in seconds of keepalive packets, so the tunnel survives
+	// NAT of the cluster. Zero disables keepalive
+	PersistentKeepalive int32 `json:"persistentKeepalive,omitempty"`
+}
+
+type ClientPort struct {
+	// +kubebuilder:validation:Required
+
+	// Required. Name of the service port
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+
+	// Required. Port of the client service
+	Port int32 `json:"port"`
+
+	// +kubebuilder:validation:Enum=TCP;UDP
+	// +kubebuilder:default="TCP"
+
+	// Protocol of the port
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:example="10.0.0.5:5432"
+
+	// Required. Remote host and port traffic is forwarded to. Must be
+	// within .spec.server.allowedIPs
+	Target string `json:"target"`
+}
+
+type Egress struct {
+	// Namespaces of the pods. Namespace of the client is used when empty
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// +kubebuilder:validation:Required
+
+	// Required. Pods which traffic is routed through the client
+	PodSelector metav1.LabelSelector `json:"podSelector"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:example={"10.20.0.0/16"}
+
+	// Required. Destination networks routed through the client. Must be
+	// within .spec.server.allowedIPs
+	DestinationCIDRs []string `json:"destinationCIDRs"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Server",type=string,JSONPath=`.spec.server.endpoint`
+//+kubebuilder:printcolumn:name="Gateway",type=string,JSONPath=`.status.gateway`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// WireguardClient connects the cluster to an external wireguard server
+type WireguardClient struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WireguardClientSpec   `json:"spec,omitempty"`
+	Status WireguardClientStatus `json:"status,omitempty"`
+}
+
+type WireguardClientStatus struct {
+	// Public key of the client, which must be added to the server
+	PublicKey *string `json:"publicKey,omitempty"`
+
+	// IP address of the client pod. Remote networks are reachable by
+	// routing them via this address
+	Gateway *string `json:"gateway,omitempty"`
+
+	// Routing table of the egress on the nodes. Tables are allocated by
+	// operator, so egress rules of the clients never share one
+	EgressTable *int32 `json:"egressTable,omitempty"`
+
+	// Conditions represent the latest available observations of the
+	// client
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WireguardClientList contains a list of WireguardClient
+type WireguardClientList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WireguardClient `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WireguardClient{}, &WireguardClientList{})
+}
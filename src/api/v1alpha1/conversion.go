@@ -0,0 +1,9 @@
+package v1alpha1
+
+// v1alpha1 is the storage version, other versions are converted through it
+
+// Hub marks this type as a conversion hub
+func (*Wireguard) Hub() {}
+
+// Hub marks this type as a conversion hub
+func (*WireguardPeer) Hub() {}
@@ -1,6 +1,250 @@
 package v1alpha1
 
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
 // +kubebuilder:validation:Pattern="^((10(\\.(([0-9]?[0-9])|(1[0-9]?[0-9])|(2[0-4]?[0-9])|(25[0-5]))){3})|(172\\.((1[6-9])|(2[0-9])(3[0-1]))(\\.(([0-9]?[0-9])|(1[0-9]?[0-9])|(2[0-4]?[0-9])|(25[0-5]))){2})|(192\\.168(\\.(([0-9]?[0-9])|(1[0-9]?[0-9])|(2[0-4]?[0-9])|(25[0-5]))){2})|(127('\\.(([0-9]?[0-9])|(1[0-9]?[0-9])|(2[0-4]?[0-9])|(25[0-5]))){3}))/([8-9]|(1[0-9])|(2[0-9])|(3[0-2]))$"
 
 // IP address of the peer
 type Address string
+
+// Split DNS forwarder configuration
+type DNSForwarder struct {
+	// Whether DNS forwarder is running
+	Enabled bool `json:"enabled,omitempty"`
+
+	// +kubebuilder:default="cluster.local"
+
+	// Cluster domain to be resolved by cluster DNS
+	ClusterDomain string `json:"clusterDomain,omitempty"`
+
+	// +kubebuilder:default="coredns/coredns:1.12.1"
+
+	// Image of the DNS forwarder
+	Image string `json:"image,omitempty"`
+}
+
+// Pod disruption budget configuration
+type PodDisruptionBudget struct {
+	// Whether pod disruption budget is created
+	Enabled bool `json:"enabled,omitempty"`
+
+	// +kubebuilder:default=1
+
+	// Maximum number of unavailable pods during voluntary disruptions
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// Network policy configuration
+type NetworkPolicy struct {
+	// Whether network policy is created
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Open;Isolated;AllowList
+
+// Mode of the peer isolation
+type IsolationMode string
+
+const (
+	// Peers can reach each other
+	IsolationOpen IsolationMode = "Open"
+	// Peers can reach only upstream networks
+	IsolationIsolated IsolationMode = "Isolated"
+	// Peers can reach only peers allowed by the rules
+	IsolationAllowList IsolationMode = "AllowList"
+)
+
+// Control of the traffic between peers
+type PeerIsolation struct {
+	// +kubebuilder:default="Open"
+
+	// Mode of the isolation. Open allows all traffic between peers,
+	// Isolated denies it and AllowList allows only traffic matching rules
+	Mode IsolationMode `json:"mode,omitempty"`
+
+	// Traffic allowed between peers in AllowList mode. Replies are always
+	// allowed
+	Rules []PeerIsolationRule `json:"rules,omitempty"`
+}
+
+// Connections allowed from one group of peers to another
+type PeerIsolationRule struct {
+	// Peers opening connections
+	From PeerSelector `json:"from"`
+
+	// Peers accepting connections
+	To PeerSelector `json:"to"`
+}
+
+// Selects peers of the wireguard by names or labels
+type PeerSelector struct {
+	// Names of the peers
+	Names []string `json:"names,omitempty"`
+
+	// Label selector of the peers
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// Rate limits of the peer traffic, in bits per second
+type Bandwidth struct {
+	// +kubebuilder:example="10M"
+
+	// Rate of the traffic sent by the peer. Exceeding traffic is dropped
+	Ingress *resource.Quantity `json:"ingress,omitempty"`
+
+	// +kubebuilder:example="50M"
+
+	// Rate of the traffic sent to the peer. Exceeding traffic is queued
+	Egress *resource.Quantity `json:"egress,omitempty"`
+}
+
+// MTU configuration of the wireguard interface
+type MTU struct {
+	// +kubebuilder:validation:Minimum=1280
+	// +kubebuilder:validation:Maximum=9000
+
+	// MTU of the wireguard interface, also used by peers without their
+	// own. Server ignores it when auto is set
+	Value int32 `json:"value,omitempty"`
+
+	// Whether MTU is derived at startup from MTU of eth0 of the pod minus
+	// wireguard overhead, e.g. when CNI encapsulates pod traffic
+	Auto bool `json:"auto,omitempty"`
+}
+
+// Networks of the cluster routed to peers
+type ClusterNetworks struct {
+	// Whether pod and service networks of the cluster are discovered and
+	// added to allowed IPs of peers
+	Enabled bool `json:"enabled,omitempty"`
+
+	// +kubebuilder:example={"10.244.0.0/16"}
+
+	// Pod networks used instead of .spec.podCIDRs of the nodes, for CNIs
+	// allocating pod addresses on their own
+	PodCIDRs []string `json:"podCIDRs,omitempty"`
+
+	// +kubebuilder:example={"10.96.0.0/12"}
+
+	// Service networks used instead of ServiceCIDR resources, for clusters
+	// not serving networking.k8s.io/v1 API
+	ServiceCIDRs []string `json:"serviceCIDRs,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=ServiceMonitor;PodMonitor
+
+// Kind of the prometheus operator monitor
+type MonitorKind string
+
+const (
+	ServiceMonitor MonitorKind = "ServiceMonitor"
+	PodMonitor     MonitorKind = "PodMonitor"
+)
+
+// Metrics exporter configuration
+type Metrics struct {
+	// Whether metrics exporter is running
+	Enabled bool `json:"enabled,omitempty"`
+
+	// +kubebuilder:default="mindflavor/prometheus-wireguard-exporter:3.6.6"
+
+	// Image of the metrics exporter
+	Image string `json:"image,omitempty"`
+
+	// +kubebuilder:default="ServiceMonitor"
+
+	// Kind of the monitor to be created. Monitor is created only when
+	// prometheus operator is installed in the cluster
+	Monitor MonitorKind `json:"monitor,omitempty"`
+}
+
+// Readiness probe configuration of the wireguard container
+type ReadinessProbe struct {
+	// Whether readiness probe is disabled
+	Disabled bool `json:"disabled,omitempty"`
+
+	// +kubebuilder:example="192.168.254.2"
+
+	// Host pinged through the tunnel, e.g. address of the always-on peer.
+	// When empty, only state of the wireguard interface is checked
+	Target string `json:"target,omitempty"`
+
+	// Custom probe replacing the built-in one
+	Custom *corev1.Probe `json:"custom,omitempty"`
+}
+
+// Monthly data quota of the peer. Period starts on the first day of the
+// calendar month in UTC
+type Quota struct {
+	// +kubebuilder:example="100Gi"
+	// Bytes the peer may transfer in both directions during the period.
+	// Peer is excluded from the wireguard configuration once reached
+	Limit resource.Quantity `json:"limit"`
+}
+
+// Traffic of the peer accumulated during the quota period
+type TrafficUsage struct {
+	// Start of the current quota period
+	PeriodStart metav1.Time `json:"periodStart"`
+
+	// Bytes received from the peer during the period
+	ReceivedBytes int64 `json:"receivedBytes"`
+
+	// Bytes sent to the peer during the period
+	SentBytes int64 `json:"sentBytes"`
+
+	// Last observed counters of the wireguard pods. Counters are reset
+	// when pod restarts, so only increments are accumulated
+	Counters []TransferCounter `json:"counters,omitempty"`
+}
+
+// Transfer counters of the peer on the wireguard interface of the pod
+type TransferCounter struct {
+	// UID of the wireguard pod
+	Pod string `json:"pod"`
+
+	// Bytes received from the peer since the interface is up
+	ReceivedBytes int64 `json:"receivedBytes"`
+
+	// Bytes sent to the peer since the interface is up
+	SentBytes int64 `json:"sentBytes"`
+}
+
+// +kubebuilder:validation:Enum=Stdout;File
+
+// Destination of the audit events
+type AuditSink string
+
+const (
+	AuditStdout AuditSink = "Stdout"
+	AuditFile   AuditSink = "File"
+)
+
+// Audit log of the peer connections. Events are JSON lines produced by
+// wg-audit sidecar polling the wireguard interface
+type Audit struct {
+	// Whether audit log is produced
+	Enabled bool `json:"enabled,omitempty"`
+
+	// +optional
+
+	// Image containing wg-audit binary, image of the operator by default
+	Image string `json:"image,omitempty"`
+
+	// +kubebuilder:default="Stdout"
+
+	// Where events are written. File sink appends to audit.log on the
+	// volume named audit, rotating it at 10MiB. Operator does not ship the
+	// file, sidecars can mount the volume to do so
+	Sink AuditSink `json:"sink,omitempty"`
+
+	// +kubebuilder:default="10s"
+
+	// How often the wireguard interface is polled
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
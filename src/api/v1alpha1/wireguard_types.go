@@ -5,14 +5,20 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// Wireguard resources are applied and endpoint is known
+	WireguardConditionReady = "Ready"
+)
+
 type WireguardSpec struct {
 	// +kubebuilder:validation:Minimum=1
 	// +kubebuilder:validation:Maximum=10
 	// +kubebuilder:validation:ExclusiveMaximum=false
-	// +kubebuilder:default=1
+	// +optional
 
-	// Replicas defines the number of Wireguard instances
-	Replicas int32 `json:"replicas,omitempty"`
+	// Replicas defines the number of Wireguard instances. When unset, replicas
+	// are not managed by the operator, so those can be scaled by autoscaler
+	Replicas *int32 `json:"replicas,omitempty"`
 
 	// +kubebuilder:default="ClusterIP"
 
@@ -34,6 +40,18 @@ type WireguardSpec struct {
 	// DNS configuration for peer
 	DNS string `json:"dns,omitempty"`
 
+	// Additional DNS servers for peer, used after .spec.dns
+	DNSServers []string `json:"dnsServers,omitempty"`
+
+	// DNS search domains for peer
+	DNSSearchDomains []string `json:"dnsSearchDomains,omitempty"`
+
+	// DNS forwarder running inside the wireguard pod. When enabled, peers
+	// use it as the only DNS server, cluster domain is resolved by
+	// cluster DNS and everything else is forwarded to .spec.dns and
+	// .spec.dnsServers
+	DNSForwarder *DNSForwarder `json:"dnsForwarder,omitempty"`
+
 	// +kubebuilder:example="example.com:51820"
 
 	// Address which going to be used in peers configuration. By default,
@@ -56,10 +74,54 @@ type WireguardSpec struct {
 
 	// Extra labels for all resources created
 	Labels map[string]string `json:"labels,omitempty"`
+
+	// Reference to the existing secret in the same namespace holding
+	// private key of the wireguard. When set, public key is derived from
+	// it and the keypair is never generated or overwritten by operator.
+	// Useful when migrating existing VPN without reissuing peer configs
+	PrivateKeyRef *corev1.SecretKeySelector `json:"privateKeyRef,omitempty"`
+
+	// Reference to the config map key in the same namespace holding go
+	// text/template used to render configuration of all peers instead of
+	// the built-in one. Can be overridden per peer
+	PeerConfigTemplateRef *corev1.ConfigMapKeySelector `json:"peerConfigTemplateRef,omitempty"`
+
+	// Pod disruption budget for the wireguard pods. Created only when
+	// there is more than one replica
+	PodDisruptionBudget *PodDisruptionBudget `json:"podDisruptionBudget,omitempty"`
+
+	// Network policy admitting only wireguard traffic to the wireguard pods
+	NetworkPolicy *NetworkPolicy `json:"networkPolicy,omitempty"`
+
+	// Metrics exporter running inside the wireguard pod
+	Metrics *Metrics `json:"metrics,omitempty"`
+
+	// Audit log of the peer connections
+	Audit *Audit `json:"audit,omitempty"`
+
+	// Readiness probe of the wireguard container. By default, pod is ready
+	// once wireguard interface is up and listening
+	ReadinessProbe *ReadinessProbe `json:"readinessProbe,omitempty"`
+
+	// Pod and service networks of the cluster added to allowed IPs of
+	// peers, so split tunnel peers reach the cluster
+	ClusterNetworks *ClusterNetworks `json:"clusterNetworks,omitempty"`
+
+	// MTU of the wireguard interface. Kernel default is used when empty
+	MTU *MTU `json:"mtu,omitempty"`
+
+	// Control of the traffic between peers. All peers can reach each
+	// other when empty
+	PeerIsolation *PeerIsolation `json:"peerIsolation,omitempty"`
+
+	// Bandwidth limits of every peer without its own, applied inside the
+	// wireguard pod
+	PeerBandwidth *Bandwidth `json:"peerBandwidth,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:storageversion
 
 // WireguardPeer is the Schema for the wireguardpeers API
 type Wireguard struct {
@@ -76,6 +138,16 @@ type WireguardStatus struct {
 
 	// Endpoint of the peer
 	Endpoint *string `json:"endpoint,omitempty"`
+
+	// Resolved addresses of .spec.dns and .spec.dnsServers
+	DNS []string `json:"dns,omitempty"`
+
+	// Discovered networks of the cluster, included in allowed IPs of peers
+	ClusterNetworks []string `json:"clusterNetworks,omitempty"`
+
+	// Conditions represent the latest available observations of the
+	// wireguard
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,368 @@
+package v1beta1
+
+import (
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+// ConvertTo converts wireguard to the hub version
+func (src *Wireguard) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.Wireguard)
+	dst.ObjectMeta = src.ObjectMeta
+
+	spec := src.Spec
+	dst.Spec = v1alpha1.WireguardSpec{
+		Replicas:              spec.Replicas,
+		ServiceType:           spec.ServiceType,
+		AllowedIPs:            strings.Join(spec.AllowedIPs, ", "),
+		Address:               v1alpha1.Address(spec.Address),
+		DNSSearchDomains:      spec.DNS.SearchDomains,
+		EndpointAddress:       spec.EndpointAddress,
+		DropConnectionsTo:     spec.DropConnectionsTo,
+		Sidecars:              spec.Sidecars,
+		Affinity:              spec.Affinity,
+		ServiceAnnotations:    spec.ServiceAnnotations,
+		Labels:                spec.Labels,
+		PrivateKeyRef:         spec.PrivateKeyRef,
+		PeerConfigTemplateRef: spec.PeerConfigTemplateRef,
+	}
+
+	// first server is the primary one in v1alpha1
+	if len(spec.DNS.Servers) > 0 {
+		dst.Spec.DNS = spec.DNS.Servers[0]
+	}
+	if len(spec.DNS.Servers) > 1 {
+		dst.Spec.DNSServers = spec.DNS.Servers[1:]
+	}
+
+	if fwd := spec.DNS.Forwarder; fwd != nil {
+		dst.Spec.DNSForwarder = &v1alpha1.DNSForwarder{
+			Enabled:       fwd.Enabled,
+			ClusterDomain: fwd.ClusterDomain,
+			Image:         fwd.Image,
+		}
+	}
+	if pdb := spec.PodDisruptionBudget; pdb != nil {
+		dst.Spec.PodDisruptionBudget = &v1alpha1.PodDisruptionBudget{
+			Enabled:        pdb.Enabled,
+			MaxUnavailable: pdb.MaxUnavailable,
+		}
+	}
+	if np := spec.NetworkPolicy; np != nil {
+		dst.Spec.NetworkPolicy = &v1alpha1.NetworkPolicy{
+			Enabled: np.Enabled,
+		}
+	}
+	if m := spec.Metrics; m != nil {
+		dst.Spec.Metrics = &v1alpha1.Metrics{
+			Enabled: m.Enabled,
+			Image:   m.Image,
+			Monitor: v1alpha1.MonitorKind(m.Monitor),
+		}
+	}
+	if a := spec.Audit; a != nil {
+		dst.Spec.Audit = &v1alpha1.Audit{
+			Enabled:  a.Enabled,
+			Image:    a.Image,
+			Sink:     v1alpha1.AuditSink(a.Sink),
+			Interval: a.Interval,
+		}
+	}
+	if rp := spec.ReadinessProbe; rp != nil {
+		dst.Spec.ReadinessProbe = &v1alpha1.ReadinessProbe{
+			Disabled: rp.Disabled,
+			Target:   rp.Target,
+			Custom:   rp.Custom,
+		}
+	}
+	if cn := spec.ClusterNetworks; cn != nil {
+		dst.Spec.ClusterNetworks = &v1alpha1.ClusterNetworks{
+			Enabled:      cn.Enabled,
+			PodCIDRs:     cn.PodCIDRs,
+			ServiceCIDRs: cn.ServiceCIDRs,
+		}
+	}
+	if mtu := spec.MTU; mtu != nil {
+		dst.Spec.MTU = &v1alpha1.MTU{
+			Value: mtu.Value,
+			Auto:  mtu.Auto,
+		}
+	}
+	if bw := spec.PeerBandwidth; bw != nil {
+		dst.Spec.PeerBandwidth = &v1alpha1.Bandwidth{
+			Ingress: bw.Ingress,
+			Egress:  bw.Egress,
+		}
+	}
+	if iso := spec.PeerIsolation; iso != nil {
+		dst.Spec.PeerIsolation = &v1alpha1.PeerIsolation{
+			Mode: v1alpha1.IsolationMode(iso.Mode),
+		}
+		for _, rule := range iso.Rules {
+			dst.Spec.PeerIsolation.Rules = append(dst.Spec.PeerIsolation.Rules,
+				v1alpha1.PeerIsolationRule{
+					From: v1alpha1.PeerSelector(rule.From),
+					To:   v1alpha1.PeerSelector(rule.To),
+				})
+		}
+	}
+
+	status := src.Status
+	dst.Status = v1alpha1.WireguardStatus{
+		PublicKey:       toPtrOrNil(status.PublicKey),
+		Endpoint:        toPtrOrNil(status.Endpoint),
+		DNS:             status.DNS,
+		ClusterNetworks: status.ClusterNetworks,
+		Conditions:      status.Conditions,
+	}
+
+	return nil
+}
+
+// ConvertFrom converts wireguard from the hub version
+func (dst *Wireguard) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.Wireguard)
+	dst.ObjectMeta = src.ObjectMeta
+
+	spec := src.Spec
+	dst.Spec = WireguardSpec{
+		Replicas:              spec.Replicas,
+		ServiceType:           spec.ServiceType,
+		AllowedIPs:            splitList(spec.AllowedIPs),
+		Address:               Address(spec.Address),
+		EndpointAddress:       spec.EndpointAddress,
+		DropConnectionsTo:     spec.DropConnectionsTo,
+		Sidecars:              spec.Sidecars,
+		Affinity:              spec.Affinity,
+		ServiceAnnotations:    spec.ServiceAnnotations,
+		Labels:                spec.Labels,
+		PrivateKeyRef:         spec.PrivateKeyRef,
+		PeerConfigTemplateRef: spec.PeerConfigTemplateRef,
+		DNS: DNS{
+			SearchDomains: spec.DNSSearchDomains,
+		},
+	}
+
+	if spec.DNS != "" {
+		dst.Spec.DNS.Servers = append(dst.Spec.DNS.Servers, spec.DNS)
+	}
+	dst.Spec.DNS.Servers = append(dst.Spec.DNS.Servers, spec.DNSServers...)
+
+	if fwd := spec.DNSForwarder; fwd != nil {
+		dst.Spec.DNS.Forwarder = &DNSForwarder{
+			Enabled:       fwd.Enabled,
+			ClusterDomain: fwd.ClusterDomain,
+			Image:         fwd.Image,
+		}
+	}
+	if pdb := spec.PodDisruptionBudget; pdb != nil {
+		dst.Spec.PodDisruptionBudget = &PodDisruptionBudget{
+			Enabled:        pdb.Enabled,
+			MaxUnavailable: pdb.MaxUnavailable,
+		}
+	}
+	if np := spec.NetworkPolicy; np != nil {
+		dst.Spec.NetworkPolicy = &NetworkPolicy{
+			Enabled: np.Enabled,
+		}
+	}
+	if m := spec.Metrics; m != nil {
+		dst.Spec.Metrics = &Metrics{
+			Enabled: m.Enabled,
+			Image:   m.Image,
+			Monitor: MonitorKind(m.Monitor),
+		}
+	}
+	if a := spec.Audit; a != nil {
+		dst.Spec.Audit = &Audit{
+			Enabled:  a.Enabled,
+			Image:    a.Image,
+			Sink:     AuditSink(a.Sink),
+			Interval: a.Interval,
+		}
+	}
+	if rp := spec.ReadinessProbe; rp != nil {
+		dst.Spec.ReadinessProbe = &ReadinessProbe{
+			Disabled: rp.Disabled,
+			Target:   rp.Target,
+			Custom:   rp.Custom,
+		}
+	}
+	if cn := spec.ClusterNetworks; cn != nil {
+		dst.Spec.ClusterNetworks = &ClusterNetworks{
+			Enabled:      cn.Enabled,
+			PodCIDRs:     cn.PodCIDRs,
+			ServiceCIDRs: cn.ServiceCIDRs,
+		}
+	}
+	if mtu := spec.MTU; mtu != nil {
+		dst.Spec.MTU = &MTU{
+			Value: mtu.Value,
+			Auto:  mtu.Auto,
+		}
+	}
+	if bw := spec.PeerBandwidth; bw != nil {
+		dst.Spec.PeerBandwidth = &Bandwidth{
+			Ingress: bw.Ingress,
+			Egress:  bw.Egress,
+		}
+	}
+	if iso := spec.PeerIsolation; iso != nil {
+		dst.Spec.PeerIsolation = &PeerIsolation{
+			Mode: IsolationMode(iso.Mode),
+		}
+		for _, rule := range iso.Rules {
+			dst.Spec.PeerIsolation.Rules = append(dst.Spec.PeerIsolation.Rules,
+				PeerIsolationRule{
+					From: PeerSelector(rule.From),
+					To:   PeerSelector(rule.To),
+				})
+		}
+	}
+
+	status := src.Status
+	dst.Status = WireguardStatus{
+		PublicKey:       fromPtr(status.PublicKey),
+		Endpoint:        fromPtr(status.Endpoint),
+		DNS:             status.DNS,
+		ClusterNetworks: status.ClusterNetworks,
+		Conditions:      status.Conditions,
+	}
+
+	return nil
+}
+
+// ConvertTo converts peer to the hub version
+func (src *WireguardPeer) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.WireguardPeer)
+	dst.ObjectMeta = src.ObjectMeta
+
+	spec := src.Spec
+	dst.Spec = v1alpha1.WireguardPeerSpec{
+		Address:             v1alpha1.Address(spec.Address),
+		WireguardRef:        spec.WireguardRef,
+		PublicKey:           spec.PublicKey,
+		Suspended:           spec.Suspended,
+		ConfigTemplateRef:   spec.ConfigTemplateRef,
+		MTU:                 spec.MTU,
+		PersistentKeepalive: spec.PersistentKeepalive,
+		ListenPort:          spec.ListenPort,
+		FwMark:              spec.FwMark,
+		Table:               spec.Table,
+	}
+	if bw := spec.Bandwidth; bw != nil {
+		dst.Spec.Bandwidth = &v1alpha1.Bandwidth{
+			Ingress: bw.Ingress,
+			Egress:  bw.Egress,
+		}
+	}
+	if q := spec.Quota; q != nil {
+		dst.Spec.Quota = &v1alpha1.Quota{Limit: q.Limit}
+	}
+
+	// v1alpha1 always reports effective public key in status
+	publicKey := toPtrOrNil(src.Status.GeneratedPublicKey)
+	if spec.PublicKey != nil {
+		publicKey = spec.PublicKey
+	}
+	dst.Status = v1alpha1.WireguardPeerStatus{
+		PublicKey:  publicKey,
+		Suspended:  src.Status.Suspended,
+		Conditions: src.Status.Conditions,
+	}
+	if u := src.Status.Usage; u != nil {
+		dst.Status.Usage = &v1alpha1.TrafficUsage{
+			PeriodStart:   u.PeriodStart,
+			ReceivedBytes: u.ReceivedBytes,
+			SentBytes:     u.SentBytes,
+		}
+		for _, c := range u.Counters {
+			dst.Status.Usage.Counters = append(dst.Status.Usage.Counters,
+				v1alpha1.TransferCounter(c))
+		}
+	}
+
+	return nil
+}
+
+// ConvertFrom converts peer from the hub version
+func (dst *WireguardPeer) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.WireguardPeer)
+	dst.ObjectMeta = src.ObjectMeta
+
+	spec := src.Spec
+	dst.Spec = WireguardPeerSpec{
+		Address:             Address(spec.Address),
+		WireguardRef:        spec.WireguardRef,
+		PublicKey:           spec.PublicKey,
+		Suspended:           spec.Suspended,
+		ConfigTemplateRef:   spec.ConfigTemplateRef,
+		MTU:                 spec.MTU,
+		PersistentKeepalive: spec.PersistentKeepalive,
+		ListenPort:          spec.ListenPort,
+		FwMark:              spec.FwMark,
+		Table:               spec.Table,
+	}
+	if bw := spec.Bandwidth; bw != nil {
+		dst.Spec.Bandwidth = &Bandwidth{
+			Ingress: bw.Ingress,
+			Egress:  bw.Egress,
+		}
+	}
+	if q := spec.Quota; q != nil {
+		dst.Spec.Quota = &Quota{Limit: q.Limit}
+	}
+
+	// key provided in spec is not duplicated in status
+	dst.Status = WireguardPeerStatus{
+		Suspended:  src.Status.Suspended,
+		Conditions: src.Status.Conditions,
+	}
+	if spec.PublicKey == nil {
+		dst.Status.GeneratedPublicKey = fromPtr(src.Status.PublicKey)
+	}
+	if u := src.Status.Usage; u != nil {
+		dst.Status.Usage = &TrafficUsage{
+			PeriodStart:   u.PeriodStart,
+			ReceivedBytes: u.ReceivedBytes,
+			SentBytes:     u.SentBytes,
+		}
+		for _, c := range u.Counters {
+			dst.Status.Usage.Counters = append(dst.Status.Usage.Counters,
+				TransferCounter(c))
+		}
+	}
+
+	return nil
+}
+
+// Splits comma separated list, e.g. "10.0.0.0/8, 192.168.0.0/16"
+func splitList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+func toPtrOrNil(s string) *string {
+	if s == "" {
+		return nil
+	}
+
+	return &s
+}
+
+func fromPtr(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
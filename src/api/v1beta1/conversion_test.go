@@ -0,0 +1,265 @@
+package v1beta1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+const publicKey = "WsFemZZdyC+ajbvOtKA7dltaNCaPOusKmkJffjMOMmg="
+
+func toPtr[V any](o V) *V { return &o }
+
+var (
+	meta = metav1.ObjectMeta{
+		Name:        "wireguard",
+		Namespace:   "default",
+		Labels:      map[string]string{"app": "vpn"},
+		Annotations: map[string]string{"note": "kept"},
+	}
+
+	conditions = []metav1.Condition{{
+		Type:   "Ready",
+		Status: metav1.ConditionTrue,
+		Reason: "Reconciled",
+	}}
+
+	alphaWireguard = v1alpha1.Wireguard{
+		ObjectMeta: meta,
+		Spec: v1alpha1.WireguardSpec{
+			Replicas:          toPtr[int32](2),
+			ServiceType:       corev1.ServiceTypeLoadBalancer,
+			AllowedIPs:        "10.0.0.0/8, 192.168.0.0/16",
+			Address:           "192.168.254.1/24",
+			DNS:               "1.1.1.1",
+			DNSServers:        []string{"one.one.one.one"},
+			DNSSearchDomains:  []string{"svc.cluster.local"},
+			EndpointAddress:   toPtr("example.com:51820"),
+			DropConnectionsTo: []string{"10.0.0.1"},
+			Labels:            map[string]string{"team": "infra"},
+			DNSForwarder: &v1alpha1.DNSForwarder{
+				Enabled:       true,
+				ClusterDomain: "cluster.local",
+				Image:         "coredns/coredns:1.12.1",
+			},
+			PrivateKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: "key",
+				},
+				Key: "private-key",
+			},
+			PodDisruptionBudget: &v1alpha1.PodDisruptionBudget{
+				Enabled:        true,
+				MaxUnavailable: toPtr(intstr.FromInt32(1)),
+			},
+			NetworkPolicy: &v1alpha1.NetworkPolicy{Enabled: true},
+			Metrics: &v1alpha1.Metrics{
+				Enabled: true,
+				Monitor: v1alpha1.PodMonitor,
+			},
+			ReadinessProbe: &v1alpha1.ReadinessProbe{
+				Target: "192.168.254.2",
+			},
+			ClusterNetworks: &v1alpha1.ClusterNetworks{
+				Enabled:      true,
+				ServiceCIDRs: []string{"10.96.0.0/12"},
+			},
+			MTU: &v1alpha1.MTU{Auto: true},
+			Audit: &v1alpha1.Audit{
+				Enabled:  true,
+				Sink:     v1alpha1.AuditFile,
+				Interval: &metav1.Duration{Duration: 5 * time.Second},
+			},
+			PeerBandwidth: &v1alpha1.Bandwidth{
+				Ingress: toPtr(resource.MustParse("10M")),
+			},
+			PeerIsolation: &v1alpha1.PeerIsolation{
+				Mode: v1alpha1.IsolationAllowList,
+				Rules: []v1alpha1.PeerIsolationRule{{
+					From: v1alpha1.PeerSelector{Names: []string{"admin"}},
+					To: v1alpha1.PeerSelector{
+						Selector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"role": "server"},
+						},
+					},
+				}},
+			},
+		},
+		Status: v1alpha1.WireguardStatus{
+			PublicKey:       toPtr(publicKey),
+			Endpoint:        toPtr("example.com:51820"),
+			DNS:             []string{"1.1.1.1", "1.0.0.1"},
+			ClusterNetworks: []string{"10.96.0.0/12", "10.244.0.0/16"},
+			Conditions:      conditions,
+		},
+	}
+)
+
+func TestWireguardConversion(t *testing.T) {
+	t.Parallel()
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	o.Spec("converts structured fields", func(t *testing.T) {
+		beta := &Wireguard{}
+		err := beta.ConvertFrom(alphaWireguard.DeepCopy())
+		assert.Nil(t, err)
+
+		spec := beta.Spec
+		assert.Equal(t, meta, beta.ObjectMeta)
+		assert.Equal(t, Address("192.168.254.1/24"), spec.Address)
+		assert.Equal(t,
+			[]string{"10.0.0.0/8", "192.168.0.0/16"},
+			spec.AllowedIPs)
+		assert.Equal(t,
+			[]string{"1.1.1.1", "one.one.one.one"},
+			spec.DNS.Servers)
+		assert.Equal(t, []string{"svc.cluster.local"}, spec.DNS.SearchDomains)
+		assert.True(t, spec.DNS.Forwarder.Enabled)
+		assert.Equal(t, PodMonitor, spec.Metrics.Monitor)
+		assert.Equal(t, publicKey, beta.Status.PublicKey)
+		assert.Equal(t, conditions, beta.Status.Conditions)
+	})
+
+	o.Spec("round trips from v1alpha1", func(t *testing.T) {
+		beta := &Wireguard{}
+		err := beta.ConvertFrom(alphaWireguard.DeepCopy())
+		assert.Nil(t, err)
+
+		alpha := &v1alpha1.Wireguard{}
+		err = beta.ConvertTo(alpha)
+		assert.Nil(t, err)
+		assert.Equal(t, alphaWireguard, *alpha)
+	})
+
+	o.Spec("round trips from v1beta1", func(t *testing.T) {
+		want := Wireguard{
+			ObjectMeta: meta,
+			Spec: WireguardSpec{
+				Replicas:   toPtr[int32](1),
+				AllowedIPs: []string{"0.0.0.0/0"},
+				Address:    "192.168.254.1/24",
+				DNS: DNS{
+					Servers:       []string{"1.1.1.1", "8.8.8.8"},
+					SearchDomains: []string{"example.com"},
+				},
+			},
+			Status: WireguardStatus{
+				PublicKey:  publicKey,
+				Conditions: conditions,
+			},
+		}
+
+		alpha := &v1alpha1.Wireguard{}
+		err := want.DeepCopy().ConvertTo(alpha)
+		assert.Nil(t, err)
+		assert.Equal(t, "1.1.1.1", alpha.Spec.DNS)
+		assert.Equal(t, []string{"8.8.8.8"}, alpha.Spec.DNSServers)
+		assert.Nil(t, alpha.Status.Endpoint)
+
+		got := &Wireguard{}
+		err = got.ConvertFrom(alpha)
+		assert.Nil(t, err)
+		assert.Equal(t, want, *got)
+	})
+}
+
+func TestWireguardPeerConversion(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		description string
+		alpha       v1alpha1.WireguardPeer
+		// expected generated key in v1beta1 status
+		generated string
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		beta := &WireguardPeer{}
+		err := beta.ConvertFrom(tc.alpha.DeepCopy())
+		assert.Nil(t, err)
+		assert.Equal(t, Address("192.168.254.2/24"), beta.Spec.Address)
+		assert.Equal(t, tc.generated, beta.Status.GeneratedPublicKey)
+
+		alpha := &v1alpha1.WireguardPeer{}
+		err = beta.ConvertTo(alpha)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.alpha, *alpha)
+	})
+
+	spec.Entry("generated key", testCase{
+		alpha: v1alpha1.WireguardPeer{
+			ObjectMeta: meta,
+			Spec: v1alpha1.WireguardPeerSpec{
+				Address:             "192.168.254.2/24",
+				WireguardRef:        "wireguard",
+				Suspended:           true,
+				MTU:                 toPtr[int32](1380),
+				ListenPort:          toPtr[int32](51821),
+				FwMark:              "0xca6c",
+				Table:               "off",
+				PersistentKeepalive: toPtr[int32](0),
+				Bandwidth: &v1alpha1.Bandwidth{
+					Egress: toPtr(resource.MustParse("50M")),
+				},
+				Quota: &v1alpha1.Quota{
+					Limit: resource.MustParse("100Gi"),
+				},
+			},
+			Status: v1alpha1.WireguardPeerStatus{
+				PublicKey: toPtr(publicKey),
+				Suspended: true,
+				Usage: &v1alpha1.TrafficUsage{
+					PeriodStart:   metav1.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+					ReceivedBytes: 1024,
+					SentBytes:     2048,
+					Counters: []v1alpha1.TransferCounter{{
+						Pod:           "uid",
+						ReceivedBytes: 512,
+						SentBytes:     1024,
+					}},
+				},
+				Conditions: conditions,
+			},
+		},
+		generated: publicKey,
+	})
+
+	spec.Entry("provided key is not duplicated", testCase{
+		alpha: v1alpha1.WireguardPeer{
+			ObjectMeta: meta,
+			Spec: v1alpha1.WireguardPeerSpec{
+				Address:      "192.168.254.2/24",
+				WireguardRef: "wireguard",
+				PublicKey:    toPtr(publicKey),
+			},
+			Status: v1alpha1.WireguardPeerStatus{
+				PublicKey: toPtr(publicKey),
+			},
+		},
+		generated: "",
+	})
+
+	spec.Entry("not yet reconciled", testCase{
+		alpha: v1alpha1.WireguardPeer{
+			ObjectMeta: meta,
+			Spec: v1alpha1.WireguardPeerSpec{
+				Address:      "192.168.254.2/24",
+				WireguardRef: "wireguard",
+			},
+		},
+		generated: "",
+	})
+}
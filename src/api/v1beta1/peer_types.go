@@ -0,0 +1,126 @@
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Peer configuration is rendered from the template
+	PeerConditionConfigRendered = "ConfigRendered"
+)
+
+// WireguardPeerSpec defines the desired state of WireguardPeer
+type WireguardPeerSpec struct {
+	// +kubebuilder:default="192.168.254.2/24"
+
+	// IP address of the peer
+	Address Address `json:"address,omitempty"`
+
+	// +kubebuilder:validation:Required
+
+	// Required. Reference to the wireguard resource
+	WireguardRef string `json:"wireguardRef,omitempty"`
+
+	// +kubebuilder:validation:MaxLength=44
+	// +kubebuilder:validation:MinLength=44
+	// +kubebuilder:example="WsFemZZdyC+ajbvOtKA7dltaNCaPOusKmkJffjMOMmg="
+
+	// Public key of the peer, when private key is kept by the peer owner.
+	// Configuration of such peer is not rendered
+	PublicKey *string `json:"publicKey,omitempty"`
+
+	// Suspended peer keeps its keys and secret, but is excluded from the
+	// wireguard configuration, so it cannot connect until resumed
+	Suspended bool `json:"suspended,omitempty"`
+
+	// Reference to the config map key in the same namespace holding go
+	// text/template used to render configuration of the peer. Takes
+	// precedence over .spec.peerConfigTemplateRef of the wireguard
+	ConfigTemplateRef *corev1.ConfigMapKeySelector `json:"configTemplateRef,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1280
+	// +kubebuilder:validation:Maximum=9000
+
+	// MTU set in configuration of the peer. Defaults to .spec.mtu.value
+	// of the wireguard
+	MTU *int32 `json:"mtu,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+
+	// Interval in seconds of keepalive packets sent by the peer. Zero
+	// disables keepalive, e.g. for battery-sensitive devices. Defaults
+	// to 25
+	PersistentKeepalive *int32 `json:"persistentKeepalive,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+
+	// Port the peer listens on. Random port is used when empty
+	ListenPort *int32 `json:"listenPort,omitempty"`
+
+	// +kubebuilder:validation:Pattern=`^(off|0x[0-9a-fA-F]{1,8}|[0-9]{1,10})$`
+
+	// Firewall mark of the outgoing packets of the peer, either decimal,
+	// hexadecimal or off
+	FwMark string `json:"fwMark,omitempty"`
+
+	// +kubebuilder:validation:Pattern=`^(off|auto|[0-9]{1,10})$`
+
+	// Routing table of the peer routes, either number, auto or off
+	Table string `json:"table,omitempty"`
+
+	// Bandwidth limits of the peer. Limits not set are taken from
+	// .spec.peerBandwidth of the wireguard
+	Bandwidth *Bandwidth `json:"bandwidth,omitempty"`
+
+	// Monthly data quota of the peer. Usage is reported in .status.usage,
+	// peer is suspended until period resets or the limit is raised
+	Quota *Quota `json:"quota,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Wireguard",type=string,JSONPath=`.spec.wireguardRef`
+//+kubebuilder:printcolumn:name="Address",type=string,JSONPath=`.spec.address`
+//+kubebuilder:printcolumn:name="Suspended",type=boolean,JSONPath=`.status.suspended`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// WireguardPeer is the Schema for the wireguardpeers API
+type WireguardPeer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WireguardPeerSpec   `json:"spec,omitempty"`
+	Status WireguardPeerStatus `json:"status,omitempty"`
+}
+
+type WireguardPeerStatus struct {
+	// Public key generated by operator. Empty when the key is provided
+	// in .spec.publicKey
+	GeneratedPublicKey string `json:"generatedPublicKey,omitempty"`
+
+	// Whether the peer is excluded from the wireguard configuration
+	Suspended bool `json:"suspended,omitempty"`
+
+	// Traffic of the peer during the current quota period. Set only when
+	// .spec.quota is set
+	Usage *TrafficUsage `json:"usage,omitempty"`
+
+	// Conditions represent the latest available observations of the peer
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WireguardPeerList contains a list of WireguardPeer
+type WireguardPeerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WireguardPeer `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WireguardPeer{}, &WireguardPeerList{})
+}
@@ -0,0 +1,153 @@
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Wireguard resources are applied and endpoint is known
+	WireguardConditionReady = "Ready"
+)
+
+type WireguardSpec struct {
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	// +kubebuilder:validation:ExclusiveMaximum=false
+	// +optional
+
+	// Replicas defines the number of Wireguard instances. When unset, replicas
+	// are not managed by the operator, so those can be scaled by autoscaler
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// +kubebuilder:default="ClusterIP"
+
+	// Type of the service to be created
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+
+	// +kubebuilder:default={"0.0.0.0/0"}
+
+	// IP ranges routed through the tunnel by peers
+	AllowedIPs []string `json:"allowedIPs,omitempty"`
+
+	// +kubebuilder:default="192.168.254.1/24"
+
+	// Address space to use
+	Address Address `json:"address,omitempty"`
+
+	// +kubebuilder:default={servers: {"1.1.1.1"}}
+
+	// DNS configuration for peers
+	DNS DNS `json:"dns,omitempty"`
+
+	// +kubebuilder:example="example.com:51820"
+
+	// Address which going to be used in peers configuration. By default,
+	// operator will use IP address of the service, which is not always
+	// desirable (e.g. if public DNS record is attached to load balancer).
+	// If port is not set, default wireguard port is used in status
+	EndpointAddress *string `json:"endpointAddress,omitempty"`
+
+	// Deny connections to the following list of IPs
+	DropConnectionsTo []string `json:"dropConnectionsTo,omitempty"`
+
+	// Sidecar containers to run
+	Sidecars []corev1.Container `json:"sidecars,omitempty"`
+
+	// Affinity configuration
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// Annotations for the service resource
+	ServiceAnnotations map[string]string `json:"serviceAnnotations,omitempty"`
+
+	// Extra labels for all resources created
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Reference to the existing secret in the same namespace holding
+	// private key of the wireguard. When set, public key is derived from
+	// it and the keypair is never generated or overwritten by operator
+	PrivateKeyRef *corev1.SecretKeySelector `json:"privateKeyRef,omitempty"`
+
+	// Reference to the config map key in the same namespace holding go
+	// text/template used to render configuration of all peers instead of
+	// the built-in one. Can be overridden per peer
+	PeerConfigTemplateRef *corev1.ConfigMapKeySelector `json:"peerConfigTemplateRef,omitempty"`
+
+	// Pod disruption budget for the wireguard pods. Created only when
+	// there is more than one replica
+	PodDisruptionBudget *PodDisruptionBudget `json:"podDisruptionBudget,omitempty"`
+
+	// Network policy admitting only wireguard traffic to the wireguard pods
+	NetworkPolicy *NetworkPolicy `json:"networkPolicy,omitempty"`
+
+	// Metrics exporter running inside the wireguard pod
+	Metrics *Metrics `json:"metrics,omitempty"`
+
+	// Audit log of the peer connections
+	Audit *Audit `json:"audit,omitempty"`
+
+	// Readiness probe of the wireguard container. By default, pod is ready
+	// once wireguard interface is up and listening
+	ReadinessProbe *ReadinessProbe `json:"readinessProbe,omitempty"`
+
+	// Pod and service networks of the cluster added to allowed IPs of
+	// peers, so split tunnel peers reach the cluster
+	ClusterNetworks *ClusterNetworks `json:"clusterNetworks,omitempty"`
+
+	// MTU of the wireguard interface. Kernel default is used when empty
+	MTU *MTU `json:"mtu,omitempty"`
+
+	// Control of the traffic between peers. All peers can reach each
+	// other when empty
+	PeerIsolation *PeerIsolation `json:"peerIsolation,omitempty"`
+
+	// Bandwidth limits of every peer without its own, applied inside the
+	// wireguard pod
+	PeerBandwidth *Bandwidth `json:"peerBandwidth,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Endpoint",type=string,JSONPath=`.status.endpoint`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Wireguard is the Schema for the wireguards API
+type Wireguard struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WireguardSpec   `json:"spec,omitempty"`
+	Status WireguardStatus `json:"status,omitempty"`
+}
+
+type WireguardStatus struct {
+	// Public key of the wireguard
+	PublicKey string `json:"publicKey,omitempty"`
+
+	// Endpoint of the wireguard used by peers
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Resolved addresses of .spec.dns.servers
+	DNS []string `json:"dns,omitempty"`
+
+	// Discovered networks of the cluster, included in allowed IPs of peers
+	ClusterNetworks []string `json:"clusterNetworks,omitempty"`
+
+	// Conditions represent the latest available observations of the
+	// wireguard
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WireguardList contains a list of Wireguard
+type WireguardList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Wireguard `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Wireguard{}, &WireguardList{})
+}
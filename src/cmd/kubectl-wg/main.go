@@ -0,0 +1,116 @@
+// kubectl-wg is kubectl plugin for day-to-day operations with wireguard
+// peers managed by the operator
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+}
+
+// State shared between commands
+type app struct {
+	config clientcmd.ClientConfig
+	out    io.Writer
+}
+
+// Returns client and namespace from kubeconfig and flags
+func (a *app) client() (client.Client, string, error) {
+	restConfig, namespace, err := a.restConfig()
+	if err != nil {
+		return nil, "", err
+	}
+
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return c, namespace, nil
+}
+
+func (a *app) restConfig() (*rest.Config, string, error) {
+	restConfig, err := a.config.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+
+	namespace, _, err := a.config.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return restConfig, namespace, nil
+}
+
+// Returns clientset, required for executing commands in pods
+func (a *app) clientset() (*kubernetes.Clientset, *rest.Config, error) {
+	restConfig, _, err := a.restConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cs, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cs, restConfig, nil
+}
+
+func newRootCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "kubectl-wg",
+		Short:         "Manage wireguard peers provisioned by wireguard-operator",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	overrides := &clientcmd.ConfigOverrides{}
+	flags := clientcmd.RecommendedConfigOverrideFlags("")
+	clientcmd.BindOverrideFlags(overrides, cmd.PersistentFlags(), flags)
+	cmd.PersistentFlags().StringVar(&loadingRules.ExplicitPath,
+		clientcmd.RecommendedConfigPathFlag, "", "Path to the kubeconfig file")
+
+	a := &app{
+		config: clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			loadingRules, overrides),
+		out: out,
+	}
+	cmd.AddCommand(
+		newCreateCommand(a),
+		newPeersCommand(a),
+		newConfigCommand(a),
+		newQRCommand(a),
+		newRotateCommand(a),
+		newEndpointCommand(a),
+	)
+
+	return cmd
+}
+
+func main() {
+	if err := newRootCommand(os.Stdout).Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+func newCreateCommand(a *app) *cobra.Command {
+	var wireguard, address, publicKey string
+	cmd := &cobra.Command{
+		Use:   "create NAME --wireguard WIREGUARD --address ADDRESS",
+		Short: "Create a peer of the wireguard",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, namespace, err := a.client()
+			if err != nil {
+				return err
+			}
+
+			peer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      args[0],
+					Namespace: namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wireguard,
+					Address:      v1alpha1.Address(address),
+				},
+			}
+			if publicKey != "" {
+				peer.Spec.PublicKey = &publicKey
+			}
+
+			if err := c.Create(cmd.Context(), peer); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(a.out, "wireguardpeer/%s created\n", peer.GetName())
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&wireguard, "wireguard", "", "Name of the wireguard")
+	cmd.Flags().StringVar(&address, "address", "", "IP address of the peer, e.g. 192.168.254.2/32")
+	cmd.Flags().StringVar(&publicKey, "public-key", "",
+		"Public key of the peer, when private key is kept by the peer owner")
+	cobra.CheckErr(cmd.MarkFlagRequired("wireguard"))
+	cobra.CheckErr(cmd.MarkFlagRequired("address"))
+
+	return cmd
+}
+
+func newConfigCommand(a *app) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "config PEER",
+		Short: "Print or save configuration of the peer",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := a.peerConfig(cmd, args[0])
+			if err != nil {
+				return err
+			}
+
+			if output == "" {
+				_, err := a.out.Write(config)
+				return err
+			}
+
+			// config holds private key, so it must not be world-readable
+			return os.WriteFile(output, config, 0600)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "File to save configuration to")
+	return cmd
+}
+
+func newQRCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "qr PEER",
+		Short: "Show configuration of the peer as QR code for mobile clients",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := a.peerConfig(cmd, args[0])
+			if err != nil {
+				return err
+			}
+
+			qr, err := qrcode.New(string(config), qrcode.Medium)
+			if err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprint(a.out, qr.ToSmallString(false))
+			return err
+		},
+	}
+}
+
+func newPeersCommand(a *app) *cobra.Command {
+	var wireguard string
+	cmd := &cobra.Command{
+		Use:   "peers",
+		Short: "List peers with their latest handshakes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			c, namespace, err := a.client()
+			if err != nil {
+				return err
+			}
+
+			var all v1alpha1.WireguardPeerList
+			if err := c.List(ctx, &all, client.InNamespace(namespace)); err != nil {
+				return err
+			}
+
+			peers := []v1alpha1.WireguardPeer{}
+			for _, peer := range all.Items {
+				if wireguard == "" || peer.Spec.WireguardRef == wireguard {
+					peers = append(peers, peer)
+				}
+			}
+
+			// handshakes are known only to wireguard pods
+			handshakes := map[string]map[string]time.Time{}
+			for _, peer := range peers {
+				ref := peer.Spec.WireguardRef
+				if _, ok := handshakes[ref]; ok {
+					continue
+				}
+
+				hs, err := a.handshakes(ctx, c, namespace, ref)
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(),
+						"warning: cannot read handshakes of wireguard %s: %v\n",
+						ref, err)
+				}
+				handshakes[ref] = hs
+			}
+
+			w := tabwriter.NewWriter(a.out, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "NAME\tWIREGUARD\tADDRESS\tSUSPENDED\tLATEST HANDSHAKE")
+			for _, peer := range peers {
+				hs := handshakes[peer.Spec.WireguardRef]
+				fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\n",
+					peer.GetName(),
+					peer.Spec.WireguardRef,
+					peer.Spec.Address,
+					peer.Spec.Suspended,
+					formatHandshake(hs, peer.Status.PublicKey, time.Now()),
+				)
+			}
+
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&wireguard, "wireguard", "", "Show only peers of the wireguard")
+	return cmd
+}
+
+func newRotateCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rotate PEER",
+		Short: "Rotate keys of the peer",
+		Long: "Rotate keys of the peer. Secret holding the keys is deleted, " +
+			"so operator generates new keypair and renders new configuration",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			c, namespace, err := a.client()
+			if err != nil {
+				return err
+			}
+
+			key := types.NamespacedName{Name: args[0], Namespace: namespace}
+			peer := &v1alpha1.WireguardPeer{}
+			if err := c.Get(ctx, key, peer); err != nil {
+				return err
+			}
+
+			if peer.Spec.PublicKey != nil {
+				return fmt.Errorf(
+					"private key of peer %s is kept by the peer owner", key.Name)
+			}
+
+			secret := &corev1.Secret{}
+			if err := c.Get(ctx, key, secret); err != nil {
+				return err
+			}
+
+			// never deleting secrets not managed by operator
+			owner := metav1.GetControllerOf(secret)
+			if owner == nil || owner.UID != peer.GetUID() {
+				return fmt.Errorf("secret %s is not managed by peer", key.Name)
+			}
+
+			if err := c.Delete(ctx, secret); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(a.out,
+				"wireguardpeer/%s key rotation triggered, fetch new config "+
+					"once it's rendered\n", key.Name)
+			return nil
+		},
+	}
+}
+
+// Returns rendered configuration of the peer
+func (a *app) peerConfig(cmd *cobra.Command, name string) ([]byte, error) {
+	c, namespace, err := a.client()
+	if err != nil {
+		return nil, err
+	}
+
+	key := types.NamespacedName{Name: name, Namespace: namespace}
+	peer := &v1alpha1.WireguardPeer{}
+	if err := c.Get(cmd.Context(), key, peer); err != nil {
+		return nil, err
+	}
+
+	if peer.Spec.PublicKey != nil {
+		return nil, fmt.Errorf(
+			"private key of peer %s is kept by the peer owner, "+
+				"configuration is not rendered", name)
+	}
+
+	secret := &corev1.Secret{}
+	if err := c.Get(cmd.Context(), key, secret); err != nil {
+		return nil, err
+	}
+
+	config, ok := secret.Data["config"]
+	if !ok {
+		return nil, fmt.Errorf("configuration of peer %s is not rendered", name)
+	}
+
+	return config, nil
+}
+
+// Returns human readable time since the latest handshake of the peer
+func formatHandshake(handshakes map[string]time.Time, publicKey *string,
+	now time.Time) string {
+
+	if handshakes == nil || publicKey == nil {
+		return "<unknown>"
+	}
+
+	ts, ok := handshakes[*publicKey]
+	if !ok || ts.IsZero() {
+		return "never"
+	}
+
+	return fmt.Sprintf("%s ago", duration.HumanDuration(now.Sub(ts)))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatHandshake(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		description string
+		handshakes  map[string]time.Time
+		publicKey   *string
+		want        string
+	}
+
+	key := "peer-key"
+	now := time.Unix(1700000000, 0)
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		got := formatHandshake(tc.handshakes, tc.publicKey, now)
+		assert.Equal(t, tc.want, got)
+	})
+
+	spec.Entry("handshakes are not known", testCase{
+		publicKey: &key,
+		want:      "<unknown>",
+	})
+	spec.Entry("peer is not reconciled", testCase{
+		handshakes: map[string]time.Time{},
+		want:       "<unknown>",
+	})
+	spec.Entry("peer never connected", testCase{
+		handshakes: map[string]time.Time{key: {}},
+		publicKey:  &key,
+		want:       "never",
+	})
+	spec.Entry("peer connected", testCase{
+		handshakes: map[string]time.Time{key: now.Add(-3 * time.Minute)},
+		publicKey:  &key,
+		want:       "3m ago",
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/factory"
+)
+
+func newEndpointCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "endpoint WIREGUARD",
+		Short: "Show endpoint peers connect to",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, namespace, err := a.client()
+			if err != nil {
+				return err
+			}
+
+			key := types.NamespacedName{Name: args[0], Namespace: namespace}
+			wg := &v1alpha1.Wireguard{}
+			if err := c.Get(cmd.Context(), key, wg); err != nil {
+				return err
+			}
+
+			if wg.Status.Endpoint == nil {
+				return fmt.Errorf("endpoint of wireguard %s is not yet known", key.Name)
+			}
+
+			_, err = fmt.Fprintln(a.out, *wg.Status.Endpoint)
+			return err
+		},
+	}
+}
+
+// Returns latest handshakes by public key of the peer across all pods of
+// the wireguard
+func (a *app) handshakes(ctx context.Context, c client.Client,
+	namespace, name string) (map[string]time.Time, error) {
+
+	key := types.NamespacedName{Name: name, Namespace: namespace}
+	wg := &v1alpha1.Wireguard{}
+	if err := c.Get(ctx, key, wg); err != nil {
+		return nil, err
+	}
+
+	fact := factory.Wireguard{Wireguard: *wg}
+	var pods corev1.PodList
+	opts := []client.ListOption{
+		client.InNamespace(namespace),
+		client.MatchingLabels(fact.Labels()),
+	}
+	if err := c.List(ctx, &pods, opts...); err != nil {
+		return nil, err
+	}
+
+	result := map[string]time.Time{}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+
+		out, err := a.exec(ctx, pod,
+			"wg", "show", "wg0", "latest-handshakes")
+		if err != nil {
+			return nil, err
+		}
+
+		hs, err := parseHandshakes(out)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, ts := range hs {
+			if ts.After(result[key]) {
+				result[key] = ts
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Executes command in the wireguard container of the pod
+func (a *app) exec(ctx context.Context, pod corev1.Pod,
+	command ...string) (string, error) {
+
+	cs, restConfig, err := a.clientset()
+	if err != nil {
+		return "", err
+	}
+
+	req := cs.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.GetNamespace()).
+		Name(pod.GetName()).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: "wireguard",
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, clientgoscheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(restConfig, "POST", req.URL())
+	if err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+// Parses output of `wg show latest-handshakes`, which is public key and
+// unix timestamp per line. Zero timestamp means no handshake yet
+func parseHandshakes(out string) (map[string]time.Time, error) {
+	result := map[string]time.Time{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		} else if len(fields) != 2 {
+			return nil, fmt.Errorf("unexpected handshake line %q", line)
+		}
+
+		ts, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		result[fields[0]] = time.Time{}
+		if ts > 0 {
+			result[fields[0]] = time.Unix(ts, 0)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHandshakes(t *testing.T) {
+	t.Parallel()
+
+	out := "key1=\t1700000000\nkey2=\t0\n"
+	got, err := parseHandshakes(out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]time.Time{
+		"key1=": time.Unix(1700000000, 0),
+		"key2=": {},
+	}, got)
+
+	got, err = parseHandshakes("")
+	assert.Nil(t, err)
+	assert.Empty(t, got)
+
+	_, err = parseHandshakes("garbage")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,110 @@
+// wg-agent runs in the wireguard container and manages the interface: it
+// brings the interface up from the mounted wg-quick config, applies config
+// changes live, serves health and metrics endpoints and brings the
+// interface down on termination. With --install it copies itself, so init
+// container can put it into the wireguard image
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/cornbuddy/wireguard-operator/src/private/agent"
+)
+
+func main() {
+	var device, config, rules, bindAddress, install string
+	flag.StringVar(&device, "device", "wg0", "Name of the wireguard interface.")
+	flag.StringVar(&config, "config", "/etc/wireguard/wg0.conf",
+		"Path of the wg-quick config.")
+	flag.StringVar(&rules, "rules", "",
+		"Path of the rules depending on the peers, re-run on every change.")
+	flag.StringVar(&bindAddress, "bind-address", ":9587",
+		"Address of the /healthz and /metrics endpoints.")
+	flag.StringVar(&install, "install", "",
+		"Copy the binary to the given path and exit.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	if install != "" {
+		if err := installTo(install); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	log := zap.New(zap.UseFlagOptions(&opts))
+	ctx, stop := signal.NotifyContext(context.Background(),
+		syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+
+	a, err := agent.New(device, config, rules, log)
+	if err != nil {
+		log.Error(err, "Cannot create agent")
+		os.Exit(1)
+	}
+	defer a.Close()
+
+	srv := &http.Server{
+		Addr:              bindAddress,
+		Handler:           a.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Error(err, "Cannot serve health and metrics")
+			stop()
+		}
+	}()
+
+	runErr := a.Run(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Error(err, "Cannot shutdown server")
+	}
+
+	if runErr != nil {
+		log.Error(runErr, "Agent failed")
+		os.Exit(1)
+	}
+}
+
+// Copies running binary to the path
+func installTo(path string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(self)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
@@ -0,0 +1,114 @@
+// wg-audit polls the wireguard interface and writes connection events of
+// the peers as JSON lines. It runs as a sidecar of the wireguard pod, so
+// it shares network namespace with the interface
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+
+	"github.com/cornbuddy/wireguard-operator/src/private/audit"
+)
+
+func main() {
+	var device, config, output string
+	var interval, timeout time.Duration
+	var maxSize int64
+	flag.StringVar(&device, "device", "wg0", "Name of the wireguard interface.")
+	flag.StringVar(&config, "config", "/etc/wireguard/wg0.conf",
+		"Wireguard config with friendly_name comments naming the peers.")
+	flag.StringVar(&output, "output", "",
+		"File events are appended to. Stdout is used when empty.")
+	flag.Int64Var(&maxSize, "max-size", 10<<20,
+		"Size in bytes the output file is rotated at, keeping one previous "+
+			"file with .1 suffix. Never rotated when zero.")
+	flag.DurationVar(&interval, "interval", 10*time.Second,
+		"How often the interface is polled.")
+	flag.DurationVar(&timeout, "timeout", audit.DefaultTimeout,
+		"Session is finished once the latest handshake is older than this.")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, device, config, output, maxSize, interval, timeout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, device, config, output string, maxSize int64,
+	interval, timeout time.Duration) error {
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := audit.OpenRotating(output, maxSize)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	client, err := wgctrl.New()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	auditor := audit.NewAuditor()
+	auditor.Timeout = timeout
+	enc := json.NewEncoder(out)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := poll(client, auditor, enc, device, config); err != nil {
+			// interface might not be up yet, so keep polling
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func poll(client *wgctrl.Client, auditor *audit.Auditor, enc *json.Encoder,
+	device, config string) error {
+
+	// config is re-read, as mounted secret is updated in place
+	if f, err := os.Open(config); err == nil {
+		names, err := audit.ParseNames(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		auditor.Names = names
+	}
+
+	dev, err := client.Device(device)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range auditor.Observe(time.Now(), dev.Peers) {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
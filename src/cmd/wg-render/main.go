@@ -0,0 +1,122 @@
+// wg-render prints resources the operator would generate for Wireguard,
+// WireguardPeer and WireguardClient manifests, without access to the
+// cluster. Keys are stubbed, the endpoint is assumed and hostnames are not
+// looked up, so the output is meant for reviews only
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/api/v1beta1"
+	"github.com/cornbuddy/wireguard-operator/src/private/resolver"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+	utilruntime.Must(v1beta1.AddToScheme(scheme))
+}
+
+// Addresses of the hostnames given with --resolve flags. Hostnames are not
+// looked up, so output does not depend on DNS of the machine running it
+type hostsFlag resolver.Fake
+
+func (h hostsFlag) String() string {
+	var hosts []string
+	for host, addrs := range h {
+		for _, addr := range addrs {
+			hosts = append(hosts, host+"="+addr)
+		}
+	}
+
+	return strings.Join(hosts, ",")
+}
+
+func (h hostsFlag) Set(value string) error {
+	host, addr, ok := strings.Cut(value, "=")
+	if !ok || host == "" {
+		return fmt.Errorf("expected host=ip, got %q", value)
+	}
+	if net.ParseIP(addr) == nil {
+		return fmt.Errorf("invalid ip address of %s: %q", host, addr)
+	}
+
+	h[host] = append(h[host], addr)
+	return nil
+}
+
+func main() {
+	var namespace, endpoint string
+	hosts := hostsFlag{}
+	flag.StringVar(&namespace, "namespace", "default",
+		"Namespace of the resources without one.")
+	flag.StringVar(&endpoint, "endpoint", "203.0.113.1",
+		"IP address the wireguard service is assumed to get. "+
+			"Ignored when .spec.endpointAddress is set.")
+	flag.Var(hosts, "resolve",
+		"Address of the hostname used in manifests, as host=ip. Can be repeated. "+
+			"Hostnames are never looked up, so rendering fails on the ones not given.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [flags] FILE...\n\n"+
+				"Renders resources for Wireguard, WireguardPeer and WireguardClient manifests. "+
+				"Use - to read from stdin.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(namespace, endpoint, hosts, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(namespace, endpoint string, hosts hostsFlag, files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("at least one file is required")
+	}
+
+	r, err := newRenderer(namespace, endpoint, resolver.Fake(hosts))
+	if err != nil {
+		return err
+	}
+
+	var in inputs
+	for _, file := range files {
+		if err := readFile(r, file, &in); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	objs, err := r.render(in)
+	if err != nil {
+		return err
+	}
+
+	return write(os.Stdout, objs)
+}
+
+func readFile(r *renderer, file string, in *inputs) error {
+	if file == "-" {
+		return r.read(os.Stdin, in)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return r.read(f, in)
+}
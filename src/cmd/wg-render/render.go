@@ -0,0 +1,511 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/defaulting"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/config/crd"
+	"github.com/cornbuddy/wireguard-operator/src/private/factory"
+	"github.com/cornbuddy/wireguard-operator/src/private/resolver"
+	"github.com/cornbuddy/wireguard-operator/src/private/wgquick"
+)
+
+var (
+	ErrUnsupportedKind   = fmt.Errorf("unsupported kind")
+	ErrWireguardNotFound = fmt.Errorf("wireguard not found")
+	ErrTemplateNotFound  = fmt.Errorf("config template not found")
+)
+
+// Resources read from manifests
+type inputs struct {
+	wireguards []v1alpha1.Wireguard
+	peers      []v1alpha1.WireguardPeer
+	clients    []v1alpha1.WireguardClient
+	configMaps []corev1.ConfigMap
+}
+
+type renderer struct {
+	// Namespace of resources without one
+	namespace string
+	// IP address the wireguard service is assumed to get
+	endpoint string
+	resolver resolver.Resolver
+	schemas  map[schema.GroupVersionKind]*structuralschema.Structural
+}
+
+func newRenderer(namespace, endpoint string, res resolver.Resolver) (
+	*renderer, error) {
+
+	schemas, err := loadSchemas(crd.Bases)
+	if err != nil {
+		return nil, err
+	}
+
+	return &renderer{
+		namespace: namespace,
+		endpoint:  endpoint,
+		resolver:  res,
+		schemas:   schemas,
+	}, nil
+}
+
+// Returns structural schemas of every version of the custom resources, used
+// to default manifests the same way API server does
+func loadSchemas(fsys fs.FS) (
+	map[schema.GroupVersionKind]*structuralschema.Structural, error) {
+
+	files, err := fs.Glob(fsys, "bases/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[schema.GroupVersionKind]*structuralschema.Structural{}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		var def apiextensionsv1.CustomResourceDefinition
+		if err := yaml.Unmarshal(data, &def); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		for _, version := range def.Spec.Versions {
+			props := &apiextensions.JSONSchemaProps{}
+			err := apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(
+				version.Schema.OpenAPIV3Schema, props, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			structural, err := structuralschema.NewStructural(props)
+			if err != nil {
+				return nil, err
+			}
+
+			gvk := schema.GroupVersionKind{
+				Group:   def.Spec.Group,
+				Version: version.Name,
+				Kind:    def.Spec.Names.Kind,
+			}
+			result[gvk] = structural
+		}
+	}
+
+	return result, nil
+}
+
+// Reads wireguards, peers, clients and config maps holding peer config templates
+// from multi-document YAML or JSON manifest
+func (r *renderer) read(in io.Reader, result *inputs) error {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(in, 4096)
+	for {
+		var obj map[string]any
+		if err := decoder.Decode(&obj); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// empty documents, e.g. trailing separator
+		if obj == nil {
+			continue
+		}
+
+		u := &unstructured.Unstructured{Object: obj}
+		if u.GetNamespace() == "" {
+			u.SetNamespace(r.namespace)
+		}
+
+		if err := r.add(u, result); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *renderer) add(u *unstructured.Unstructured, result *inputs) error {
+	gvk := u.GroupVersionKind()
+	if structural, ok := r.schemas[gvk]; ok {
+		defaulting.Default(u.Object, structural)
+	} else if gvk != corev1.SchemeGroupVersion.WithKind("ConfigMap") {
+		return fmt.Errorf("%w: %s %s", ErrUnsupportedKind, gvk, u.GetName())
+	}
+
+	obj, err := scheme.New(gvk)
+	if err != nil {
+		return err
+	}
+
+	converter := runtime.DefaultUnstructuredConverter
+	if err := converter.FromUnstructured(u.Object, obj); err != nil {
+		return fmt.Errorf("%s %s: %w", gvk.Kind, u.GetName(), err)
+	}
+
+	// newer api versions are converted to the hub one, which is used by
+	// the operator
+	if convertible, ok := obj.(conversion.Convertible); ok {
+		var hub conversion.Hub = &v1alpha1.Wireguard{}
+		if gvk.Kind == "WireguardPeer" {
+			hub = &v1alpha1.WireguardPeer{}
+		}
+
+		if err := convertible.ConvertTo(hub); err != nil {
+			return err
+		}
+
+		obj = hub
+	}
+
+	switch o := obj.(type) {
+	case *v1alpha1.Wireguard:
+		result.wireguards = append(result.wireguards, *o)
+	case *v1alpha1.WireguardPeer:
+		result.peers = append(result.peers, *o)
+	case *v1alpha1.WireguardClient:
+		result.clients = append(result.clients, *o)
+	case *corev1.ConfigMap:
+		result.configMaps = append(result.configMaps, *o)
+	}
+
+	return nil
+}
+
+// Returns resources operator would generate for the given inputs. Keys are
+// derived from names of the resources, so output is stable between runs
+func (r *renderer) render(in inputs) ([]client.Object, error) {
+	for _, peer := range in.peers {
+		if findWireguard(in.wireguards, peer) == nil {
+			return nil, fmt.Errorf("%w: %s/%s of peer %s", ErrWireguardNotFound,
+				peer.GetNamespace(), peer.Spec.WireguardRef, peer.GetName())
+		}
+	}
+
+	var result []client.Object
+	for _, wg := range in.wireguards {
+		objs, err := r.renderWireguard(wg, in)
+		if err != nil {
+			return nil, fmt.Errorf("wireguard %s: %w", wg.GetName(), err)
+		}
+
+		result = append(result, objs...)
+	}
+
+	for _, wgClient := range in.clients {
+		objs, err := renderClient(wgClient)
+		if err != nil {
+			return nil, fmt.Errorf("client %s: %w", wgClient.GetName(), err)
+		}
+
+		result = append(result, objs...)
+	}
+
+	return result, nil
+}
+
+// Returns resources of the client. Preshared key is stubbed, when referenced
+func renderClient(wgClient v1alpha1.WireguardClient) ([]client.Object, error) {
+	fact := factory.WireguardClient{
+		Scheme:          scheme,
+		WireguardClient: wgClient,
+	}
+	if wgClient.Spec.Server.PresharedKeyRef != nil {
+		fact.PresharedKey, _ = stubKeypair("preshared", wgClient.GetNamespace(),
+			wgClient.GetName())
+	}
+
+	privateKey, publicKey := stubKeypair("client", wgClient.GetNamespace(), wgClient.GetName())
+	secret, err := fact.Secret(publicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha1.Sum(wgquick.InterfaceSection(secret.Data["config"]))
+	deploy, err := fact.Deployment(hex.EncodeToString(hash[:]))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []client.Object{secret, deploy}
+	if fact.ServiceEnabled() {
+		svc, err := fact.Service()
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, svc)
+	}
+
+	if fact.EgressEnabled() {
+		// pods are resolved in the cluster, so rules are always empty
+		egressCm, err := fact.EgressConfigMap(nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		ds, err := fact.EgressDaemonSet()
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, egressCm, ds)
+	}
+
+	return result, nil
+}
+
+func (r *renderer) renderWireguard(wg v1alpha1.Wireguard, in inputs) (
+	[]client.Object, error) {
+
+	privateKey, publicKey := stubKeypair("wireguard", wg.GetNamespace(), wg.GetName())
+	wg.Status.PublicKey = &publicKey
+
+	// nodes are unknown, so only networks set explicitly are rendered
+	if cfg := wg.Spec.ClusterNetworks; cfg != nil && cfg.Enabled {
+		cidrs := append(slices.Clone(cfg.PodCIDRs), cfg.ServiceCIDRs...)
+		networks, err := factory.AggregateNetworks(cidrs)
+		if err != nil {
+			return nil, err
+		}
+		wg.Status.ClusterNetworks = networks
+	}
+
+	peers := v1alpha1.WireguardPeerList{}
+	for _, peer := range in.peers {
+		if findWireguard([]v1alpha1.Wireguard{wg}, peer) == nil {
+			continue
+		}
+
+		// mimics reconciled peer, so it's present in server config
+		_, peerPublicKey := stubKeypair("peer", peer.GetNamespace(), peer.GetName())
+		if peer.Spec.PublicKey != nil {
+			peerPublicKey = *peer.Spec.PublicKey
+		}
+		peer.Status.PublicKey = &peerPublicKey
+		peers.Items = append(peers.Items, peer)
+	}
+
+	fact := factory.Wireguard{
+		Scheme:    scheme,
+		Wireguard: wg,
+		Peers:     peers,
+		Resolver:  r.resolver,
+	}
+
+	svc, err := fact.Service()
+	if err != nil {
+		return nil, err
+	}
+
+	cm, err := fact.ConfigMap()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := fact.Secret(publicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// the same hash as operator puts to the pod template
+	hash := sha1.Sum(wgquick.InterfaceSection(secret.Data["config"]))
+	deploy, err := fact.Deployment(hex.EncodeToString(hash[:]))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []client.Object{svc, cm, secret, deploy}
+	if fact.PodDisruptionBudgetEnabled() {
+		pdb, err := fact.PodDisruptionBudget()
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, pdb)
+	}
+
+	if fact.NetworkPolicyEnabled() {
+		np, err := fact.NetworkPolicy()
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, np)
+	}
+
+	if fact.MetricsEnabled() {
+		metricsSvc, err := fact.MetricsService()
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, metricsSvc)
+	}
+
+	for _, kind := range factory.MonitorKinds {
+		if !fact.MonitorEnabled(kind) {
+			continue
+		}
+
+		mon, err := fact.Monitor(kind)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, mon)
+	}
+
+	// assumed address is given to the service, as if it's provisioned
+	assumed := corev1.Service{
+		Spec: corev1.ServiceSpec{ClusterIP: r.endpoint},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: r.endpoint}},
+			},
+		},
+	}
+	endpoint, err := fact.ExtractEndpoint(assumed)
+	if err != nil {
+		return nil, err
+	}
+	wg.Status.Endpoint = endpoint
+
+	for _, peer := range peers.Items {
+		tmpl, err := configTemplate(in.configMaps, wg, peer)
+		if err != nil {
+			return nil, err
+		}
+
+		peerFact := factory.Peer{
+			Scheme:         scheme,
+			Peer:           peer,
+			Wireguard:      wg,
+			ConfigTemplate: tmpl,
+			Resolver:       r.resolver,
+		}
+
+		privateKey, publicKey := stubKeypair("peer", peer.GetNamespace(), peer.GetName())
+		peerSecret, err := peerFact.Secret(*endpoint, publicKey, privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("peer %s: %w", peer.GetName(), err)
+		}
+
+		result = append(result, peerSecret)
+	}
+
+	return result, nil
+}
+
+// Returns wireguard the peer belongs to, nil if it's absent
+func findWireguard(wireguards []v1alpha1.Wireguard,
+	peer v1alpha1.WireguardPeer) *v1alpha1.Wireguard {
+
+	for i, wg := range wireguards {
+		if wg.GetName() == peer.Spec.WireguardRef &&
+			wg.GetNamespace() == peer.GetNamespace() {
+			return &wireguards[i]
+		}
+	}
+
+	return nil
+}
+
+// Returns custom config template of the peer, looked up the same way as
+// operator does. Empty string means built-in template
+func configTemplate(configMaps []corev1.ConfigMap, wg v1alpha1.Wireguard,
+	peer v1alpha1.WireguardPeer) (string, error) {
+
+	ref := peer.Spec.ConfigTemplateRef
+	if ref == nil {
+		ref = wg.Spec.PeerConfigTemplateRef
+	}
+	if ref == nil {
+		return "", nil
+	}
+
+	for _, cm := range configMaps {
+		if cm.GetName() != ref.Name || cm.GetNamespace() != peer.GetNamespace() {
+			continue
+		}
+
+		if tmpl, ok := cm.Data[ref.Key]; ok {
+			return tmpl, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s/%s", ErrTemplateNotFound, ref.Name, ref.Key)
+}
+
+// Returns private and public keys derived from the resource identity. Those
+// are valid keys, but must never be used for real
+func stubKeypair(kind, namespace, name string) (string, string) {
+	seed := sha256.Sum256([]byte(kind + "/" + namespace + "/" + name))
+	key, _ := wgtypes.NewKey(seed[:])
+	return key.String(), key.PublicKey().String()
+}
+
+// Writes resources as multi-document YAML. Secrets are written with
+// string data, so rendered configs are readable in reviews
+func write(out io.Writer, objs []client.Object) error {
+	for _, obj := range objs {
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return err
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+
+		if secret, ok := obj.(*corev1.Secret); ok {
+			secret = secret.DeepCopy()
+			secret.StringData = map[string]string{}
+			for key, value := range secret.Data {
+				secret.StringData[key] = string(value)
+			}
+			secret.Data = nil
+			obj = secret
+		}
+
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return err
+		}
+
+		// fields set by API server only
+		delete(u, "status")
+		unstructured.RemoveNestedField(u, "metadata", "creationTimestamp")
+		unstructured.RemoveNestedField(u,
+			"spec", "template", "metadata", "creationTimestamp")
+
+		data, err := yaml.Marshal(u)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(out, "---\n%s", data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	"github.com/cornbuddy/wireguard-operator/src/private/resolver"
+)
+
+const (
+	wireguardManifest = `
+apiVersion: vpn.ahova.com/v1alpha1
+kind: Wireguard
+metadata:
+  name: vpn
+spec:
+  serviceType: LoadBalancer
+`
+	peerManifest = `
+apiVersion: vpn.ahova.com/v1beta1
+kind: WireguardPeer
+metadata:
+  name: peer
+spec:
+  wireguardRef: vpn
+  address: 192.168.254.2/32
+`
+)
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		description string
+		manifests   []string
+		// kinds of rendered resources, in order
+		kinds []string
+		err   error
+	}
+
+	render := func(t *testing.T, manifests ...string) ([]client.Object, error) {
+		r, err := newRenderer("default", "203.0.113.1", resolver.Fake{})
+		assert.Nil(t, err)
+
+		var in inputs
+		err = r.read(strings.NewReader(strings.Join(manifests, "---")), &in)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.render(in)
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		objs, err := render(t, tc.manifests...)
+		assert.ErrorIs(t, err, tc.err)
+
+		kinds := []string{}
+		for _, obj := range objs {
+			gvk, err := apiutil.GVKForObject(obj, scheme)
+			assert.Nil(t, err)
+			kinds = append(kinds, gvk.Kind)
+		}
+		if tc.err == nil {
+			assert.Equal(t, tc.kinds, kinds)
+		}
+	})
+
+	spec.Entry("renders wireguard and its peers", testCase{
+		manifests: []string{wireguardManifest, peerManifest},
+		kinds:     []string{"Service", "ConfigMap", "Secret", "Deployment", "Secret"},
+	})
+	spec.Entry("renders optional resources", testCase{
+		manifests: []string{wireguardManifest + `
+  replicas: 2
+  podDisruptionBudget:
+    enabled: true
+  networkPolicy:
+    enabled: true
+`},
+		kinds: []string{
+			"Service", "ConfigMap", "Secret", "Deployment",
+			"PodDisruptionBudget", "NetworkPolicy",
+		},
+	})
+	spec.Entry("renders client", testCase{
+		manifests: []string{`
+apiVersion: vpn.ahova.com/v1alpha1
+kind: WireguardClient
+metadata:
+  name: office
+spec:
+  address: 10.8.0.2/32
+  server:
+    publicKey: WsFemZZdyC+ajbvOtKA7dltaNCaPOusKmkJffjMOMmg=
+    endpoint: vpn.example.com:51820
+    allowedIPs:
+      - 10.0.0.0/8
+  ports:
+    - name: postgres
+      port: 5432
+      target: 10.0.0.5:5432
+`},
+		kinds: []string{"Secret", "Deployment", "Service"},
+	})
+	spec.Entry("renders client egress", testCase{
+		manifests: []string{`
+apiVersion: vpn.ahova.com/v1alpha1
+kind: WireguardClient
+metadata:
+  name: partner
+spec:
+  address: 10.8.0.3/32
+  server:
+    publicKey: WsFemZZdyC+ajbvOtKA7dltaNCaPOusKmkJffjMOMmg=
+    endpoint: partner.example.com:51820
+    allowedIPs:
+      - 10.20.0.0/16
+  egress:
+    podSelector:
+      matchLabels:
+        app: billing
+    destinationCIDRs:
+      - 10.20.0.0/16
+`},
+		kinds: []string{"Secret", "Deployment", "ConfigMap", "DaemonSet"},
+	})
+	spec.Entry("errors on peer without wireguard", testCase{
+		manifests: []string{peerManifest},
+		err:       ErrWireguardNotFound,
+	})
+	spec.Entry("errors on missing config template", testCase{
+		manifests: []string{wireguardManifest + `
+  peerConfigTemplateRef:
+    name: template
+    key: peer.conf
+`, peerManifest},
+		err: ErrTemplateNotFound,
+	})
+	spec.Entry("errors on unsupported kind", testCase{
+		manifests: []string{`
+apiVersion: v1
+kind: Pod
+metadata:
+  name: pod
+`},
+		err: ErrUnsupportedKind,
+	})
+
+	o.Spec("applies crd defaults", func(t *testing.T) {
+		objs, err := render(t, wireguardManifest)
+		assert.Nil(t, err)
+
+		server := string(objs[2].(*corev1.Secret).Data["config"])
+		assert.Contains(t, server, "Address = 192.168.254.1/24")
+
+		deploy := objs[3].(*appsv1.Deployment)
+		assert.Nil(t, deploy.Spec.Replicas,
+			"should leave replicas to the autoscaler")
+	})
+
+	o.Spec("renders peer with assumed endpoint and stable keys", func(t *testing.T) {
+		objs, err := render(t, wireguardManifest, peerManifest)
+		assert.Nil(t, err)
+
+		server := string(objs[2].(*corev1.Secret).Data["config"])
+		peer := objs[4].(*corev1.Secret)
+		config := string(peer.Data["config"])
+		assert.Contains(t, config, "Endpoint = 203.0.113.1:51820")
+		assert.Contains(t, server, "PublicKey = "+string(peer.Data["public-key"]))
+
+		again, err := render(t, wireguardManifest, peerManifest)
+		assert.Nil(t, err)
+		assert.Equal(t, objs, again)
+	})
+
+	o.Spec("resolves hostnames only from given addresses", func(t *testing.T) {
+		manifest := wireguardManifest + `
+  dns: dns.example.com
+`
+		_, err := render(t, manifest, peerManifest)
+		assert.NotNil(t, err, "should not look hostname up")
+
+		hosts := hostsFlag{}
+		assert.Nil(t, hosts.Set("dns.example.com=10.0.0.53"))
+		r, err := newRenderer("default", "203.0.113.1", resolver.Fake(hosts))
+		assert.Nil(t, err)
+
+		var in inputs
+		err = r.read(strings.NewReader(manifest+"---"+peerManifest), &in)
+		assert.Nil(t, err)
+		objs, err := r.render(in)
+		assert.Nil(t, err)
+
+		config := string(objs[4].(*corev1.Secret).Data["config"])
+		assert.Contains(t, config, "DNS = 10.0.0.53")
+	})
+
+	o.Spec("adds explicit cluster networks to peers", func(t *testing.T) {
+		objs, err := render(t, wireguardManifest+`
+  allowedIPs: 192.168.0.0/16
+  clusterNetworks:
+    enabled: true
+    podCIDRs:
+      - 10.244.0.0/16
+    serviceCIDRs:
+      - 10.96.0.0/12
+`, peerManifest)
+		assert.Nil(t, err)
+
+		config := string(objs[4].(*corev1.Secret).Data["config"])
+		assert.Contains(t, config,
+			"AllowedIPs = 192.168.0.0/16, 10.96.0.0/12, 10.244.0.0/16")
+	})
+
+	o.Spec("uses config template from manifests", func(t *testing.T) {
+		objs, err := render(t, wireguardManifest, peerManifest, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: template
+data:
+  peer.conf: "endpoint {{ .Endpoint }}"
+`, `
+apiVersion: vpn.ahova.com/v1alpha1
+kind: WireguardPeer
+metadata:
+  name: custom
+spec:
+  wireguardRef: vpn
+  address: 192.168.254.3/32
+  configTemplateRef:
+    name: template
+    key: peer.conf
+`)
+		assert.Nil(t, err)
+
+		secret := objs[len(objs)-1].(*corev1.Secret)
+		assert.Equal(t, "endpoint 203.0.113.1:51820", string(secret.Data["config"]))
+	})
+
+	o.Spec("writes secrets with string data", func(t *testing.T) {
+		objs, err := render(t, wireguardManifest, peerManifest)
+		assert.Nil(t, err)
+
+		buf := new(bytes.Buffer)
+		err = write(buf, objs)
+		assert.Nil(t, err)
+		assert.Contains(t, buf.String(), "stringData:\n  config: |\n    [Interface]")
+		assert.NotContains(t, buf.String(), "creationTimestamp")
+		assert.Equal(t, len(objs), strings.Count(buf.String(), "---\n"))
+	})
+}
+
+func TestHostsFlag(t *testing.T) {
+	t.Parallel()
+
+	hosts := hostsFlag{}
+	assert.Nil(t, hosts.Set("dns.example.com=10.0.0.53"))
+	assert.Nil(t, hosts.Set("dns.example.com=fd00::53"))
+	assert.Equal(t, hostsFlag{
+		"dns.example.com": {"10.0.0.53", "fd00::53"},
+	}, hosts)
+
+	assert.NotNil(t, hosts.Set("dns.example.com"), "should require address")
+	assert.NotNil(t, hosts.Set("=10.0.0.53"), "should require host")
+	assert.NotNil(t, hosts.Set("dns.example.com=kek"), "should require ip")
+}
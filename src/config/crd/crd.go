@@ -0,0 +1,8 @@
+// Package crd embeds generated custom resource definitions, so tools can
+// apply the same defaults as API server does
+package crd
+
+import "embed"
+
+//go:embed bases/*.yaml
+var Bases embed.FS
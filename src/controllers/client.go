@@ -0,0 +1,503 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	wgtypes "golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/factory"
+	"github.com/cornbuddy/wireguard-operator/src/private/wgquick"
+)
+
+const reasonPodPending = "PodPending"
+
+var errNoEgressTable = fmt.Errorf("no free egress routing table")
+
+// WireguardClientReconciler reconciles a WireguardClient object
+type WireguardClientReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// Image of the operator, which pods install the agent from
+	OperatorImage string
+}
+
+//+kubebuilder:rbac:groups=vpn.ahova.com,resources=wireguardclients,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=vpn.ahova.com,resources=wireguardclients/status,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+
+func (r *WireguardClientReconciler) Reconcile(
+	ctx context.Context, req ctrl.Request) (
+	ctrl.Result, error) {
+
+	empty := ctrl.Result{}
+	requeue := ctrl.Result{Requeue: true}
+	log := log.FromContext(ctx).WithName("client")
+
+	// WireguardClient
+	wgClient := &v1alpha1.WireguardClient{}
+	err := r.Get(ctx, req.NamespacedName, wgClient)
+	if apierrors.IsNotFound(err) {
+		log.Info("Must have been deleted, reconcilation is finished")
+		return empty, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get client")
+		return empty, err
+	}
+	log.Info("Successfully read client from cluster")
+
+	presharedKey, err := r.getPresharedKey(ctx, wgClient)
+	if err != nil {
+		log.Error(err, "Cannot read preshared key")
+		return empty, err
+	}
+
+	fact := factory.WireguardClient{
+		Scheme:          r.Scheme,
+		WireguardClient: *wgClient,
+		PresharedKey:    presharedKey,
+		OperatorImage:   r.OperatorImage,
+	}
+
+	// Secret
+	privateKey, publicKey, err := r.getKeypair(ctx, wgClient)
+	if err != nil {
+		log.Error(err, "Cannot get keypair")
+		return empty, err
+	}
+	log.Info("Keypair is set")
+
+	secret, err := fact.Secret(publicKey, privateKey)
+	if err != nil {
+		log.Error(err, "Cannot generate secret")
+		return empty, err
+	}
+
+	if applied, err := apply(ctx, r.Client, secret); err != nil {
+		log.Error(err, "Cannot apply secret")
+		return empty, err
+	} else if applied {
+		log.Info("Secret applied successfully")
+		r.Recorder.Event(wgClient, corev1.EventTypeNormal,
+			reasonSecretUpdated, "Secret is applied")
+		return requeue, nil
+	}
+	log.Info("Secret is up to date")
+
+	// Deployment
+	deploy, err := fact.Deployment(
+		makeHash(wgquick.InterfaceSection(secret.Data["config"])))
+	if err != nil {
+		log.Error(err, "Cannot generate deployment")
+		return empty, err
+	}
+
+	if applied, err := apply(ctx, r.Client, deploy); err != nil {
+		log.Error(err, "Cannot apply deployment")
+		return empty, err
+	} else if applied {
+		log.Info("Deployment applied successfully")
+		r.Recorder.Event(wgClient, corev1.EventTypeNormal,
+			reasonDeploymentUpdated, "Deployment is applied")
+		return requeue, nil
+	}
+	log.Info("Deployment is up to date")
+
+	// Service
+	svc, err := fact.Service()
+	if err != nil {
+		log.Error(err, "Cannot generate service")
+		return empty, err
+	}
+
+	if applied, err := applyIf(ctx, r.Client, svc, fact.ServiceEnabled()); err != nil {
+		log.Error(err, "Cannot apply service")
+		return empty, err
+	} else if applied {
+		log.Info("Service applied successfully")
+		return requeue, nil
+	}
+	log.Info("Service is up to date")
+
+	// pods are watched, so no need to requeue while pending
+	gateway, err := r.getGateway(ctx, wgClient)
+	if err != nil {
+		log.Error(err, "Cannot list client pods")
+		return empty, err
+	}
+
+	// Egress
+	table, err := r.getEgressTable(ctx, wgClient)
+	if err != nil {
+		log.Error(err, "Cannot allocate egress routing table")
+		return empty, err
+	}
+
+	if !equalPtr(table, wgClient.Status.EgressTable) {
+		wgClient.Status.EgressTable = table
+		if err := r.Status().Update(ctx, wgClient); err != nil {
+			log.Error(err, "Cannot record egress routing table")
+			return empty, err
+		}
+		log.Info("Egress routing table is allocated", "table", table)
+		return requeue, nil
+	}
+
+	sources, err := r.getEgressSources(ctx, wgClient)
+	if err != nil {
+		log.Error(err, "Cannot list egress pods")
+		return empty, err
+	}
+
+	egressCm, err := fact.EgressConfigMap(gateway, sources)
+	if err != nil {
+		log.Error(err, "Cannot generate egress configmap")
+		return empty, err
+	}
+
+	if applied, err := applyIf(ctx, r.Client, egressCm, fact.EgressEnabled()); err != nil {
+		log.Error(err, "Cannot apply egress configmap")
+		return empty, err
+	} else if applied {
+		log.Info("Egress configmap applied successfully")
+		return requeue, nil
+	}
+	log.Info("Egress configmap is up to date")
+
+	ds, err := fact.EgressDaemonSet()
+	if err != nil {
+		log.Error(err, "Cannot generate egress daemonset")
+		return empty, err
+	}
+
+	if applied, err := applyIf(ctx, r.Client, ds, fact.EgressEnabled()); err != nil {
+		log.Error(err, "Cannot apply egress daemonset")
+		return empty, err
+	} else if applied {
+		log.Info("Egress daemonset applied successfully")
+		return requeue, nil
+	}
+	log.Info("Egress daemonset is up to date")
+
+	// Status
+
+	if err := r.Get(ctx, req.NamespacedName, wgClient); err != nil {
+		log.Error(err, "Failed to refetch client")
+		return empty, err
+	}
+
+	condition := metav1.Condition{
+		Type:               v1alpha1.ClientConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonReconciled,
+		ObservedGeneration: wgClient.GetGeneration(),
+	}
+	if gateway == nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonPodPending
+		condition.Message = "Client pod is not ready yet"
+	}
+
+	wgClient.Status.PublicKey = &publicKey
+	wgClient.Status.Gateway = gateway
+	meta.SetStatusCondition(&wgClient.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, wgClient); err != nil {
+		log.Error(err, "Cannot update status")
+		return empty, err
+	}
+	log.Info("Status is updated, reconcilation is finished")
+
+	return empty, nil
+}
+
+func (r *WireguardClientReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.WireguardClient{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&appsv1.DaemonSet{}).
+		Watches(
+			&corev1.Pod{},
+			handler.EnqueueRequestsFromMapFunc(r.findClientForPod),
+		).
+		Watches(
+			&corev1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(r.findEgressClients),
+		).
+		Complete(r)
+}
+
+// Returns keypair from the managed secret, generating new one when secret
+// does not exist yet
+func (r *WireguardClientReconciler) getKeypair(
+	ctx context.Context, wgClient *v1alpha1.WireguardClient) (
+	string, string, error) {
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(wgClient), secret)
+	if apierrors.IsNotFound(err) {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return "", "", err
+		}
+
+		r.Recorder.Event(wgClient, corev1.EventTypeNormal,
+			reasonKeypairGenerated, "New keypair is generated")
+		return key.String(), key.PublicKey().String(), nil
+	} else if err != nil {
+		return "", "", err
+	}
+
+	privateKey := string(secret.Data["private-key"])
+	publicKey := string(secret.Data["public-key"])
+	return privateKey, publicKey, nil
+}
+
+// Returns preshared key of the server, empty if not referenced
+func (r *WireguardClientReconciler) getPresharedKey(
+	ctx context.Context, wgClient *v1alpha1.WireguardClient) (string, error) {
+
+	ref := wgClient.Spec.Server.PresharedKeyRef
+	if ref == nil {
+		return "", nil
+	}
+
+	key := types.NamespacedName{
+		Name:      ref.Name,
+		Namespace: wgClient.GetNamespace(),
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, key, secret); err != nil {
+		return "", err
+	}
+
+	data, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", ref.Name, ref.Key)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Returns IP address of the ready client pod, nil if there is none
+func (r *WireguardClientReconciler) getGateway(
+	ctx context.Context, wgClient *v1alpha1.WireguardClient) (*string, error) {
+
+	var pods corev1.PodList
+	opts := []client.ListOption{
+		client.InNamespace(wgClient.GetNamespace()),
+		client.MatchingLabels{factory.ClientLabel: wgClient.GetName()},
+	}
+	if err := r.List(ctx, &pods, opts...); err != nil {
+		return nil, err
+	}
+
+	for _, pod := range pods.Items {
+		if pod.GetDeletionTimestamp() != nil || pod.Status.PodIP == "" {
+			continue
+		}
+
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				return &pod.Status.PodIP, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// Returns IP addresses of the pods selected by egress of the client. Pods
+// in host network are skipped, otherwise traffic of the whole node would
+// be routed
+func (r *WireguardClientReconciler) getEgressSources(
+	ctx context.Context, wgClient *v1alpha1.WireguardClient) ([]string, error) {
+
+	egress := wgClient.Spec.Egress
+	if egress == nil {
+		return nil, nil
+	}
+
+	namespaces, err := r.getEgressNamespaces(ctx, wgClient)
+	if err != nil {
+		return nil, err
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&egress.PodSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []string{}
+	for _, ns := range namespaces {
+		var pods corev1.PodList
+		opts := []client.ListOption{
+			client.InNamespace(ns),
+			client.MatchingLabelsSelector{Selector: selector},
+		}
+		if err := r.List(ctx, &pods, opts...); err != nil {
+			return nil, err
+		}
+
+		for _, pod := range pods.Items {
+			if pod.GetDeletionTimestamp() != nil || pod.Status.PodIP == "" {
+				continue
+			} else if pod.Spec.HostNetwork {
+				continue
+			} else if _, ok := pod.GetLabels()[factory.ClientLabel]; ok {
+				continue
+			}
+
+			sources = append(sources, pod.Status.PodIP)
+		}
+	}
+
+	return sources, nil
+}
+
+// Returns routing table of the egress. Table recorded in status is kept
+// unless another client holds it too, in which case the client created
+// first keeps it, and the lowest free table is allocated otherwise
+func (r *WireguardClientReconciler) getEgressTable(
+	ctx context.Context, wgClient *v1alpha1.WireguardClient) (*int32, error) {
+
+	if wgClient.Spec.Egress == nil {
+		return nil, nil
+	}
+
+	var clients v1alpha1.WireguardClientList
+	if err := r.List(ctx, &clients); err != nil {
+		return nil, err
+	}
+
+	current := wgClient.Status.EgressTable
+	taken := map[int32]bool{}
+	for _, other := range clients.Items {
+		table := other.Status.EgressTable
+		if other.GetUID() == wgClient.GetUID() || table == nil {
+			continue
+		}
+
+		taken[*table] = true
+		if current != nil && *current == *table && createdBefore(&other, wgClient) {
+			current = nil
+		}
+	}
+
+	if current != nil {
+		return current, nil
+	}
+
+	for table := int32(factory.MinEgressTable); table <= factory.MaxEgressTable; table++ {
+		if !taken[table] {
+			return &table, nil
+		}
+	}
+
+	return nil, errNoEgressTable
+}
+
+// Returns whether the first object was created before the second one. Ties
+// are broken by uid, so exactly one of two objects is the first
+func createdBefore(a, b client.Object) bool {
+	aTime, bTime := a.GetCreationTimestamp(), b.GetCreationTimestamp()
+	if !aTime.Equal(&bTime) {
+		return aTime.Before(&bTime)
+	}
+
+	return a.GetUID() < b.GetUID()
+}
+
+// Returns namespaces selected by egress of the client
+func (r *WireguardClientReconciler) getEgressNamespaces(
+	ctx context.Context, wgClient *v1alpha1.WireguardClient) ([]string, error) {
+
+	nsSelector := wgClient.Spec.Egress.NamespaceSelector
+	if nsSelector == nil {
+		return []string{wgClient.GetNamespace()}, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(nsSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	var namespaces corev1.NamespaceList
+	opts := client.MatchingLabelsSelector{Selector: selector}
+	if err := r.List(ctx, &namespaces, opts); err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, ns := range namespaces.Items {
+		result = append(result, ns.GetName())
+	}
+
+	return result, nil
+}
+
+// Returns client owning the pod, or clients which egress might select the
+// pod. Selectors are evaluated during reconcilation
+func (r *WireguardClientReconciler) findClientForPod(
+	ctx context.Context, pod client.Object) []reconcile.Request {
+
+	if name, ok := pod.GetLabels()[factory.ClientLabel]; ok {
+		return []reconcile.Request{{
+			NamespacedName: types.NamespacedName{
+				Name:      name,
+				Namespace: pod.GetNamespace(),
+			},
+		}}
+	}
+
+	return r.findEgressClients(ctx, pod)
+}
+
+// Returns all clients with egress enabled
+func (r *WireguardClientReconciler) findEgressClients(
+	ctx context.Context, _ client.Object) []reconcile.Request {
+
+	var clients v1alpha1.WireguardClientList
+	if err := r.List(ctx, &clients); err != nil {
+		log.FromContext(ctx).Error(err, "Cannot list clients")
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, wgClient := range clients.Items {
+		if wgClient.Spec.Egress == nil {
+			continue
+		}
+
+		requests = append(requests, reconcile.Request{
+			NamespacedName: client.ObjectKeyFromObject(&wgClient),
+		})
+	}
+
+	return requests
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
+)
+
+func TestWireguardClient(t *testing.T) {
+	t.Parallel()
+
+	clientDsl := dsl.Dsl{
+		K8sClient: k8sClient,
+		Reconciler: &WireguardClientReconciler{
+			Client:   k8sClient,
+			Scheme:   k8sClient.Scheme(),
+			Recorder: &record.FakeRecorder{},
+		},
+	}
+
+	makeSpec := func(ports ...v1alpha1.ClientPort) v1alpha1.WireguardClientSpec {
+		return v1alpha1.WireguardClientSpec{
+			Address: "10.8.0.2/32",
+			Server: v1alpha1.WireguardServer{
+				PublicKey:  "WsFemZZdyC+ajbvOtKA7dltaNCaPOusKmkJffjMOMmg=",
+				Endpoint:   "vpn.example.com:51820",
+				AllowedIPs: []string{"10.0.0.0/8"},
+			},
+			Ports: ports,
+		}
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	o.Spec("should create resources", func(t *testing.T) {
+		wgClient := dsl.GenerateClient(makeSpec(), v1alpha1.WireguardClientStatus{})
+		err := clientDsl.Apply(ctx, &wgClient)
+		assert.Nil(t, err)
+
+		key := types.NamespacedName{
+			Name:      wgClient.GetName(),
+			Namespace: wgClient.GetNamespace(),
+		}
+		secret := &corev1.Secret{}
+		err = k8sClient.Get(ctx, key, secret)
+		assert.Nil(t, err)
+		assert.Contains(t, string(secret.Data["config"]),
+			"Endpoint = vpn.example.com:51820")
+
+		deploy := &appsv1.Deployment{}
+		err = k8sClient.Get(ctx, key, deploy)
+		assert.Nil(t, err)
+
+		// service is created only when ports are exposed
+		err = k8sClient.Get(ctx, key, &corev1.Service{})
+		assert.NotNil(t, err)
+
+		assert.NotNil(t, wgClient.Status.PublicKey)
+		assert.Equal(t, string(secret.Data["public-key"]), *wgClient.Status.PublicKey)
+		cond := meta.FindStatusCondition(wgClient.Status.Conditions,
+			v1alpha1.ClientConditionReady)
+		assert.NotNil(t, cond)
+	})
+
+	o.Spec("should expose ports", func(t *testing.T) {
+		wgClient := dsl.GenerateClient(makeSpec(v1alpha1.ClientPort{
+			Name:     "postgres",
+			Port:     5432,
+			Protocol: corev1.ProtocolTCP,
+			Target:   "10.0.0.5:5432",
+		}), v1alpha1.WireguardClientStatus{})
+		err := clientDsl.Apply(ctx, &wgClient)
+		assert.Nil(t, err)
+
+		key := types.NamespacedName{
+			Name:      wgClient.GetName(),
+			Namespace: wgClient.GetNamespace(),
+		}
+		svc := &corev1.Service{}
+		err = k8sClient.Get(ctx, key, svc)
+		assert.Nil(t, err)
+		assert.Equal(t, int32(5432), svc.Spec.Ports[0].Port)
+	})
+
+	o.Spec("should route egress through the client", func(t *testing.T) {
+		spec := makeSpec()
+		spec.Egress = &v1alpha1.Egress{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "billing"},
+			},
+			DestinationCIDRs: []string{"10.20.0.0/16"},
+		}
+		wgClient := dsl.GenerateClient(spec, v1alpha1.WireguardClientStatus{})
+		err := clientDsl.Apply(ctx, &wgClient)
+		assert.Nil(t, err)
+
+		key := types.NamespacedName{
+			Name:      wgClient.GetName() + "-egress",
+			Namespace: wgClient.GetNamespace(),
+		}
+		cm := &corev1.ConfigMap{}
+		err = k8sClient.Get(ctx, key, cm)
+		assert.Nil(t, err)
+		assert.Contains(t, cm.Data, "rules")
+
+		ds := &appsv1.DaemonSet{}
+		err = k8sClient.Get(ctx, key, ds)
+		assert.Nil(t, err)
+		assert.True(t, ds.Spec.Template.Spec.HostNetwork)
+	})
+	o.Spec("should allocate distinct egress routing tables", func(t *testing.T) {
+		spec := makeSpec()
+		spec.Egress = &v1alpha1.Egress{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "reporting"},
+			},
+			DestinationCIDRs: []string{"10.30.0.0/16"},
+		}
+		first := dsl.GenerateClient(spec, v1alpha1.WireguardClientStatus{})
+		err := clientDsl.Apply(ctx, &first)
+		assert.Nil(t, err)
+
+		second := dsl.GenerateClient(spec, v1alpha1.WireguardClientStatus{})
+		err = clientDsl.Apply(ctx, &second)
+		assert.Nil(t, err)
+
+		assert.NotNil(t, first.Status.EgressTable)
+		assert.NotNil(t, second.Status.EgressTable)
+		assert.NotEqual(t, *first.Status.EgressTable, *second.Status.EgressTable)
+	})
+}
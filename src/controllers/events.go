@@ -0,0 +1,17 @@
+package controllers
+
+// Reasons of the events emitted by reconcilers. Users alert on them, so
+// those must not be changed
+const (
+	reasonKeypairGenerated     = "KeypairGenerated"
+	reasonKeyRotated           = "KeyRotated"
+	reasonSecretUpdated        = "SecretUpdated"
+	reasonServiceUpdated       = "ServiceUpdated"
+	reasonDeploymentUpdated    = "DeploymentUpdated"
+	reasonEndpointDiscovered   = "EndpointDiscovered"
+	reasonDNSResolutionFailed  = "DNSResolutionFailed"
+	reasonWireguardNotFound    = "WireguardNotFound"
+	reasonConfigDownloaded     = "ConfigDownloaded"
+	reasonConfigDownloadDenied = "ConfigDownloadDenied"
+	reasonQuotaExceeded        = "QuotaExceeded"
+)
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/resolver"
+	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
+)
+
+// Returns reasons of the events recorded so far
+func recordedReasons(recorder *record.FakeRecorder) []string {
+	reasons := []string{}
+	for {
+		select {
+		case event := <-recorder.Events:
+			// event is formatted as "<type> <reason> <message>"
+			reasons = append(reasons, strings.Fields(event)[1])
+		default:
+			return reasons
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	o.Spec("wireguard milestones", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(100)
+		wgDsl := dsl.Dsl{
+			K8sClient: k8sClient,
+			Reconciler: &WireguardReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+				Resolver: resolver.NewCached(resolver.Net{}, time.Minute),
+			},
+		}
+
+		wg := dsl.GenerateWireguard(
+			v1alpha1.WireguardSpec{},
+			v1alpha1.WireguardStatus{},
+		)
+		err := wgDsl.Apply(ctx, &wg)
+		assert.Nil(t, err)
+
+		reasons := recordedReasons(recorder)
+		assert.Contains(t, reasons, reasonKeypairGenerated)
+		assert.Contains(t, reasons, reasonServiceUpdated)
+		assert.Contains(t, reasons, reasonSecretUpdated)
+		assert.Contains(t, reasons, reasonDeploymentUpdated)
+		assert.Contains(t, reasons, reasonEndpointDiscovered)
+	})
+
+	o.Spec("dns resolution failure", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(100)
+		wgDsl := dsl.Dsl{
+			K8sClient: k8sClient,
+			Reconciler: &WireguardReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+				Resolver: resolver.Fake{},
+			},
+		}
+
+		wg := dsl.GenerateWireguard(
+			v1alpha1.WireguardSpec{DNS: "unknown.dns.svc"},
+			v1alpha1.WireguardStatus{},
+		)
+		err := wgDsl.Apply(ctx, &wg)
+		assert.NotNil(t, err)
+
+		reasons := recordedReasons(recorder)
+		assert.Contains(t, reasons, reasonDNSResolutionFailed)
+	})
+
+	o.Spec("missing parent wireguard", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(100)
+		peerDsl := dsl.Dsl{
+			K8sClient: k8sClient,
+			Reconciler: &WireguardPeerReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			},
+		}
+
+		peer := dsl.GeneratePeer(
+			v1alpha1.WireguardPeerSpec{WireguardRef: "does-not-exist"},
+			v1alpha1.WireguardPeerStatus{},
+		)
+		err := peerDsl.Apply(ctx, &peer)
+		assert.NotNil(t, err)
+
+		reasons := recordedReasons(recorder)
+		assert.Contains(t, reasons, reasonWireguardNotFound)
+	})
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+const metricsNamespace = "wireguard_operator"
+
+// results of the apply
+const (
+	resultUnchanged = "unchanged"
+	resultChanged   = "changed"
+	resultError     = "error"
+)
+
+var (
+	applyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "apply_duration_seconds",
+		Help: "Duration of applying resources managed by wireguard " +
+			"reconciler, by phase and result",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"phase", "result"})
+
+	endpointWaits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "endpoint_not_set_total",
+		Help: "Number of reconciliations requeued because endpoint of " +
+			"the wireguard is not yet known",
+	}, []string{"namespace", "wireguard"})
+
+	renderFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "render_failures_total",
+		Help:      "Number of failures to render desired resources",
+	}, []string{"resource"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(applyDuration, endpointWaits, renderFailures)
+}
+
+// applies resource of the given reconciliation phase when wanted,
+// otherwise deletes it. records duration and result of the apply
+func applyPhase(ctx context.Context, c client.Client, phase string,
+	desired client.Object, wanted bool) (bool, error) {
+
+	start := time.Now()
+	changed, err := applyIf(ctx, c, desired, wanted)
+
+	result := resultUnchanged
+	if err != nil {
+		result = resultError
+	} else if changed {
+		result = resultChanged
+	}
+	applyDuration.WithLabelValues(phase, result).
+		Observe(time.Since(start).Seconds())
+
+	return changed, err
+}
+
+var (
+	wireguardsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "wireguards"),
+		"Number of wireguards",
+		[]string{"namespace"}, nil,
+	)
+	peersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "peers"),
+		"Number of wireguard peers",
+		[]string{"namespace"}, nil,
+	)
+	peersPendingDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "peers_pending"),
+		"Number of wireguard peers with config not rendered for the "+
+			"latest generation",
+		[]string{"namespace"}, nil,
+	)
+	keyAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "key_age_seconds"),
+		"Age of the secret holding private key",
+		[]string{"namespace", "kind", "name"}, nil,
+	)
+)
+
+// Collector exposes state of the wireguards and peers. It reads from the
+// manager cache on scrape, so deleted resources disappear from metrics
+type Collector struct {
+	client.Reader
+	// Timeout of reading resources on scrape
+	Timeout time.Duration
+	now     func() time.Time
+}
+
+func NewCollector(reader client.Reader) *Collector {
+	return &Collector{
+		Reader:  reader,
+		Timeout: 10 * time.Second,
+		now:     time.Now,
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- wireguardsDesc
+	ch <- peersDesc
+	ch <- peersPendingDesc
+	ch <- keyAgeDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	var wireguards v1alpha1.WireguardList
+	if err := c.List(ctx, &wireguards); err != nil {
+		ch <- prometheus.NewInvalidMetric(wireguardsDesc, err)
+		return
+	}
+
+	var peers v1alpha1.WireguardPeerList
+	if err := c.List(ctx, &peers); err != nil {
+		ch <- prometheus.NewInvalidMetric(peersDesc, err)
+		return
+	}
+
+	var secrets corev1.SecretList
+	if err := c.List(ctx, &secrets); err != nil {
+		ch <- prometheus.NewInvalidMetric(keyAgeDesc, err)
+		return
+	}
+
+	created := map[types.NamespacedName]metav1.Time{}
+	for _, secret := range secrets.Items {
+		created[client.ObjectKeyFromObject(&secret)] = secret.CreationTimestamp
+	}
+
+	wireguardsByNs := map[string]int{}
+	for _, wg := range wireguards.Items {
+		wireguardsByNs[wg.GetNamespace()]++
+
+		// referenced secret holds the key if it exists, see getKeypair
+		key := client.ObjectKeyFromObject(&wg)
+		if ref := wg.Spec.PrivateKeyRef; ref != nil {
+			refKey := types.NamespacedName{
+				Name:      ref.Name,
+				Namespace: wg.GetNamespace(),
+			}
+			if _, ok := created[refKey]; ok {
+				key = refKey
+			}
+		}
+		c.collectKeyAge(ch, created, key, "Wireguard", wg.GetName())
+	}
+
+	peersByNs := map[string]int{}
+	pendingByNs := map[string]int{}
+	for _, peer := range peers.Items {
+		ns := peer.GetNamespace()
+		peersByNs[ns]++
+
+		cond := meta.FindStatusCondition(
+			peer.Status.Conditions,
+			v1alpha1.PeerConditionConfigRendered,
+		)
+		if cond == nil || cond.Status != metav1.ConditionTrue ||
+			cond.ObservedGeneration != peer.GetGeneration() {
+			pendingByNs[ns]++
+		}
+
+		// private key of such peer is not known to operator
+		if peer.Spec.PublicKey != nil {
+			continue
+		}
+		key := client.ObjectKeyFromObject(&peer)
+		c.collectKeyAge(ch, created, key, "WireguardPeer", peer.GetName())
+	}
+
+	for ns, count := range wireguardsByNs {
+		ch <- prometheus.MustNewConstMetric(
+			wireguardsDesc, prometheus.GaugeValue, float64(count), ns)
+	}
+	for ns, count := range peersByNs {
+		ch <- prometheus.MustNewConstMetric(
+			peersDesc, prometheus.GaugeValue, float64(count), ns)
+		ch <- prometheus.MustNewConstMetric(
+			peersPendingDesc, prometheus.GaugeValue,
+			float64(pendingByNs[ns]), ns)
+	}
+}
+
+func (c *Collector) collectKeyAge(ch chan<- prometheus.Metric,
+	created map[types.NamespacedName]metav1.Time,
+	key types.NamespacedName, kind, name string) {
+
+	// somehow expected: resource is not yet reconciled
+	ts, ok := created[key]
+	if !ok {
+		return
+	}
+
+	age := c.now().Sub(ts.Time).Seconds()
+	ch <- prometheus.MustNewConstMetric(
+		keyAgeDesc, prometheus.GaugeValue, age, key.Namespace, kind, name)
+}
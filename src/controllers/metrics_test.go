@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	wg := dsl.GenerateWireguard(
+		v1alpha1.WireguardSpec{},
+		v1alpha1.WireguardStatus{},
+	)
+	err := wgDsl.Apply(ctx, &wg)
+	assert.Nil(t, err)
+
+	peer := dsl.GeneratePeer(
+		v1alpha1.WireguardPeerSpec{WireguardRef: wg.GetName()},
+		v1alpha1.WireguardPeerStatus{},
+	)
+	err = peerDsl.Apply(ctx, &peer)
+	assert.Nil(t, err)
+
+	collector := NewCollector(k8sClient)
+	for _, name := range []string{
+		"wireguard_operator_wireguards",
+		"wireguard_operator_peers",
+		"wireguard_operator_peers_pending",
+	} {
+		count := testutil.CollectAndCount(collector, name)
+		assert.NotZero(t, count, "should collect %s", name)
+	}
+
+	// both wireguard and peer have managed keys
+	count := testutil.CollectAndCount(collector, "wireguard_operator_key_age_seconds")
+	assert.GreaterOrEqual(t, count, 2)
+
+	count = testutil.CollectAndCount(applyDuration)
+	assert.NotZero(t, count, "should observe apply durations")
+}
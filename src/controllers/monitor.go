@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/factory"
+)
+
+// ManagerMonitor creates pod monitor for the metrics endpoint of the
+// operator itself once manager is started
+type ManagerMonitor struct {
+	client.Client
+	// Namespace operator is running in
+	Namespace string
+	// Labels of the operator pods
+	Selector map[string]string
+}
+
+func (m *ManagerMonitor) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("manager-monitor")
+
+	gvk := factory.MonitorGVK(v1alpha1.PodMonitor)
+	ok, err := installed(m.Client, gvk)
+	if err != nil {
+		log.Error(err, "Cannot discover pod monitor kind")
+		return nil
+	} else if !ok {
+		log.Info("Prometheus operator is not installed, skipping")
+		return nil
+	}
+
+	mon, err := factory.ManagerMonitor(m.Namespace, m.Selector)
+	if err != nil {
+		log.Error(err, "Cannot generate pod monitor")
+		return nil
+	}
+
+	// monitoring is optional, so failure should not stop the operator
+	if _, err := apply(ctx, m.Client, mon); err != nil {
+		log.Error(err, "Cannot apply pod monitor")
+		return nil
+	}
+	log.Info("Pod monitor applied successfully")
+
+	return nil
+}
@@ -2,26 +2,46 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	wgtypes "golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
 	"github.com/cornbuddy/wireguard-operator/src/private/factory"
+	"github.com/cornbuddy/wireguard-operator/src/private/resolver"
 )
 
+const (
+	reasonRendered         = "Rendered"
+	reasonTemplateNotFound = "TemplateNotFound"
+	reasonTemplateInvalid  = "TemplateInvalid"
+)
+
+var errTemplateKeyNotFound = fmt.Errorf("config map has no template key")
+
 // WireguardPeerReconciler reconciles a WireguardPeer object
 type WireguardPeerReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// Resolver for DNS server hostnames
+	Resolver resolver.Resolver
+	// How often DNS server hostnames are re-resolved
+	DNSRefreshInterval time.Duration
 }
 
 //+kubebuilder:rbac:groups=vpn.ahova.com,resources=wireguardpeers,verbs=get;list;watch;create;update;patch;delete
@@ -31,6 +51,7 @@ type WireguardPeerReconciler struct {
 //+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
 
 func (r *WireguardPeerReconciler) Reconcile(
 	ctx context.Context, req ctrl.Request) (
@@ -60,6 +81,11 @@ func (r *WireguardPeerReconciler) Reconcile(
 	}
 	if err := r.Get(ctx, wgKey, wireguard); err != nil {
 		log.Error(err, "Cannot retrieve parent wireguard resource")
+		if apierrors.IsNotFound(err) {
+			r.Recorder.Eventf(peer, v1.EventTypeWarning,
+				reasonWireguardNotFound, "Wireguard %s is not found",
+				peer.Spec.WireguardRef)
+		}
 		return empty, err
 	}
 	log.Info("Retrieved parent wireguard resource, moving on...")
@@ -73,10 +99,29 @@ func (r *WireguardPeerReconciler) Reconcile(
 		return requeue, nil
 	}
 
+	// Config template
+	tmpl, err := r.getConfigTemplate(ctx, wireguard, peer)
+	if apierrors.IsNotFound(err) || errors.Is(err, errTemplateKeyNotFound) {
+		log.Info("Config template is not found", "reason", err.Error())
+		r.Recorder.Event(peer, v1.EventTypeWarning,
+			reasonTemplateNotFound, err.Error())
+		return empty, r.setCondition(ctx, req.NamespacedName, metav1.Condition{
+			Type:    v1alpha1.PeerConditionConfigRendered,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonTemplateNotFound,
+			Message: err.Error(),
+		})
+	} else if err != nil {
+		log.Error(err, "Cannot fetch config template")
+		return empty, err
+	}
+
 	fact := factory.Peer{
-		Scheme:    r.Scheme,
-		Wireguard: *wireguard,
-		Peer:      *peer,
+		Scheme:         r.Scheme,
+		Wireguard:      *wireguard,
+		Peer:           *peer,
+		ConfigTemplate: tmpl,
+		Resolver:       r.Resolver,
 	}
 
 	// Secret
@@ -97,6 +142,10 @@ func (r *WireguardPeerReconciler) Reconcile(
 
 		privateKey = key.String()
 		publicKey = key.PublicKey().String()
+		if peer.Spec.PublicKey == nil {
+			r.Recorder.Event(peer, v1.EventTypeNormal,
+				reasonKeypairGenerated, "New keypair is generated")
+		}
 	} else if err != nil {
 		// unexpected error
 		log.Error(err, "Cannot fetch corresponding secret from cluster")
@@ -109,16 +158,36 @@ func (r *WireguardPeerReconciler) Reconcile(
 	log.Info("Keypair is set")
 
 	desiredSecret, err := fact.Secret(*wgEndpoint, publicKey, privateKey)
-	if err != nil {
+	if errors.Is(err, factory.ErrInvalidTemplate) {
+		// keeping previously rendered secret untouched, user needs to fix
+		// the template first
+		log.Info("Cannot render config template", "reason", err.Error())
+		renderFailures.WithLabelValues("peer_secret").Inc()
+		r.Recorder.Event(peer, v1.EventTypeWarning,
+			reasonTemplateInvalid, err.Error())
+		return empty, r.setCondition(ctx, req.NamespacedName, metav1.Condition{
+			Type:    v1alpha1.PeerConditionConfigRendered,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonTemplateInvalid,
+			Message: err.Error(),
+		})
+	} else if err != nil {
 		log.Error(err, "Cannot generate secret")
+		renderFailures.WithLabelValues("peer_secret").Inc()
+		if errors.Is(err, factory.ErrDNSResolution) {
+			r.Recorder.Event(peer, v1.EventTypeWarning,
+				reasonDNSResolutionFailed, err.Error())
+		}
 		return empty, err
 	}
 
-	if applied, err := apply(ctx, r, desiredSecret); err != nil {
+	if applied, err := apply(ctx, r.Client, desiredSecret); err != nil {
 		log.Error(err, "Cannot apply secret")
 		return empty, err
 	} else if applied {
 		log.Info("Secret applied successfully")
+		r.Recorder.Event(peer, v1.EventTypeNormal,
+			reasonSecretUpdated, "Secret is applied")
 		return requeue, nil
 	}
 	log.Info("Secret is up to date")
@@ -129,17 +198,40 @@ func (r *WireguardPeerReconciler) Reconcile(
 		return empty, err
 	}
 
+	oldPublicKey := peer.Status.PublicKey
 	if peer.Spec.PublicKey == nil {
 		peer.Status.PublicKey = &publicKey
 	} else {
 		peer.Status.PublicKey = peer.Spec.PublicKey
 	}
+	if oldPublicKey != nil && *oldPublicKey != *peer.Status.PublicKey {
+		r.Recorder.Eventf(peer, v1.EventTypeNormal, reasonKeyRotated,
+			"Public key is rotated to %s", *peer.Status.PublicKey)
+	}
+	if peer.Spec.Quota == nil {
+		// usage of the removed quota is not relevant anymore
+		peer.Status.Usage = nil
+	}
+	peer.Status.Suspended = peer.Spec.Suspended || factory.QuotaExceeded(*peer)
+	meta.SetStatusCondition(&peer.Status.Conditions, metav1.Condition{
+		Type:               v1alpha1.PeerConditionConfigRendered,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonRendered,
+		ObservedGeneration: peer.GetGeneration(),
+	})
 	if err := r.Status().Update(ctx, peer); err != nil {
 		log.Error(err, "Cannot update status")
 		return empty, err
 	}
 	log.Info("Status is updated")
 
+	spec := wireguard.Spec
+	hostnames := append([]string{spec.DNS}, spec.DNSServers...)
+	if r.DNSRefreshInterval > 0 && resolver.NeedsLookup(hostnames...) {
+		// DNS records might change, so coming back to pick them up
+		return ctrl.Result{RequeueAfter: r.DNSRefreshInterval}, nil
+	}
+
 	return empty, nil
 }
 
@@ -147,5 +239,129 @@ func (r *WireguardPeerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1alpha1.WireguardPeer{}).
 		Owns(&v1.Secret{}).
+		Watches(
+			&v1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(r.findPeersForConfigMap),
+		).
+		Watches(
+			&v1alpha1.Wireguard{},
+			handler.EnqueueRequestsFromMapFunc(r.findPeersForWireguard),
+		).
 		Complete(r)
 }
+
+// Returns custom config template of the peer. Peer reference takes
+// precedence over the wireguard one. Empty string means built-in template
+func (r *WireguardPeerReconciler) getConfigTemplate(
+	ctx context.Context, wireguard *v1alpha1.Wireguard,
+	peer *v1alpha1.WireguardPeer) (string, error) {
+
+	ref := peer.Spec.ConfigTemplateRef
+	if ref == nil {
+		ref = wireguard.Spec.PeerConfigTemplateRef
+	}
+	if ref == nil {
+		return "", nil
+	}
+
+	key := types.NamespacedName{
+		Name:      ref.Name,
+		Namespace: peer.GetNamespace(),
+	}
+	cm := &v1.ConfigMap{}
+	if err := r.Get(ctx, key, cm); err != nil {
+		return "", err
+	}
+
+	tmpl, ok := cm.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("%w: %s/%s", errTemplateKeyNotFound, ref.Name, ref.Key)
+	}
+
+	return tmpl, nil
+}
+
+// Sets condition on the peer. Peer is refetched to avoid update conflicts
+func (r *WireguardPeerReconciler) setCondition(
+	ctx context.Context, key types.NamespacedName,
+	condition metav1.Condition) error {
+
+	peer := &v1alpha1.WireguardPeer{}
+	if err := r.Get(ctx, key, peer); err != nil {
+		return err
+	}
+
+	condition.ObservedGeneration = peer.GetGeneration()
+	meta.SetStatusCondition(&peer.Status.Conditions, condition)
+	return r.Status().Update(ctx, peer)
+}
+
+// Maps config map into requests for peers using it as a config template,
+// either directly or through the parent wireguard
+func (r *WireguardPeerReconciler) findPeersForConfigMap(
+	ctx context.Context, cm client.Object) []reconcile.Request {
+
+	opts := &client.ListOptions{Namespace: cm.GetNamespace()}
+	var wireguards v1alpha1.WireguardList
+	if err := r.List(ctx, &wireguards, opts); err != nil {
+		return nil
+	}
+
+	var peers v1alpha1.WireguardPeerList
+	if err := r.List(ctx, &peers, opts); err != nil {
+		return nil
+	}
+
+	refs := map[string]*v1.ConfigMapKeySelector{}
+	for _, wg := range wireguards.Items {
+		refs[wg.GetName()] = wg.Spec.PeerConfigTemplateRef
+	}
+
+	requests := []reconcile.Request{}
+	for _, peer := range peers.Items {
+		ref := peer.Spec.ConfigTemplateRef
+		if ref == nil {
+			ref = refs[peer.Spec.WireguardRef]
+		}
+		if ref == nil || ref.Name != cm.GetName() {
+			continue
+		}
+
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      peer.GetName(),
+				Namespace: peer.GetNamespace(),
+			},
+		})
+	}
+
+	return requests
+}
+
+// Maps wireguard into requests for its peers, so configs follow status of
+// the wireguard, e.g. discovered cluster networks
+func (r *WireguardPeerReconciler) findPeersForWireguard(
+	ctx context.Context, wg client.Object) []reconcile.Request {
+
+	var peers v1alpha1.WireguardPeerList
+	opts := &client.ListOptions{Namespace: wg.GetNamespace()}
+	if err := r.List(ctx, &peers, opts); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, peer := range peers.Items {
+		if peer.Spec.WireguardRef != wg.GetName() {
+			continue
+		}
+
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      peer.GetName(),
+				Namespace: peer.GetNamespace(),
+			},
+		})
+	}
+
+	return requests
+}
@@ -8,7 +8,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/storage/names"
 
 	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
 	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
@@ -214,3 +217,129 @@ func TestPeerStatus(t *testing.T) {
 		table.Entry(tc.description, tc)
 	}
 }
+
+func TestPeerSuspended(t *testing.T) {
+	t.Parallel()
+
+	wg := dsl.GenerateWireguard(
+		v1alpha1.WireguardSpec{},
+		v1alpha1.WireguardStatus{},
+	)
+	err := wgDsl.Apply(ctx, &wg)
+	assert.Nil(t, err)
+
+	peer := dsl.GeneratePeer(
+		v1alpha1.WireguardPeerSpec{
+			WireguardRef: wg.GetName(),
+			Suspended:    true,
+		},
+		v1alpha1.WireguardPeerStatus{},
+	)
+	err = peerDsl.Apply(ctx, &peer)
+	assert.Nil(t, err)
+	assert.True(t, peer.Status.Suspended)
+
+	key := types.NamespacedName{
+		Name:      peer.GetName(),
+		Namespace: peer.GetNamespace(),
+	}
+	peerSecret := &corev1.Secret{}
+	err = k8sClient.Get(ctx, key, peerSecret)
+	assert.Nil(t, err, "suspended peer should keep its secret")
+	assert.Contains(t, peerSecret.Data, "private-key")
+	assert.Contains(t, peerSecret.Data, "public-key")
+
+	err = wgDsl.Reconcile(ctx, &wg)
+	assert.Nil(t, err)
+
+	wgKey := types.NamespacedName{
+		Name:      wg.GetName(),
+		Namespace: wg.GetNamespace(),
+	}
+	wgSecret := &corev1.Secret{}
+	err = k8sClient.Get(ctx, wgKey, wgSecret)
+	assert.Nil(t, err)
+
+	config := string(wgSecret.Data["config"])
+	pubKey := string(peerSecret.Data["public-key"])
+	assert.NotContains(t, config, pubKey,
+		"suspended peer should not be in server config")
+}
+
+func TestPeerConfigTemplate(t *testing.T) {
+	t.Parallel()
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	type testCase struct {
+		description string
+		template    string
+		wantStatus  metav1.ConditionStatus
+	}
+
+	testCases := []testCase{{
+		description: "valid template",
+		template:    "[Interface]\nPrivateKey = {{ .PrivateKey }}\nMTU = 1380\n",
+		wantStatus:  metav1.ConditionTrue,
+	}, {
+		description: "invalid template",
+		template:    "Keepalive = {{ .Keepalive }}",
+		wantStatus:  metav1.ConditionFalse,
+	}}
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      names.SimpleNameGenerator.GenerateName("template-"),
+				Namespace: corev1.NamespaceDefault,
+			},
+			Data: map[string]string{"peer.conf": tc.template},
+		}
+		err := k8sClient.Create(ctx, cm)
+		assert.Nil(t, err)
+
+		wg := dsl.GenerateWireguard(
+			v1alpha1.WireguardSpec{
+				PeerConfigTemplateRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: cm.GetName(),
+					},
+					Key: "peer.conf",
+				},
+			},
+			v1alpha1.WireguardStatus{},
+		)
+		err = wgDsl.Apply(ctx, &wg)
+		assert.Nil(t, err)
+
+		peer := dsl.GeneratePeer(
+			v1alpha1.WireguardPeerSpec{WireguardRef: wg.GetName()},
+			v1alpha1.WireguardPeerStatus{},
+		)
+		err = peerDsl.Apply(ctx, &peer)
+		assert.Nil(t, err)
+
+		cond := meta.FindStatusCondition(
+			peer.Status.Conditions,
+			v1alpha1.PeerConditionConfigRendered,
+		)
+		assert.NotNil(t, cond)
+		assert.Equal(t, tc.wantStatus, cond.Status)
+
+		if tc.wantStatus == metav1.ConditionTrue {
+			key := types.NamespacedName{
+				Name:      peer.GetName(),
+				Namespace: peer.GetNamespace(),
+			}
+			secret := &corev1.Secret{}
+			err = k8sClient.Get(ctx, key, secret)
+			assert.Nil(t, err)
+			assert.Contains(t, string(secret.Data["config"]), "MTU = 1380")
+		}
+	})
+
+	for _, tc := range testCases {
+		spec.Entry(tc.description, tc)
+	}
+}
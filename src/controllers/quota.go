@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/factory"
+)
+
+// Transfer counters of the peer on the wireguard interface, as seen by
+// the wireguard
+type Transfer struct {
+	Received int64
+	Sent     int64
+}
+
+// TransferReader reads transfer counters of the wireguard interface in the
+// pod, by public key of the peer
+type TransferReader interface {
+	ReadTransfer(ctx context.Context, pod corev1.Pod) (map[string]Transfer, error)
+}
+
+const (
+	// Counters of the peers served by wg-agent, labelled by public key
+	agentReceiveBytes  = "wireguard_agent_peer_receive_bytes_total"
+	agentTransmitBytes = "wireguard_agent_peer_transmit_bytes_total"
+)
+
+// AgentMetrics reads transfer counters from metrics endpoint of the agent
+// in the wireguard container, so no access to the pods is required beyond
+// the network
+type AgentMetrics struct {
+	Client *http.Client
+	// Port of the agent endpoints, factory.AgentPort unless set
+	Port int32
+}
+
+func (m *AgentMetrics) ReadTransfer(ctx context.Context, pod corev1.Pod) (
+	map[string]Transfer, error) {
+
+	if pod.Status.PodIP == "" {
+		return nil, fmt.Errorf("pod ip is not yet set")
+	}
+
+	port := m.Port
+	if port == 0 {
+		port = factory.AgentPort
+	}
+
+	httpClient := m.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	host := net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(port)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://"+host+"/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status of metrics: %s", resp.Status)
+	}
+
+	return parseTransfer(resp.Body)
+}
+
+// Parses counters of the peers from metrics of the agent in text format
+func parseTransfer(r io.Reader) (map[string]Transfer, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]Transfer{}
+	for _, name := range []string{agentReceiveBytes, agentTransmitBytes} {
+		family, ok := families[name]
+		if !ok {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			key := ""
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "public_key" {
+					key = label.GetValue()
+				}
+			}
+			if key == "" {
+				continue
+			}
+
+			value := int64(metric.GetCounter().GetValue())
+			transfer := result[key]
+			if name == agentReceiveBytes {
+				transfer.Received = value
+			} else {
+				transfer.Sent = value
+			}
+			result[key] = transfer
+		}
+	}
+
+	return result, nil
+}
+
+// QuotaAccountant periodically accumulates traffic of the peers with quota
+// into their status. Exceeding peers are then suspended by reconcilers
+type QuotaAccountant struct {
+	client.Client
+	Recorder record.EventRecorder
+	Transfer TransferReader
+	// How often transfer counters are read
+	Interval time.Duration
+	now      func() time.Time
+}
+
+func (a *QuotaAccountant) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("quota-accountant")
+
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	for {
+		if err := a.Account(ctx); err != nil {
+			log.Error(err, "Cannot account traffic of the peers")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reads transfer counters of the wireguard pods and updates usage of the
+// peers with quota
+func (a *QuotaAccountant) Account(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("quota-accountant")
+
+	now := time.Now
+	if a.now != nil {
+		now = a.now
+	}
+	periodStart := quotaPeriodStart(now())
+
+	var peers v1alpha1.WireguardPeerList
+	if err := a.List(ctx, &peers); err != nil {
+		return err
+	}
+
+	byWireguard := map[types.NamespacedName][]v1alpha1.WireguardPeer{}
+	for _, peer := range peers.Items {
+		if peer.Spec.Quota == nil || peer.Status.PublicKey == nil {
+			continue
+		}
+
+		key := types.NamespacedName{
+			Name:      peer.Spec.WireguardRef,
+			Namespace: peer.GetNamespace(),
+		}
+		byWireguard[key] = append(byWireguard[key], peer)
+	}
+
+	for key, peers := range byWireguard {
+		wg := &v1alpha1.Wireguard{}
+		if err := a.Get(ctx, key, wg); err != nil {
+			log.Error(err, "Cannot retrieve wireguard", "wireguard", key)
+			continue
+		}
+
+		fact := factory.Wireguard{Wireguard: *wg}
+		var pods corev1.PodList
+		opts := []client.ListOption{
+			client.InNamespace(key.Namespace),
+			client.MatchingLabels(fact.Labels()),
+		}
+		if err := a.List(ctx, &pods, opts...); err != nil {
+			return err
+		}
+
+		// counters of the pods which cannot be read now are kept, so
+		// traffic is not counted twice once those are readable again
+		existing := map[string]bool{}
+		observed := map[string]map[string]Transfer{}
+		for _, pod := range pods.Items {
+			uid := string(pod.GetUID())
+			existing[uid] = true
+			if pod.Status.Phase != corev1.PodRunning {
+				continue
+			}
+
+			transfer, err := a.Transfer.ReadTransfer(ctx, pod)
+			if err != nil {
+				log.Error(err, "Cannot read transfer counters", "pod", pod.GetName())
+				continue
+			}
+			observed[uid] = transfer
+		}
+
+		for _, peer := range peers {
+			counters := map[string]Transfer{}
+			for uid, transfer := range observed {
+				if t, ok := transfer[*peer.Status.PublicKey]; ok {
+					counters[uid] = t
+				}
+			}
+
+			usage := accumulateUsage(peer.Status.Usage, periodStart, counters, existing)
+			if equality.Semantic.DeepEqual(usage, peer.Status.Usage) {
+				continue
+			}
+
+			exceeded := factory.QuotaExceeded(peer)
+			peer.Status.Usage = usage
+			if err := a.Status().Update(ctx, &peer); err != nil {
+				// conflicts are retried on the next tick, counters are
+				// still on the interface
+				log.Error(err, "Cannot update usage", "peer", peer.GetName())
+				continue
+			}
+
+			if !exceeded && factory.QuotaExceeded(peer) {
+				a.Recorder.Eventf(&peer, corev1.EventTypeWarning,
+					reasonQuotaExceeded, "Quota of %s is exceeded, peer is suspended "+
+						"until %s", peer.Spec.Quota.Limit.String(),
+					periodStart.AddDate(0, 1, 0).Format(time.DateOnly))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns start of the quota period, which is the calendar month in UTC
+func quotaPeriodStart(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Returns usage with increments of the counters accumulated. Counters are
+// by UID of the pod, pods not in existing are forgotten. Usage of the
+// previous period is reset, keeping counters as a baseline
+func accumulateUsage(current *v1alpha1.TrafficUsage, periodStart time.Time,
+	counters map[string]Transfer, existing map[string]bool) *v1alpha1.TrafficUsage {
+
+	usage := &v1alpha1.TrafficUsage{PeriodStart: metav1.NewTime(periodStart)}
+	// traffic before quota is set is not counted
+	firstSeen := current == nil
+	previous := map[string]v1alpha1.TransferCounter{}
+	if current != nil {
+		if !current.PeriodStart.Time.Before(periodStart) {
+			usage.ReceivedBytes = current.ReceivedBytes
+			usage.SentBytes = current.SentBytes
+		}
+		for _, c := range current.Counters {
+			previous[c.Pod] = c
+		}
+	}
+
+	for pod, prev := range previous {
+		if _, ok := counters[pod]; !ok && existing[pod] {
+			usage.Counters = append(usage.Counters, prev)
+		}
+	}
+
+	for pod, transfer := range counters {
+		received, sent := transfer.Received, transfer.Sent
+		prev, ok := previous[pod]
+		switch {
+		case firstSeen:
+			received, sent = 0, 0
+		case ok && received >= prev.ReceivedBytes && sent >= prev.SentBytes:
+			received -= prev.ReceivedBytes
+			sent -= prev.SentBytes
+		}
+		// otherwise interface is recreated, so counters start from zero
+
+		usage.ReceivedBytes += received
+		usage.SentBytes += sent
+		usage.Counters = append(usage.Counters, v1alpha1.TransferCounter{
+			Pod:           pod,
+			ReceivedBytes: transfer.Received,
+			SentBytes:     transfer.Sent,
+		})
+	}
+
+	// stable order avoids status updates without changes
+	slices.SortFunc(usage.Counters, func(a, b v1alpha1.TransferCounter) int {
+		return strings.Compare(a.Pod, b.Pod)
+	})
+
+	return usage
+}
@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/factory"
+	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
+)
+
+type fakeTransfer map[string]Transfer
+
+func (f fakeTransfer) ReadTransfer(context.Context, corev1.Pod) (
+	map[string]Transfer, error) {
+
+	return f, nil
+}
+
+func TestAgentMetrics(t *testing.T) {
+	t.Parallel()
+
+	const metrics = `# TYPE wireguard_agent_peer_receive_bytes_total counter
+wireguard_agent_peer_receive_bytes_total{public_key="laptop"} 1024
+wireguard_agent_peer_receive_bytes_total{public_key="phone"} 0
+# TYPE wireguard_agent_peer_transmit_bytes_total counter
+wireguard_agent_peer_transmit_bytes_total{public_key="laptop"} 4096
+wireguard_agent_peer_transmit_bytes_total{public_key="phone"} 512
+# TYPE wireguard_agent_up gauge
+wireguard_agent_up 1
+`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, metrics)
+	}))
+	defer srv.Close()
+
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	assert.Nil(t, err)
+	portNumber, err := strconv.Atoi(port)
+	assert.Nil(t, err)
+
+	reader := &AgentMetrics{Port: int32(portNumber)}
+	pod := corev1.Pod{Status: corev1.PodStatus{PodIP: host}}
+	got, err := reader.ReadTransfer(context.Background(), pod)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]Transfer{
+		"laptop": {Received: 1024, Sent: 4096},
+		"phone":  {Received: 0, Sent: 512},
+	}, got)
+
+	_, err = reader.ReadTransfer(context.Background(), corev1.Pod{})
+	assert.NotNil(t, err, "should fail without pod ip")
+}
+
+func TestAccumulateUsage(t *testing.T) {
+	t.Parallel()
+
+	period := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	previousPeriod := metav1.NewTime(period.AddDate(0, -1, 0))
+	counter := func(pod string, received, sent int64) v1alpha1.TransferCounter {
+		return v1alpha1.TransferCounter{
+			Pod:           pod,
+			ReceivedBytes: received,
+			SentBytes:     sent,
+		}
+	}
+
+	type testCase struct {
+		description string
+		current     *v1alpha1.TrafficUsage
+		counters    map[string]Transfer
+		existing    map[string]bool
+		want        v1alpha1.TrafficUsage
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		tc.want.PeriodStart = metav1.NewTime(period)
+		got := accumulateUsage(tc.current, period, tc.counters, tc.existing)
+		assert.Equal(t, tc.want, *got)
+	})
+
+	spec.Entry("counters are a baseline when quota is set", testCase{
+		counters: map[string]Transfer{"a": {Received: 100, Sent: 200}},
+		existing: map[string]bool{"a": true},
+		want: v1alpha1.TrafficUsage{
+			Counters: []v1alpha1.TransferCounter{counter("a", 100, 200)},
+		},
+	})
+	spec.Entry("accumulates increments", testCase{
+		current: &v1alpha1.TrafficUsage{
+			PeriodStart:   metav1.NewTime(period),
+			ReceivedBytes: 10,
+			SentBytes:     20,
+			Counters:      []v1alpha1.TransferCounter{counter("a", 100, 200)},
+		},
+		counters: map[string]Transfer{"a": {Received: 150, Sent: 300}},
+		existing: map[string]bool{"a": true},
+		want: v1alpha1.TrafficUsage{
+			ReceivedBytes: 60,
+			SentBytes:     120,
+			Counters:      []v1alpha1.TransferCounter{counter("a", 150, 300)},
+		},
+	})
+	spec.Entry("counts whole counters of restarted pods", testCase{
+		current: &v1alpha1.TrafficUsage{
+			PeriodStart:   metav1.NewTime(period),
+			ReceivedBytes: 10,
+			SentBytes:     20,
+			Counters: []v1alpha1.TransferCounter{
+				counter("a", 100, 200),
+				counter("b", 100, 200),
+				counter("c", 100, 200),
+			},
+		},
+		counters: map[string]Transfer{
+			"a": {Received: 5, Sent: 5},
+			"d": {Received: 1, Sent: 2},
+		},
+		// b is not readable, c is gone
+		existing: map[string]bool{"a": true, "b": true, "d": true},
+		want: v1alpha1.TrafficUsage{
+			ReceivedBytes: 16,
+			SentBytes:     27,
+			Counters: []v1alpha1.TransferCounter{
+				counter("a", 5, 5),
+				counter("b", 100, 200),
+				counter("d", 1, 2),
+			},
+		},
+	})
+	spec.Entry("resets usage of the previous period", testCase{
+		current: &v1alpha1.TrafficUsage{
+			PeriodStart:   previousPeriod,
+			ReceivedBytes: 1000,
+			SentBytes:     2000,
+			Counters:      []v1alpha1.TransferCounter{counter("a", 100, 200)},
+		},
+		counters: map[string]Transfer{"a": {Received: 110, Sent: 220}},
+		existing: map[string]bool{"a": true},
+		want: v1alpha1.TrafficUsage{
+			ReceivedBytes: 10,
+			SentBytes:     20,
+			Counters:      []v1alpha1.TransferCounter{counter("a", 110, 220)},
+		},
+	})
+}
+
+func TestQuotaAccountant(t *testing.T) {
+	t.Parallel()
+
+	wg := dsl.GenerateWireguard(
+		v1alpha1.WireguardSpec{},
+		v1alpha1.WireguardStatus{},
+	)
+	err := wgDsl.Apply(ctx, &wg)
+	assert.Nil(t, err)
+
+	peer := dsl.GeneratePeer(
+		v1alpha1.WireguardPeerSpec{
+			WireguardRef: wg.GetName(),
+			Quota:        &v1alpha1.Quota{Limit: resource.MustParse("1Ki")},
+		},
+		v1alpha1.WireguardPeerStatus{},
+	)
+	err = peerDsl.Apply(ctx, &peer)
+	assert.Nil(t, err)
+	assert.False(t, peer.Status.Suspended)
+
+	fact := factory.Wireguard{Wireguard: wg}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      wg.GetName() + "-quota",
+			Namespace: wg.GetNamespace(),
+			Labels:    fact.Labels(),
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "wireguard",
+				Image: "linuxserver/wireguard",
+			}},
+		},
+	}
+	err = k8sClient.Create(ctx, pod)
+	assert.Nil(t, err)
+
+	pod.Status.Phase = corev1.PodRunning
+	err = k8sClient.Status().Update(ctx, pod)
+	assert.Nil(t, err)
+
+	transfer := fakeTransfer{*peer.Status.PublicKey: {Received: 100, Sent: 100}}
+	accountant := &QuotaAccountant{
+		Client:   k8sClient,
+		Recorder: &record.FakeRecorder{},
+		Transfer: transfer,
+	}
+
+	key := types.NamespacedName{
+		Name:      peer.GetName(),
+		Namespace: peer.GetNamespace(),
+	}
+
+	// first observation is a baseline
+	err = accountant.Account(ctx)
+	assert.Nil(t, err)
+	err = k8sClient.Get(ctx, key, &peer)
+	assert.Nil(t, err)
+	if assert.NotNil(t, peer.Status.Usage) {
+		assert.Zero(t, peer.Status.Usage.ReceivedBytes)
+		assert.Len(t, peer.Status.Usage.Counters, 1)
+	}
+
+	transfer[*peer.Status.PublicKey] = Transfer{Received: 1000, Sent: 200}
+	err = accountant.Account(ctx)
+	assert.Nil(t, err)
+	err = k8sClient.Get(ctx, key, &peer)
+	assert.Nil(t, err)
+	if assert.NotNil(t, peer.Status.Usage) {
+		assert.Equal(t, int64(900), peer.Status.Usage.ReceivedBytes)
+		assert.Equal(t, int64(100), peer.Status.Usage.SentBytes)
+	}
+
+	err = peerDsl.Reconcile(ctx, &peer)
+	assert.Nil(t, err)
+	err = k8sClient.Get(ctx, key, &peer)
+	assert.Nil(t, err)
+	assert.True(t, peer.Status.Suspended, "peer over quota should be suspended")
+
+	err = wgDsl.Reconcile(ctx, &wg)
+	assert.Nil(t, err)
+
+	wgKey := types.NamespacedName{
+		Name:      wg.GetName(),
+		Namespace: wg.GetNamespace(),
+	}
+	wgSecret := &corev1.Secret{}
+	err = k8sClient.Get(ctx, wgKey, wgSecret)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(wgSecret.Data["config"]), *peer.Status.PublicKey,
+		"peer over quota should not be in server config")
+}
@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+const (
+	// Subresource callers must be allowed to get, e.g. by role granting
+	// get on wireguardpeers/config for the given resource names
+	configSubresource = "config"
+	// User name recorded in events when one-time token is used
+	downloadTokenUser = "download-token"
+	// Audience kubernetes tokens must be issued for, so tokens of the other
+	// services can't be replayed here and ones sent here can't be replayed
+	// to the API server
+	selfServiceAudience = "vpn.ahova.com/self-service"
+	qrCodeSize          = 512
+)
+
+var (
+	errUnauthenticated = fmt.Errorf("unauthenticated")
+	errForbidden       = fmt.Errorf("forbidden")
+)
+
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// SelfService serves configurations of the peers over HTTP to the peer
+// owners, so those don't need access to secrets in the cluster. Callers are
+// authenticated either by one-time token, which hash is stored on the peer, or by
+// kubernetes token issued for the self-service audience, which must be allowed to
+// get wireguardpeers/config. Kubernetes tokens are accepted over TLS only
+type SelfService struct {
+	client.Client
+	Recorder record.EventRecorder
+	// Address to listen on, e.g. :8443
+	BindAddress string
+	// Directory with tls.crt and tls.key. Plain HTTP is served when empty,
+	// and only one-time tokens are accepted then
+	CertDir string
+}
+
+// Every replica serves requests, as no state is kept in memory
+func (s *SelfService) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *SelfService) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("self-service")
+
+	srv := &http.Server{
+		Addr:              s.BindAddress,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Error(err, "Cannot shutdown self-service server")
+		}
+	}()
+
+	log.Info("Serving self-service API", "address", s.BindAddress)
+	var err error
+	if s.CertDir == "" {
+		err = srv.ListenAndServe()
+	} else {
+		err = srv.ListenAndServeTLS(
+			filepath.Join(s.CertDir, "tls.crt"),
+			filepath.Join(s.CertDir, "tls.key"))
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *SelfService) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/namespaces/{namespace}/peers/{name}/config", s.serveConfig)
+	return mux
+}
+
+// Serves configuration of the peer. Format is set by format query
+// parameter: conf (default), qr for PNG image, or json
+func (s *SelfService) serveConfig(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	key := types.NamespacedName{
+		Namespace: r.PathValue("namespace"),
+		Name:      r.PathValue("name"),
+	}
+	log := log.FromContext(ctx).WithName("self-service").WithValues("peer", key)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "conf"
+	}
+
+	// peer existence is not revealed to unauthenticated callers
+	peer := &v1alpha1.WireguardPeer{}
+	if err := s.Get(ctx, key, peer); apierrors.IsNotFound(err) {
+		peer = nil
+	} else if err != nil {
+		log.Error(err, "Cannot fetch peer")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.authenticate(ctx, r, key, peer)
+	if errors.Is(err, errUnauthenticated) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	} else if errors.Is(err, errForbidden) {
+		if peer != nil {
+			s.Recorder.Eventf(peer, corev1.EventTypeWarning, reasonConfigDownloadDenied,
+				"Config download is denied to %s from %s", user, r.RemoteAddr)
+		}
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Error(err, "Cannot authenticate request")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if peer == nil {
+		http.Error(w, "peer not found", http.StatusNotFound)
+		return
+	}
+
+	secret := &corev1.Secret{}
+	if err := s.Get(ctx, key, secret); apierrors.IsNotFound(err) {
+		http.Error(w, "config is not rendered", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Error(err, "Cannot fetch secret of the peer")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	config, ok := secret.Data["config"]
+	if !ok {
+		http.Error(w, "config is not rendered", http.StatusConflict)
+		return
+	}
+
+	body, contentType, err := formatConfig(peer, config, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// token is consumed before the config is returned, so it's never
+	// used twice even on concurrent requests
+	if user == downloadTokenUser {
+		if err := s.consumeToken(ctx, peer); apierrors.IsConflict(err) {
+			http.Error(w, errUnauthenticated.Error(), http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			log.Error(err, "Cannot consume download token")
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.Recorder.Eventf(peer, corev1.EventTypeNormal, reasonConfigDownloaded,
+		"Config is downloaded by %s from %s in %s format", user, r.RemoteAddr, format)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := w.Write(body); err != nil {
+		log.Error(err, "Cannot write response")
+	}
+}
+
+// Returns name of the authenticated user. Error wraps errUnauthenticated or
+// errForbidden when the caller is not allowed to download the config
+func (s *SelfService) authenticate(ctx context.Context, r *http.Request,
+	key types.NamespacedName, peer *v1alpha1.WireguardPeer) (string, error) {
+
+	token, fromQuery := bearerToken(r)
+	if token == "" {
+		return "", errUnauthenticated
+	}
+
+	if peer != nil {
+		stored := peer.GetAnnotations()[v1alpha1.DownloadTokenHashAnnotation]
+		hash := sha256.Sum256([]byte(token))
+		given := hex.EncodeToString(hash[:])
+		if stored != "" &&
+			subtle.ConstantTimeCompare([]byte(strings.ToLower(stored)), []byte(given)) == 1 {
+			return downloadTokenUser, nil
+		}
+	}
+
+	// query strings end up in logs and browser history, and plain HTTP
+	// exposes the token, while kubernetes token outlives the download
+	if fromQuery || s.CertDir == "" {
+		return "", errUnauthenticated
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: []string{selfServiceAudience},
+		},
+	}
+	if err := s.Create(ctx, review); err != nil {
+		return "", err
+	}
+
+	// authenticators not supporting audiences return none, those tokens
+	// are not bound to this service
+	if !review.Status.Authenticated ||
+		!slices.Contains(review.Status.Audiences, selfServiceAudience) {
+		return "", errUnauthenticated
+	}
+
+	user := review.Status.User
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	access := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   key.Namespace,
+				Verb:        "get",
+				Group:       v1alpha1.GroupVersion.Group,
+				Resource:    "wireguardpeers",
+				Subresource: configSubresource,
+				Name:        key.Name,
+			},
+		},
+	}
+	if err := s.Create(ctx, access); err != nil {
+		return user.Username, err
+	}
+
+	if !access.Status.Allowed {
+		return user.Username, errForbidden
+	}
+
+	return user.Username, nil
+}
+
+// Removes one-time token from the peer. Conflict is returned if the peer
+// was changed in between, e.g. when token is already consumed
+func (s *SelfService) consumeToken(ctx context.Context,
+	peer *v1alpha1.WireguardPeer) error {
+
+	patched := peer.DeepCopy()
+	delete(patched.Annotations, v1alpha1.DownloadTokenHashAnnotation)
+	patch := client.MergeFromWithOptions(peer, client.MergeFromWithOptimisticLock{})
+	return s.Patch(ctx, patched, patch)
+}
+
+// Returns token from authorization header, or from token query parameter,
+// so download links can be opened in browser. The latter is reported, as
+// only one-time tokens are accepted from the query
+func bearerToken(r *http.Request) (token string, fromQuery bool) {
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token), false
+	}
+
+	return r.URL.Query().Get("token"), true
+}
+
+// Returns config of the peer in the requested format along with its
+// content type
+func formatConfig(peer *v1alpha1.WireguardPeer, config []byte,
+	format string) ([]byte, string, error) {
+
+	switch format {
+	case "conf":
+		return config, "text/plain; charset=utf-8", nil
+	case "qr":
+		png, err := qrcode.Encode(string(config), qrcode.Medium, qrCodeSize)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return png, "image/png", nil
+	case "json":
+		body, err := json.Marshal(map[string]string{
+			"name":      peer.GetName(),
+			"namespace": peer.GetNamespace(),
+			"wireguard": peer.Spec.WireguardRef,
+			"address":   string(peer.Spec.Address),
+			"config":    string(config),
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		return body, "application/json", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported format %q, "+
+			"expected one of conf, qr, json", format)
+	}
+}
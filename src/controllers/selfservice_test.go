@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/storage/names"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
+)
+
+func TestSelfService(t *testing.T) {
+	t.Parallel()
+
+	const (
+		token = "6f0c1d2e3b4a59687766554433221100"
+		// sha256 of the token
+		tokenHash = "01836ee8878eb930b8c62bfe5913f29ed96524cd9be1f31510353c5cfcc53e2c"
+	)
+
+	// Returns reconciled peer holding hash of the download token
+	makePeer := func(t *testing.T) v1alpha1.WireguardPeer {
+		wg := dsl.GenerateWireguard(
+			v1alpha1.WireguardSpec{},
+			v1alpha1.WireguardStatus{},
+		)
+		err := wgDsl.Apply(ctx, &wg)
+		assert.Nil(t, err)
+
+		peer := dsl.GeneratePeer(
+			v1alpha1.WireguardPeerSpec{WireguardRef: wg.GetName()},
+			v1alpha1.WireguardPeerStatus{},
+		)
+		peer.SetAnnotations(map[string]string{
+			v1alpha1.DownloadTokenHashAnnotation: tokenHash,
+		})
+		err = peerDsl.Apply(ctx, &peer)
+		assert.Nil(t, err)
+
+		return peer
+	}
+
+	get := func(srv *SelfService, peer v1alpha1.WireguardPeer,
+		query, token string) *httptest.ResponseRecorder {
+
+		url := fmt.Sprintf("/api/v1/namespaces/%s/peers/%s/config%s",
+			peer.GetNamespace(), peer.GetName(), query)
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Returns token of a new service account issued for the audiences
+	serviceAccountToken := func(t *testing.T, namespace string,
+		audiences ...string) string {
+
+		sa := &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      names.SimpleNameGenerator.GenerateName("peer-owner-"),
+				Namespace: namespace,
+			},
+		}
+		err := k8sClient.Create(ctx, sa)
+		assert.Nil(t, err)
+
+		request := &authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{Audiences: audiences},
+		}
+		err = k8sClient.SubResource("token").Create(ctx, sa, request)
+		assert.Nil(t, err)
+
+		return request.Status.Token
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	o.Spec("serves config once per download token", func(t *testing.T) {
+		recorder := record.NewFakeRecorder(100)
+		srv := &SelfService{Client: k8sClient, Recorder: recorder}
+		peer := makePeer(t)
+
+		rec := get(srv, peer, "", token)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		secret := &corev1.Secret{}
+		key := types.NamespacedName{
+			Name:      peer.GetName(),
+			Namespace: peer.GetNamespace(),
+		}
+		err := k8sClient.Get(ctx, key, secret)
+		assert.Nil(t, err)
+		assert.Equal(t, secret.Data["config"], rec.Body.Bytes())
+		assert.Equal(t, []string{reasonConfigDownloaded}, recordedReasons(recorder))
+
+		err = k8sClient.Get(ctx, key, &peer)
+		assert.Nil(t, err)
+		assert.NotContains(t, peer.GetAnnotations(), v1alpha1.DownloadTokenHashAnnotation)
+
+		rec = get(srv, peer, "", token)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	o.Spec("serves qr code", func(t *testing.T) {
+		srv := &SelfService{Client: k8sClient, Recorder: &record.FakeRecorder{}}
+		peer := makePeer(t)
+
+		rec := get(srv, peer, "?format=qr&token="+token, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+		assert.True(t, bytes.HasPrefix(rec.Body.Bytes(), []byte("\x89PNG")))
+	})
+
+	o.Spec("rejects unsupported format without consuming token", func(t *testing.T) {
+		srv := &SelfService{Client: k8sClient, Recorder: &record.FakeRecorder{}}
+		peer := makePeer(t)
+
+		rec := get(srv, peer, "?format=yaml", token)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = get(srv, peer, "", token)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	o.Spec("rejects stored hash presented as token", func(t *testing.T) {
+		srv := &SelfService{Client: k8sClient, Recorder: &record.FakeRecorder{}}
+		peer := makePeer(t)
+
+		rec := get(srv, peer, "", tokenHash)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	o.Spec("rejects unauthenticated requests", func(t *testing.T) {
+		srv := &SelfService{Client: k8sClient, Recorder: &record.FakeRecorder{}}
+		peer := makePeer(t)
+
+		rec := get(srv, peer, "", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = get(srv, peer, "", "invalid")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	o.Spec("authorizes kubernetes token of self-service audience", func(t *testing.T) {
+		// cert dir is not read by the handler
+		srv := &SelfService{
+			Client:   k8sClient,
+			Recorder: record.NewFakeRecorder(100),
+			CertDir:  "/tmp/certs",
+		}
+		peer := makePeer(t)
+		token := serviceAccountToken(t, peer.GetNamespace(), selfServiceAudience)
+
+		// service account is not allowed to get the config
+		rec := get(srv, peer, "", token)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	o.Spec("rejects kubernetes token of other audience", func(t *testing.T) {
+		srv := &SelfService{
+			Client:   k8sClient,
+			Recorder: &record.FakeRecorder{},
+			CertDir:  "/tmp/certs",
+		}
+		peer := makePeer(t)
+		token := serviceAccountToken(t, peer.GetNamespace())
+
+		rec := get(srv, peer, "", token)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	o.Spec("rejects kubernetes token without tls", func(t *testing.T) {
+		srv := &SelfService{Client: k8sClient, Recorder: &record.FakeRecorder{}}
+		peer := makePeer(t)
+		token := serviceAccountToken(t, peer.GetNamespace(), selfServiceAudience)
+
+		rec := get(srv, peer, "", token)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	o.Spec("rejects kubernetes token in query", func(t *testing.T) {
+		srv := &SelfService{
+			Client:   k8sClient,
+			Recorder: &record.FakeRecorder{},
+			CertDir:  "/tmp/certs",
+		}
+		peer := makePeer(t)
+		token := serviceAccountToken(t, peer.GetNamespace(), selfServiceAudience)
+
+		rec := get(srv, peer, "?token="+token, "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
@@ -10,11 +10,13 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	k8sLog "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/resolver"
 	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
 	"github.com/cornbuddy/wireguard-operator/src/test/testenv"
 )
@@ -52,18 +54,23 @@ func TestMain(m *testing.M) {
 		log.Fatalf("failed to setup k8s client: %v", err)
 	}
 
+	dnsResolver := resolver.NewCached(resolver.Net{}, time.Minute)
 	peerDsl = dsl.Dsl{
 		K8sClient: k8sClient,
 		Reconciler: &WireguardPeerReconciler{
-			Client: k8sClient,
-			Scheme: k8sClient.Scheme(),
+			Client:   k8sClient,
+			Scheme:   k8sClient.Scheme(),
+			Recorder: &record.FakeRecorder{},
+			Resolver: dnsResolver,
 		},
 	}
 	wgDsl = dsl.Dsl{
 		K8sClient: k8sClient,
 		Reconciler: &WireguardReconciler{
-			Client: k8sClient,
-			Scheme: k8sClient.Scheme(),
+			Client:   k8sClient,
+			Scheme:   k8sClient.Scheme(),
+			Recorder: &record.FakeRecorder{},
+			Resolver: dnsResolver,
 		},
 	}
 
@@ -6,80 +6,94 @@ import (
 	"encoding/hex"
 	"fmt"
 
-	appsv1 "k8s.io/api/apps/v1"
-	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-
-	"github.com/cisco-open/k8s-objectmatcher/patch"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 )
 
-type reconciler interface {
-	client.Reader
-	client.Writer
-}
+const fieldManager = "wireguard-operator"
 
-// creates or updates resource. returns true when created or updated
-func apply(ctx context.Context, r reconciler, desired client.Object) (
+// applies desired state of the resource with server-side apply, so only
+// fields set by operator are owned and fields managed by others (e.g.
+// annotations of cloud controllers) are kept. returns true when resource
+// was created or changed
+func apply(ctx context.Context, c client.Client, desired client.Object) (
 	bool, error) {
 
-	var current client.Object
-	switch v := desired.(type) {
-	case *corev1.Service:
-		current = &corev1.Service{}
-	case *corev1.ConfigMap:
-		current = &corev1.ConfigMap{}
-	case *corev1.Secret:
-		current = &corev1.Secret{}
-	case *appsv1.Deployment:
-		current = &appsv1.Deployment{}
-	case nil:
+	if desired == nil {
 		return false, fmt.Errorf("desired cannot be nil")
-	default:
-		return false, fmt.Errorf("unsupported type %s for desired", v)
 	}
 
-	key := types.NamespacedName{
-		Name:      desired.GetName(),
-		Namespace: desired.GetNamespace(),
+	gvk, err := apiutil.GVKForObject(desired, c.Scheme())
+	if err != nil {
+		return false, err
 	}
-	err := r.Get(ctx, key, current)
-	objectNotExists := errors.IsNotFound(err)
-	unexpectedError := err != nil && !objectNotExists
 
-	if unexpectedError {
-		return false, err
+	// typed objects are not serialized with apiVersion and kind, but
+	// apply requests require them
+	desired.GetObjectKind().SetGroupVersionKind(gvk)
+
+	current, ok := desired.DeepCopyObject().(client.Object)
+	if !ok {
+		return false, fmt.Errorf("unsupported type %T for desired", desired)
 	}
 
-	if objectNotExists {
-		if err := r.Create(ctx, desired); err != nil {
-			return false, err
-		}
+	key := client.ObjectKeyFromObject(desired)
+	err = c.Get(ctx, key, current)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
 
-		// resource is created, get back to reconcilation
-		return true, nil
+	// resource version is empty when resource does not exist yet
+	versionBefore := current.GetResourceVersion()
+	if errors.IsNotFound(err) {
+		versionBefore = ""
 	}
 
-	patchMaker := patch.DefaultPatchMaker
-	opts := []patch.CalculateOption{
-		patch.IgnoreField("metadata"),
+	opts := []client.PatchOption{
+		client.FieldOwner(fieldManager),
+		client.ForceOwnership,
 	}
-	patchResult, err := patchMaker.Calculate(current, desired, opts...)
-	if err != nil {
+	if err := c.Patch(ctx, desired, client.Apply, opts...); err != nil {
 		return false, err
 	}
 
-	// nothing to update, get back to reconcilation
-	if patchResult.IsEmpty() {
+	// apply of unchanged resource is no-op and does not bump the version
+	return desired.GetResourceVersion() != versionBefore, nil
+}
+
+// applies resource when wanted, otherwise deletes it if exists. returns
+// true when resource was created, changed or deleted
+func applyIf(ctx context.Context, c client.Client, desired client.Object,
+	wanted bool) (bool, error) {
+
+	if wanted {
+		return apply(ctx, c, desired)
+	}
+
+	err := c.Delete(ctx, desired)
+	if errors.IsNotFound(err) {
 		return false, nil
+	} else if err != nil {
+		return false, err
 	}
 
-	if err := r.Update(ctx, desired); err != nil {
+	return true, nil
+}
+
+// returns true when api server serves the given kind, e.g. when crd of
+// optional integration is installed. rest mapper is backed by discovery
+// and picks up crds installed after operator start
+func installed(c client.Client, gvk schema.GroupVersionKind) (bool, error) {
+	_, err := c.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	} else if err != nil {
 		return false, err
 	}
 
-	// resource is updated, get back to reconcilation
 	return true, nil
 }
 
@@ -90,3 +104,11 @@ func makeHash(data []byte) string {
 }
 
 func toPtr[V any](o V) *V { return &o }
+
+func equalPtr[V comparable](a, b *V) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
@@ -2,23 +2,40 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	wgtypes "golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
 	"github.com/cornbuddy/wireguard-operator/src/private/factory"
+	"github.com/cornbuddy/wireguard-operator/src/private/resolver"
+	"github.com/cornbuddy/wireguard-operator/src/private/wgquick"
+)
+
+const (
+	reasonReconciled      = "Reconciled"
+	reasonEndpointPending = "EndpointPending"
 )
 
 // WireguardReconciler reconciles a Wireguard object
@@ -26,6 +43,15 @@ type WireguardReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// Resolver for DNS server hostnames
+	Resolver resolver.Resolver
+	// How often DNS server hostnames are re-resolved
+	DNSRefreshInterval time.Duration
+	// Image of the operator, which pods install the agent from
+	OperatorImage string
+	// Namespace operator is running in, which network policies admit to
+	// the agent
+	OperatorNamespace string
 }
 
 //+kubebuilder:rbac:groups=vpn.ahova.com,resources=wireguards,verbs=get;list;watch;create;update;patch;delete
@@ -35,10 +61,15 @@ type WireguardReconciler struct {
 //+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=servicecidrs,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services/status,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors;podmonitors,verbs=get;list;watch;create;update;patch;delete
 
 func (r *WireguardReconciler) Reconcile(
 	ctx context.Context, req ctrl.Request) (
@@ -55,6 +86,7 @@ func (r *WireguardReconciler) Reconcile(
 		return empty, err
 	} else if apierrors.IsNotFound(err) {
 		log.Info("Must have been deleted, reconcilation is finished")
+		endpointWaits.DeleteLabelValues(req.Namespace, req.Name)
 		return empty, nil
 	}
 	log.Info("Successfully read wireguard from cluster")
@@ -68,23 +100,48 @@ func (r *WireguardReconciler) Reconcile(
 	log.Info("Peers list is fetched", "peers", peers.Items)
 
 	fact := factory.Wireguard{
-		Scheme:    r.Scheme,
-		Wireguard: *wireguard,
-		Peers:     peers,
+		Scheme:            r.Scheme,
+		Wireguard:         *wireguard,
+		Peers:             peers,
+		Resolver:          r.Resolver,
+		OperatorImage:     r.OperatorImage,
+		OperatorNamespace: r.OperatorNamespace,
+	}
+
+	// DNS
+	dns, err := fact.DNSServers()
+	if err != nil {
+		log.Error(err, "Cannot resolve DNS servers")
+		renderFailures.WithLabelValues("dns").Inc()
+		r.Recorder.Event(wireguard, corev1.EventTypeWarning,
+			reasonDNSResolutionFailed, err.Error())
+		return empty, err
 	}
+	log.Info("DNS servers are resolved", "dns", dns)
+
+	// Cluster networks
+	networks, err := r.getClusterNetworks(ctx, wireguard)
+	if err != nil {
+		log.Error(err, "Cannot discover cluster networks")
+		return empty, err
+	}
+	log.Info("Cluster networks are discovered", "networks", networks)
 
 	// Service
 	service, err := fact.Service()
 	if err != nil {
 		log.Error(err, "Cannot generate service")
+		renderFailures.WithLabelValues("service").Inc()
 		return empty, err
 	}
 
-	if applied, err := apply(ctx, r, service); err != nil {
+	if applied, err := applyPhase(ctx, r.Client, "service", service, true); err != nil {
 		log.Error(err, "Cannot apply service")
 		return empty, err
 	} else if applied {
 		log.Info("Service applied successfully")
+		r.Recorder.Event(wireguard, corev1.EventTypeNormal,
+			reasonServiceUpdated, "Service is applied")
 		return requeue, nil
 	}
 	log.Info("Service is up to date")
@@ -93,10 +150,11 @@ func (r *WireguardReconciler) Reconcile(
 	cm, err := fact.ConfigMap()
 	if err != nil {
 		log.Error(err, "Cannot generate configmap")
+		renderFailures.WithLabelValues("configmap").Inc()
 		return empty, err
 	}
 
-	if applied, err := apply(ctx, r, cm); err != nil {
+	if applied, err := applyPhase(ctx, r.Client, "configmap", cm, true); err != nil {
 		log.Error(err, "Cannot apply configmap")
 		return empty, err
 	} else if applied {
@@ -106,67 +164,145 @@ func (r *WireguardReconciler) Reconcile(
 	log.Info("Config map is up to date")
 
 	// Secret
-	var privateKey, publicKey string
-	key := types.NamespacedName{
-		Name:      wireguard.GetName(),
-		Namespace: wireguard.GetNamespace(),
-	}
-	currentSecret := &corev1.Secret{}
-	err = r.Get(ctx, key, currentSecret)
-	if apierrors.IsNotFound(err) {
-		// we need to create a new secret
-		key, err := wgtypes.GeneratePrivateKey()
-		if err != nil {
-			log.Error(err, "Cannot generate keypair")
-			return empty, err
-		}
-
-		privateKey = key.String()
-		publicKey = key.PublicKey().String()
-	} else if err != nil {
-		// unexpected error
-		log.Error(err, "Cannot fetch corresponding secret from cluster")
+	privateKey, publicKey, err := r.getKeypair(ctx, wireguard)
+	if err != nil {
+		log.Error(err, "Cannot get keypair")
 		return empty, err
-	} else {
-		// secret exists, so let's read keys from it
-		privateKey = string(currentSecret.Data["private-key"])
-		publicKey = string(currentSecret.Data["public-key"])
 	}
 	log.Info("Keypair is set")
 
 	desiredSecret, err := fact.Secret(publicKey, privateKey)
 	if err != nil {
 		log.Error(err, "Cannot generate secret")
+		renderFailures.WithLabelValues("secret").Inc()
 		return empty, err
 	}
 
-	if applied, err := apply(ctx, r, desiredSecret); err != nil {
+	if applied, err := applyPhase(ctx, r.Client, "secret", desiredSecret, true); err != nil {
 		log.Error(err, "Cannot apply secret")
 		return empty, err
 	} else if applied {
 		log.Info("Secret applied successfully")
+		r.Recorder.Event(wireguard, corev1.EventTypeNormal,
+			reasonSecretUpdated, "Secret is applied")
 		return requeue, nil
 	}
 	log.Info("Secret is up to date")
 
 	// Deployment
-	configHash := makeHash(desiredSecret.Data["config"])
+	// peers are applied live by the agent, so only interface changes roll
+	// the pods
+	configHash := makeHash(wgquick.InterfaceSection(desiredSecret.Data["config"]))
 	deploy, err := fact.Deployment(configHash)
 	if err != nil {
 		log.Error(err, "Cannot generate deployment")
+		renderFailures.WithLabelValues("deployment").Inc()
 		return empty, err
 	}
 
-	if applied, err := apply(ctx, r, deploy); err != nil {
+	if applied, err := applyPhase(ctx, r.Client, "deployment", deploy, true); err != nil {
 		log.Error(err, "Cannot apply deployment")
 		return empty, err
 	} else if applied {
 		log.Info("Deployment applied successfully")
+		r.Recorder.Event(wireguard, corev1.EventTypeNormal,
+			reasonDeploymentUpdated, "Deployment is applied")
 		return requeue, nil
 	}
 	log.Info("Deployment is up to date")
 
+	// PodDisruptionBudget
+	pdb, err := fact.PodDisruptionBudget()
+	if err != nil {
+		log.Error(err, "Cannot generate pod disruption budget")
+		renderFailures.WithLabelValues("poddisruptionbudget").Inc()
+		return empty, err
+	}
+
+	wantPdb := fact.PodDisruptionBudgetEnabled()
+	changed, err := applyPhase(ctx, r.Client, "poddisruptionbudget", pdb, wantPdb)
+	if err != nil {
+		log.Error(err, "Cannot apply pod disruption budget")
+		return empty, err
+	} else if changed {
+		log.Info("Pod disruption budget applied successfully")
+		return requeue, nil
+	}
+	log.Info("Pod disruption budget is up to date")
+
+	// NetworkPolicy
+	np, err := fact.NetworkPolicy()
+	if err != nil {
+		log.Error(err, "Cannot generate network policy")
+		renderFailures.WithLabelValues("networkpolicy").Inc()
+		return empty, err
+	}
+
+	wantNp := fact.NetworkPolicyEnabled()
+	if changed, err := applyPhase(ctx, r.Client, "networkpolicy", np, wantNp); err != nil {
+		log.Error(err, "Cannot apply network policy")
+		return empty, err
+	} else if changed {
+		log.Info("Network policy applied successfully")
+		return requeue, nil
+	}
+	log.Info("Network policy is up to date")
+
+	// Metrics service
+	metricsSvc, err := fact.MetricsService()
+	if err != nil {
+		log.Error(err, "Cannot generate metrics service")
+		renderFailures.WithLabelValues("metrics_service").Inc()
+		return empty, err
+	}
+
+	wantMetrics := fact.MetricsEnabled()
+	changed, err = applyPhase(ctx, r.Client, "metrics_service", metricsSvc, wantMetrics)
+	if err != nil {
+		log.Error(err, "Cannot apply metrics service")
+		return empty, err
+	} else if changed {
+		log.Info("Metrics service applied successfully")
+		return requeue, nil
+	}
+	log.Info("Metrics service is up to date")
+
+	// Monitors. those are not watched, as crds of prometheus operator
+	// might be absent when operator starts
+	for _, kind := range factory.MonitorKinds {
+		ok, err := installed(r.Client, factory.MonitorGVK(kind))
+		if err != nil {
+			log.Error(err, "Cannot discover monitor kind", "kind", kind)
+			return empty, err
+		} else if !ok {
+			log.Info("Prometheus operator is not installed, skipping",
+				"kind", kind)
+			continue
+		}
+
+		mon, err := fact.Monitor(kind)
+		if err != nil {
+			log.Error(err, "Cannot generate monitor", "kind", kind)
+			renderFailures.WithLabelValues("monitor").Inc()
+			return empty, err
+		}
+
+		wantMon := fact.MonitorEnabled(kind)
+		if changed, err := applyPhase(ctx, r.Client, "monitor", mon, wantMon); err != nil {
+			log.Error(err, "Cannot apply monitor", "kind", kind)
+			return empty, err
+		} else if changed {
+			log.Info("Monitor applied successfully", "kind", kind)
+			return requeue, nil
+		}
+		log.Info("Monitor is up to date", "kind", kind)
+	}
+
 	// Status
+	key := types.NamespacedName{
+		Name:      wireguard.GetName(),
+		Namespace: wireguard.GetNamespace(),
+	}
 	if err := r.Get(ctx, key, service); err != nil {
 		log.Error(err, "Cannot read service from the cluster")
 		return empty, err
@@ -176,7 +312,13 @@ func (r *WireguardReconciler) Reconcile(
 	ep, err := fact.ExtractEndpoint(*service)
 	if err == factory.ErrEndpointNotSet {
 		log.Info("Public ip not yet set, somehow expected")
-		return requeue, nil
+		endpointWaits.WithLabelValues(key.Namespace, key.Name).Inc()
+		return requeue, r.setCondition(ctx, key, metav1.Condition{
+			Type:    v1alpha1.WireguardConditionReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonEndpointPending,
+			Message: err.Error(),
+		})
 	} else if err != nil {
 		log.Error(err, "Cannot extract endpoint from service")
 		return empty, err
@@ -187,16 +329,33 @@ func (r *WireguardReconciler) Reconcile(
 		return empty, err
 	}
 
+	r.recordStatusChanges(wireguard, ep, publicKey)
 	wireguard.Status = v1alpha1.WireguardStatus{
-		Endpoint:  ep,
-		PublicKey: &publicKey,
+		Endpoint:        ep,
+		PublicKey:       &publicKey,
+		DNS:             dns,
+		ClusterNetworks: networks,
+		Conditions:      wireguard.Status.Conditions,
 	}
+	meta.SetStatusCondition(&wireguard.Status.Conditions, metav1.Condition{
+		Type:               v1alpha1.WireguardConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonReconciled,
+		ObservedGeneration: wireguard.GetGeneration(),
+	})
 	if err := r.Status().Update(ctx, wireguard); err != nil {
 		log.Error(err, "Cannot update status")
 		return empty, err
 	}
 	log.Info("Status is updated, reconcilation is finished")
 
+	spec := wireguard.Spec
+	hostnames := append([]string{spec.DNS}, spec.DNSServers...)
+	if r.DNSRefreshInterval > 0 && resolver.NeedsLookup(hostnames...) {
+		// DNS records might change, so coming back to pick them up
+		return ctrl.Result{RequeueAfter: r.DNSRefreshInterval}, nil
+	}
+
 	return empty, nil
 }
 
@@ -205,14 +364,303 @@ func (r *WireguardReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	predicates := builder.WithPredicates(
 		predicate.ResourceVersionChangedPredicate{},
 	)
-	return ctrl.NewControllerManagedBy(mgr).
+	bldr := ctrl.NewControllerManagedBy(mgr).
 		For(&v1alpha1.Wireguard{}).
 		Watches(&v1alpha1.WireguardPeer{}, handlers, predicates).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&corev1.Secret{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
-		Complete(r)
+		Owns(&policyv1.PodDisruptionBudget{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Watches(
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.findWireguardsForSecret),
+		).
+		Watches(
+			&corev1.Node{},
+			handler.EnqueueRequestsFromMapFunc(r.findWireguardsForNode),
+			builder.WithPredicates(podCIDRsChangedPredicate()),
+		)
+
+	// service networks are discovered only on clusters serving them
+	gvk := networkingv1.SchemeGroupVersion.WithKind("ServiceCIDR")
+	ok, err := installed(r.Client, gvk)
+	if err != nil {
+		return err
+	} else if ok {
+		bldr = bldr.Watches(
+			&networkingv1.ServiceCIDR{},
+			handler.EnqueueRequestsFromMapFunc(r.findWireguardsForServiceCIDR),
+		)
+	}
+
+	return bldr.Complete(r)
+}
+
+// Sets condition on the wireguard unless it's already set. Wireguard is
+// refetched to avoid update conflicts
+func (r *WireguardReconciler) setCondition(
+	ctx context.Context, key types.NamespacedName,
+	condition metav1.Condition) error {
+
+	wireguard := &v1alpha1.Wireguard{}
+	if err := r.Get(ctx, key, wireguard); err != nil {
+		return err
+	}
+
+	condition.ObservedGeneration = wireguard.GetGeneration()
+	if !meta.SetStatusCondition(&wireguard.Status.Conditions, condition) {
+		return nil
+	}
+
+	return r.Status().Update(ctx, wireguard)
+}
+
+// Emits events for the endpoint and public key changes, comparing current
+// status of the wireguard with the desired one
+func (r *WireguardReconciler) recordStatusChanges(
+	wireguard *v1alpha1.Wireguard, endpoint *string, publicKey string) {
+
+	status := wireguard.Status
+	if status.Endpoint == nil || *status.Endpoint != *endpoint {
+		r.Recorder.Eventf(wireguard, corev1.EventTypeNormal,
+			reasonEndpointDiscovered, "Endpoint is %s", *endpoint)
+	}
+
+	if status.PublicKey != nil && *status.PublicKey != publicKey {
+		r.Recorder.Eventf(wireguard, corev1.EventTypeNormal,
+			reasonKeyRotated, "Public key is rotated to %s, peers must "+
+				"pick up new configs", publicKey)
+	}
+}
+
+// Returns private and public keys of the wireguard. Keys are taken from
+// the secret referenced by .spec.privateKeyRef if set, otherwise from the
+// secret managed by operator. Generates new keypair when neither exists
+func (r *WireguardReconciler) getKeypair(
+	ctx context.Context, wireguard *v1alpha1.Wireguard) (
+	string, string, error) {
+
+	if ref := wireguard.Spec.PrivateKeyRef; ref != nil {
+		if ref.Name == wireguard.GetName() {
+			// managed secret is rewritten on every change, so it cannot
+			// be used as a source of truth for the private key
+			return "", "", fmt.Errorf(
+				"secret %s is managed by operator and cannot be referenced",
+				ref.Name,
+			)
+		}
+
+		privateKey, publicKey, err := r.getKeypairFromRef(
+			ctx, wireguard.GetNamespace(), *ref)
+		optional := ref.Optional != nil && *ref.Optional
+		if !(optional && apierrors.IsNotFound(err)) {
+			return privateKey, publicKey, err
+		}
+		// referenced secret is optional and absent, falling back to the
+		// managed keypair
+	}
+
+	key := types.NamespacedName{
+		Name:      wireguard.GetName(),
+		Namespace: wireguard.GetNamespace(),
+	}
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, key, secret)
+	if apierrors.IsNotFound(err) {
+		// we need to create a new secret
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return "", "", err
+		}
+
+		r.Recorder.Event(wireguard, corev1.EventTypeNormal,
+			reasonKeypairGenerated, "New keypair is generated")
+		return key.String(), key.PublicKey().String(), nil
+	} else if err != nil {
+		// unexpected error
+		return "", "", err
+	}
+
+	// secret exists, so let's read keys from it
+	privateKey := string(secret.Data["private-key"])
+	publicKey := string(secret.Data["public-key"])
+	return privateKey, publicKey, nil
+}
+
+// Reads private key from the referenced secret and derives public key
+// from it
+func (r *WireguardReconciler) getKeypairFromRef(
+	ctx context.Context, namespace string, ref corev1.SecretKeySelector) (
+	string, string, error) {
+
+	key := types.NamespacedName{
+		Name:      ref.Name,
+		Namespace: namespace,
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, key, secret); err != nil {
+		return "", "", err
+	}
+
+	data, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", "", fmt.Errorf("secret %s has no key %s", ref.Name, ref.Key)
+	}
+
+	privateKey, err := wgtypes.ParseKey(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", "", err
+	}
+
+	return privateKey.String(), privateKey.PublicKey().String(), nil
+}
+
+// Maps secret into requests for wireguards referencing it in
+// .spec.privateKeyRef, so key change is picked up immediately
+func (r *WireguardReconciler) findWireguardsForSecret(
+	ctx context.Context, secret client.Object) []reconcile.Request {
+
+	var wireguards v1alpha1.WireguardList
+	opts := &client.ListOptions{Namespace: secret.GetNamespace()}
+	if err := r.List(ctx, &wireguards, opts); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, wg := range wireguards.Items {
+		ref := wg.Spec.PrivateKeyRef
+		if ref == nil || ref.Name != secret.GetName() {
+			continue
+		}
+
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      wg.GetName(),
+				Namespace: wg.GetNamespace(),
+			},
+		})
+	}
+
+	return requests
+}
+
+// Maps node into requests for wireguards discovering cluster networks,
+// so peers are updated when nodes join or leave
+func (r *WireguardReconciler) findWireguardsForNode(
+	ctx context.Context, _ client.Object) []reconcile.Request {
+
+	return r.findWireguardsDiscovering(ctx,
+		func(cfg *v1alpha1.ClusterNetworks) bool {
+			return len(cfg.PodCIDRs) == 0
+		})
+}
+
+// Maps service cidr into requests for wireguards discovering cluster
+// networks, so peers are updated when service networks are extended
+func (r *WireguardReconciler) findWireguardsForServiceCIDR(
+	ctx context.Context, _ client.Object) []reconcile.Request {
+
+	return r.findWireguardsDiscovering(ctx,
+		func(cfg *v1alpha1.ClusterNetworks) bool {
+			return len(cfg.ServiceCIDRs) == 0
+		})
+}
+
+// Returns requests for wireguards with cluster networks enabled, which
+// discover networks according to the given function
+func (r *WireguardReconciler) findWireguardsDiscovering(
+	ctx context.Context,
+	discovers func(*v1alpha1.ClusterNetworks) bool) []reconcile.Request {
+
+	var wireguards v1alpha1.WireguardList
+	if err := r.List(ctx, &wireguards); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, wg := range wireguards.Items {
+		cfg := wg.Spec.ClusterNetworks
+		if cfg == nil || !cfg.Enabled || !discovers(cfg) {
+			continue
+		}
+
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      wg.GetName(),
+				Namespace: wg.GetNamespace(),
+			},
+		})
+	}
+
+	return requests
+}
+
+// Passes node events only when pod networks of the node change, as
+// status of the nodes is updated constantly
+func podCIDRsChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, ok := e.ObjectOld.(*corev1.Node)
+			if !ok {
+				return false
+			}
+
+			newNode, ok := e.ObjectNew.(*corev1.Node)
+			if !ok {
+				return false
+			}
+
+			return !slices.Equal(oldNode.Spec.PodCIDRs, newNode.Spec.PodCIDRs)
+		},
+	}
+}
+
+// Returns aggregated pod and service networks of the cluster, nil when
+// discovery is disabled. Pod networks are taken from the nodes and
+// service networks from ServiceCIDR resources, unless set explicitly
+func (r *WireguardReconciler) getClusterNetworks(
+	ctx context.Context, wg *v1alpha1.Wireguard) ([]string, error) {
+
+	cfg := wg.Spec.ClusterNetworks
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+
+	podCIDRs := cfg.PodCIDRs
+	if len(podCIDRs) == 0 {
+		var nodes corev1.NodeList
+		if err := r.List(ctx, &nodes); err != nil {
+			return nil, err
+		}
+
+		for _, node := range nodes.Items {
+			podCIDRs = append(podCIDRs, node.Spec.PodCIDRs...)
+		}
+	}
+
+	serviceCIDRs := cfg.ServiceCIDRs
+	if len(serviceCIDRs) == 0 {
+		gvk := networkingv1.SchemeGroupVersion.WithKind("ServiceCIDR")
+		ok, err := installed(r.Client, gvk)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			var cidrs networkingv1.ServiceCIDRList
+			if err := r.List(ctx, &cidrs); err != nil {
+				return nil, err
+			}
+
+			for _, cidr := range cidrs.Items {
+				serviceCIDRs = append(serviceCIDRs, cidr.Spec.CIDRs...)
+			}
+		}
+	}
+
+	return factory.AggregateNetworks(append(podCIDRs, serviceCIDRs...))
 }
 
 func (r *WireguardReconciler) getWireguard(
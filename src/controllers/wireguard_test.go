@@ -9,9 +9,17 @@ import (
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/storage/names"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/wgquick"
 	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
 )
 
@@ -195,7 +203,7 @@ func TestWireguardDeployment(t *testing.T) {
 		podAnnotations := deploy.Spec.Template.Annotations
 		assert.Contains(t, podAnnotations, "vpn.ahova.com/config-hash")
 
-		wantHash := makeHash(config)
+		wantHash := makeHash(wgquick.InterfaceSection(config))
 		gotHash := podAnnotations["vpn.ahova.com/config-hash"]
 		assert.Equal(t, wantHash, gotHash)
 
@@ -209,6 +217,38 @@ func TestWireguardDeployment(t *testing.T) {
 
 }
 
+func TestWireguardAutoscaledReplicas(t *testing.T) {
+	t.Parallel()
+
+	wg := dsl.GenerateWireguard(
+		v1alpha1.WireguardSpec{},
+		v1alpha1.WireguardStatus{},
+	)
+	err := wgDsl.Apply(ctx, &wg)
+	assert.Nil(t, err)
+
+	key := types.NamespacedName{
+		Name:      wg.GetName(),
+		Namespace: wg.GetNamespace(),
+	}
+	deploy := &appsv1.Deployment{}
+	err = k8sClient.Get(ctx, key, deploy)
+	assert.Nil(t, err)
+
+	// scale as horizontal pod autoscaler does
+	deploy.Spec.Replicas = toPtr[int32](3)
+	err = k8sClient.Update(ctx, deploy, client.FieldOwner("autoscaler"))
+	assert.Nil(t, err)
+
+	err = wgDsl.Reconcile(ctx, &wg)
+	assert.Nil(t, err)
+
+	err = k8sClient.Get(ctx, key, deploy)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), *deploy.Spec.Replicas,
+		"should keep replicas of the autoscaler")
+}
+
 func TestWireguardService(t *testing.T) {
 	t.Parallel()
 
@@ -252,6 +292,44 @@ func TestWireguardService(t *testing.T) {
 		assert.Equal(t, spec1.ServiceType, svc1.Spec.Type)
 		assert.Equal(t, spec2.ServiceType, svc2.Spec.Type)
 	})
+
+	o.Spec("should propagate labels and keep foreign annotations", func(t *testing.T) {
+		wg := dsl.GenerateWireguard(
+			v1alpha1.WireguardSpec{},
+			v1alpha1.WireguardStatus{},
+		)
+		err := wgDsl.Apply(ctx, &wg)
+		assert.Nil(t, err)
+
+		key := types.NamespacedName{
+			Name:      wg.GetName(),
+			Namespace: wg.GetNamespace(),
+		}
+		svc := &corev1.Service{}
+		err = k8sClient.Get(ctx, key, svc)
+		assert.Nil(t, err)
+
+		// simulating cloud controller annotating the service
+		patch := client.MergeFrom(svc.DeepCopy())
+		svc.SetAnnotations(map[string]string{"example.com/lb-id": "kekeke"})
+		err = k8sClient.Patch(ctx, svc, patch)
+		assert.Nil(t, err)
+
+		err = k8sClient.Get(ctx, key, &wg)
+		assert.Nil(t, err)
+
+		wg.Spec.Labels = map[string]string{"team": "vpn"}
+		err = k8sClient.Update(ctx, &wg)
+		assert.Nil(t, err)
+
+		err = wgDsl.Reconcile(ctx, &wg)
+		assert.Nil(t, err)
+
+		err = k8sClient.Get(ctx, key, svc)
+		assert.Nil(t, err)
+		assert.Equal(t, "vpn", svc.GetLabels()["team"])
+		assert.Equal(t, "kekeke", svc.GetAnnotations()["example.com/lb-id"])
+	})
 }
 
 func TestWireguardStatus(t *testing.T) {
@@ -306,6 +384,19 @@ func TestWireguardStatus(t *testing.T) {
 		wantPubKey := string(secret.Data["public-key"])
 		assert.Equal(t, wantPubKey, gotPubKey)
 	})
+
+	o.Spec("should contain resolved dns servers", func(t *testCtx) {
+		assert.Equal(t, []string{"1.1.1.1"}, t.Wg.Status.DNS)
+	})
+
+	o.Spec("should be ready", func(t *testCtx) {
+		cond := meta.FindStatusCondition(
+			t.Wg.Status.Conditions,
+			v1alpha1.WireguardConditionReady,
+		)
+		assert.NotNil(t, cond)
+		assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	})
 }
 
 func TestWireguardConfigMap(t *testing.T) {
@@ -349,3 +440,152 @@ func TestWireguardConfigMap(t *testing.T) {
 		spec.Entry(tc.description, tc)
 	}
 }
+
+func TestWireguardPrivateKeyRef(t *testing.T) {
+	t.Parallel()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	assert.Nil(t, err)
+
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      names.SimpleNameGenerator.GenerateName("existing-key-"),
+			Namespace: corev1.NamespaceDefault,
+		},
+		Data: map[string][]byte{
+			"wg0.key": []byte(key.String()),
+		},
+	}
+	err = k8sClient.Create(ctx, existing)
+	assert.Nil(t, err)
+
+	wg := dsl.GenerateWireguard(
+		v1alpha1.WireguardSpec{
+			PrivateKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: existing.GetName(),
+				},
+				Key: "wg0.key",
+			},
+		},
+		v1alpha1.WireguardStatus{},
+	)
+	err = wgDsl.Apply(ctx, &wg)
+	assert.Nil(t, err)
+
+	wantPubKey := key.PublicKey().String()
+	assert.Equal(t, wantPubKey, *wg.Status.PublicKey)
+
+	wgKey := types.NamespacedName{
+		Name:      wg.GetName(),
+		Namespace: wg.GetNamespace(),
+	}
+	secret := &corev1.Secret{}
+	err = k8sClient.Get(ctx, wgKey, secret)
+	assert.Nil(t, err)
+	assert.Equal(t, key.String(), string(secret.Data["private-key"]))
+	assert.Equal(t, wantPubKey, string(secret.Data["public-key"]))
+
+	existingKey := types.NamespacedName{
+		Name:      existing.GetName(),
+		Namespace: existing.GetNamespace(),
+	}
+	gotExisting := &corev1.Secret{}
+	err = k8sClient.Get(ctx, existingKey, gotExisting)
+	assert.Nil(t, err)
+	assert.Equal(t, existing.Data, gotExisting.Data,
+		"referenced secret should not be modified")
+	assert.Empty(t, gotExisting.GetOwnerReferences())
+}
+
+func TestWireguardClusterNetworks(t *testing.T) {
+	t.Parallel()
+
+	wg := dsl.GenerateWireguard(
+		v1alpha1.WireguardSpec{
+			ClusterNetworks: &v1alpha1.ClusterNetworks{
+				Enabled:      true,
+				PodCIDRs:     []string{"10.244.1.0/24", "10.244.0.0/24"},
+				ServiceCIDRs: []string{"10.96.0.0/12"},
+			},
+		},
+		v1alpha1.WireguardStatus{},
+	)
+	err := wgDsl.Apply(ctx, &wg)
+	assert.Nil(t, err)
+	assert.Equal(t,
+		[]string{"10.96.0.0/12", "10.244.0.0/23"},
+		wg.Status.ClusterNetworks)
+}
+
+func TestWireguardPolicies(t *testing.T) {
+	t.Parallel()
+
+	wg := dsl.GenerateWireguard(
+		v1alpha1.WireguardSpec{
+			Replicas: toPtr[int32](2),
+			PodDisruptionBudget: &v1alpha1.PodDisruptionBudget{
+				Enabled: true,
+			},
+			NetworkPolicy: &v1alpha1.NetworkPolicy{Enabled: true},
+		},
+		v1alpha1.WireguardStatus{},
+	)
+	err := wgDsl.Apply(ctx, &wg)
+	assert.Nil(t, err)
+
+	key := types.NamespacedName{
+		Name:      wg.GetName(),
+		Namespace: wg.GetNamespace(),
+	}
+	err = k8sClient.Get(ctx, key, &policyv1.PodDisruptionBudget{})
+	assert.Nil(t, err, "should create pod disruption budget")
+
+	err = k8sClient.Get(ctx, key, &networkingv1.NetworkPolicy{})
+	assert.Nil(t, err, "should create network policy")
+
+	wg.Spec.PodDisruptionBudget.Enabled = false
+	wg.Spec.NetworkPolicy.Enabled = false
+	err = k8sClient.Update(ctx, &wg)
+	assert.Nil(t, err)
+
+	err = wgDsl.Reconcile(ctx, &wg)
+	assert.Nil(t, err)
+
+	err = k8sClient.Get(ctx, key, &policyv1.PodDisruptionBudget{})
+	assert.True(t, apierrors.IsNotFound(err),
+		"should delete pod disruption budget")
+
+	err = k8sClient.Get(ctx, key, &networkingv1.NetworkPolicy{})
+	assert.True(t, apierrors.IsNotFound(err), "should delete network policy")
+}
+
+func TestWireguardMetrics(t *testing.T) {
+	t.Parallel()
+
+	wg := dsl.GenerateWireguard(
+		v1alpha1.WireguardSpec{
+			Metrics: &v1alpha1.Metrics{Enabled: true},
+		},
+		v1alpha1.WireguardStatus{},
+	)
+	err := wgDsl.Apply(ctx, &wg)
+	assert.Nil(t, err, "should reconcile without prometheus operator")
+
+	key := types.NamespacedName{
+		Name:      fmt.Sprintf("%s-metrics", wg.GetName()),
+		Namespace: wg.GetNamespace(),
+	}
+	err = k8sClient.Get(ctx, key, &corev1.Service{})
+	assert.Nil(t, err, "should create metrics service")
+
+	wg.Spec.Metrics.Enabled = false
+	err = k8sClient.Update(ctx, &wg)
+	assert.Nil(t, err)
+
+	err = wgDsl.Reconcile(ctx, &wg)
+	assert.Nil(t, err)
+
+	err = k8sClient.Get(ctx, key, &corev1.Service{})
+	assert.True(t, apierrors.IsNotFound(err), "should delete metrics service")
+}
@@ -19,4 +19,7 @@ target "wireguard-operator" {
   cache-to   = ["type=inline,mod=max"]
   cache-from = ["type=registry,ref=${IMAGE}:${CACHE_FROM}"]
   tags       = ["${IMAGE}:${TAG}"]
+  args       = {
+    VERSION = TAG
+  }
 }
@@ -2,7 +2,9 @@ package main
 
 import (
 	"flag"
+	"net/http"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -14,10 +16,14 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	vpnv1alpha1 "github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	vpnv1beta1 "github.com/cornbuddy/wireguard-operator/src/api/v1beta1"
 	"github.com/cornbuddy/wireguard-operator/src/controllers"
+	"github.com/cornbuddy/wireguard-operator/src/private/factory"
+	"github.com/cornbuddy/wireguard-operator/src/private/resolver"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -30,15 +36,47 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(vpnv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(vpnv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
+// Version of the operator, set at build time to the tag of its image
+var version = "latest"
+
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var dnsRefreshInterval time.Duration
+	var managerMonitor bool
+	var conversionWebhook bool
+	var selfServiceAddr string
+	var selfServiceCertDir string
+	var quotaInterval time.Duration
+	var operatorImage string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":9081", "Address of the metrics endpoint")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "Address of the healthz endpoint")
+	flag.DurationVar(&dnsRefreshInterval, "dns-refresh-interval", 5*time.Minute,
+		"How often hostnames of DNS servers are resolved again")
+	flag.BoolVar(&managerMonitor, "manager-monitor", false,
+		"Create pod monitor for the metrics endpoint of the operator. "+
+			"Requires prometheus operator and POD_NAMESPACE environment variable")
+	flag.BoolVar(&conversionWebhook, "conversion-webhook", true,
+		"Serve conversion webhook between API versions. "+
+			"Requires serving certificate, disable when running locally")
+	flag.StringVar(&selfServiceAddr, "self-service-bind-address", "",
+		"Address of the self-service API, which lets peer owners download their "+
+			"configuration. Disabled when empty")
+	flag.StringVar(&selfServiceCertDir, "self-service-cert-dir", "",
+		"Directory with tls.crt and tls.key of the self-service API. "+
+			"Plain HTTP is served when empty, kubernetes tokens are rejected then")
+	flag.DurationVar(&quotaInterval, "quota-interval", time.Minute,
+		"How often traffic of the peers with quota is accounted. "+
+			"Quotas are not enforced when zero")
+	flag.StringVar(&operatorImage, "operator-image", defaultOperatorImage(),
+		"Image of the operator, which wireguard pods install the agent from. "+
+			"Defaults to OPERATOR_IMAGE environment variable, or to the image of "+
+			"the operator version")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager")
@@ -64,10 +102,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	// shared between controllers, so peers and wireguards render the same
+	// addresses
+	dnsResolver := resolver.NewCached(resolver.Net{}, dnsRefreshInterval)
+
 	if err = (&controllers.WireguardPeerReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		Recorder: mgr.GetEventRecorderFor("wireguard-peer-controller"),
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Recorder:           mgr.GetEventRecorderFor("wireguard-peer-controller"),
+		Resolver:           dnsResolver,
+		DNSRefreshInterval: dnsRefreshInterval,
 	}).SetupWithManager(mgr); err != nil {
 		log.Error(err, "unable to create controller", "controller", "WireguardPeer")
 		os.Exit(1)
@@ -75,15 +119,87 @@ func main() {
 	//+kubebuilder:scaffold:builder
 
 	if err = (&controllers.WireguardReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		Recorder: mgr.GetEventRecorderFor("wireguard-controller"),
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Recorder:           mgr.GetEventRecorderFor("wireguard-controller"),
+		Resolver:           dnsResolver,
+		DNSRefreshInterval: dnsRefreshInterval,
+		OperatorImage:      operatorImage,
+		OperatorNamespace:  os.Getenv("POD_NAMESPACE"),
 	}).SetupWithManager(mgr); err != nil {
 		log.Error(err, "unable to create controller", "controller", "Wireguard")
 		os.Exit(1)
 	}
 	//+kubebuilder:scaffold:builder
 
+	if err = (&controllers.WireguardClientReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		Recorder:      mgr.GetEventRecorderFor("wireguard-client-controller"),
+		OperatorImage: operatorImage,
+	}).SetupWithManager(mgr); err != nil {
+		log.Error(err, "unable to create controller", "controller", "WireguardClient")
+		os.Exit(1)
+	}
+	//+kubebuilder:scaffold:builder
+
+	if conversionWebhook {
+		for _, obj := range []runtime.Object{
+			&vpnv1alpha1.Wireguard{},
+			&vpnv1alpha1.WireguardPeer{},
+		} {
+			if err := ctrl.NewWebhookManagedBy(mgr).For(obj).Complete(); err != nil {
+				log.Error(err, "unable to create conversion webhook")
+				os.Exit(1)
+			}
+		}
+	}
+
+	metrics.Registry.MustRegister(controllers.NewCollector(mgr.GetClient()))
+
+	if managerMonitor {
+		namespace := os.Getenv("POD_NAMESPACE")
+		if namespace == "" {
+			log.Error(nil, "POD_NAMESPACE must be set to create pod monitor")
+			os.Exit(1)
+		}
+
+		if err := mgr.Add(&controllers.ManagerMonitor{
+			Client:    mgr.GetClient(),
+			Namespace: namespace,
+			Selector:  factory.OperatorLabels(),
+		}); err != nil {
+			log.Error(err, "unable to set up pod monitor")
+			os.Exit(1)
+		}
+	}
+
+	if selfServiceAddr != "" {
+		if err := mgr.Add(&controllers.SelfService{
+			Client:      mgr.GetClient(),
+			Recorder:    mgr.GetEventRecorderFor("wireguard-self-service"),
+			BindAddress: selfServiceAddr,
+			CertDir:     selfServiceCertDir,
+		}); err != nil {
+			log.Error(err, "unable to set up self-service API")
+			os.Exit(1)
+		}
+	}
+
+	if quotaInterval > 0 {
+		if err := mgr.Add(&controllers.QuotaAccountant{
+			Client:   mgr.GetClient(),
+			Recorder: mgr.GetEventRecorderFor("wireguard-quota-accountant"),
+			Transfer: &controllers.AgentMetrics{
+				Client: &http.Client{Timeout: 10 * time.Second},
+			},
+			Interval: quotaInterval,
+		}); err != nil {
+			log.Error(err, "unable to set up quota accountant")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		log.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -99,3 +215,13 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// Returns image of the operator from the environment, or the released image
+// of the same version, so agent matches the operator rendering its config
+func defaultOperatorImage() string {
+	if image := os.Getenv("OPERATOR_IMAGE"); image != "" {
+		return image
+	}
+
+	return "ghcr.io/cornbuddy/wireguard-operator:" + version
+}
@@ -0,0 +1,429 @@
+// Package agent manages wireguard interface inside the pod: brings it up
+// from the mounted wg-quick config, applies config changes to the running
+// interface and tears it down on shutdown
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/cornbuddy/wireguard-operator/src/private/wgquick"
+)
+
+const (
+	// Table and firewall mark of the default routes, same as wg-quick uses
+	defaultTable = 51820
+	// Secret volumes are updated by swapping symlinks, which is a burst of
+	// events
+	debounce = time.Second
+	// Endpoints of the peers might be hostnames, so config is re-applied
+	// even without changes of the file
+	resync = 5 * time.Minute
+)
+
+type Agent struct {
+	// Name of the wireguard interface
+	Device string
+	// Path of the wg-quick config
+	ConfigPath string
+	// Path of the rules depending on the peers, one shell command per line.
+	// Rules are run on every change, so those must reset the previous ones.
+	// Optional, missing file means no rules
+	RulesPath string
+	Log       logr.Logger
+
+	// serializes changes of the interface, which run hooks and take long
+	mu           sync.Mutex
+	rtnl         *rtnl
+	index        int
+	applied      []byte
+	appliedRules []byte
+	metrics      *metrics
+
+	// guards wireguard client only, so health and metrics handlers are
+	// not blocked by the hooks
+	wgMu sync.Mutex
+	wg   *wgctrl.Client
+}
+
+func New(device, configPath, rulesPath string, log logr.Logger) (*Agent, error) {
+	wg, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+
+	rtnl, err := dialRtnl()
+	if err != nil {
+		wg.Close()
+		return nil, err
+	}
+
+	return &Agent{
+		Device:     device,
+		ConfigPath: configPath,
+		RulesPath:  rulesPath,
+		Log:        log,
+		wg:         wg,
+		rtnl:       rtnl,
+		metrics:    newMetrics(),
+	}, nil
+}
+
+func (a *Agent) Close() error {
+	return errors.Join(a.wg.Close(), a.rtnl.Close())
+}
+
+// Brings the interface up and keeps applying config changes until context
+// is cancelled, then brings the interface down
+func (a *Agent) Run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// file itself is a symlink replaced on update, so directory is watched
+	if err := watcher.Add(filepath.Dir(a.ConfigPath)); err != nil {
+		return err
+	}
+
+	if err := a.Up(ctx); err != nil {
+		return err
+	}
+	a.Log.Info("Wireguard is up", "device", a.Device)
+
+	timer := time.NewTimer(resync)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			a.Log.Info("Shutting down wireguard", "device", a.Device)
+			return a.Down(context.Background())
+		case err := <-watcher.Errors:
+			a.Log.Error(err, "Cannot watch config")
+		case <-watcher.Events:
+			timer.Reset(debounce)
+		case <-timer.C:
+			if err := a.Reload(ctx); err != nil {
+				a.Log.Error(err, "Cannot apply config")
+			}
+			timer.Reset(resync)
+		}
+	}
+}
+
+// Creates the interface from the config and runs the hooks
+func (a *Agent) Up(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	raw, cfg, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+
+	if err := a.runHooks(ctx, cfg.Interface.PreUp); err != nil {
+		return err
+	}
+
+	a.index, err = a.rtnl.createLink(a.Device)
+	if err != nil {
+		return fmt.Errorf("cannot create link: %w", err)
+	}
+
+	for _, address := range cfg.Interface.Addresses {
+		if err := a.rtnl.addAddress(a.index, address); err != nil {
+			return fmt.Errorf("cannot add address %s: %w", address, err)
+		}
+	}
+
+	if err := a.rtnl.setUp(a.index, cfg.Interface.MTU); err != nil {
+		return fmt.Errorf("cannot bring link up: %w", err)
+	}
+
+	if err := a.configure(cfg); err != nil {
+		return err
+	}
+
+	if err := a.runHooks(ctx, cfg.Interface.PostUp); err != nil {
+		return err
+	}
+
+	rules, err := a.readRules()
+	if err != nil {
+		return err
+	}
+
+	if err := a.runHooks(ctx, commands(rules)); err != nil {
+		return err
+	}
+
+	a.applied = raw
+	a.appliedRules = rules
+	return nil
+}
+
+// Applies changed peers to the running interface. Interface settings are
+// applied only by Up, operator restarts the pod once those change
+func (a *Agent) Reload(ctx context.Context) (err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	defer func() { a.metrics.observeReload(err) }()
+
+	raw, cfg, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+
+	changed := !bytes.Equal(raw, a.applied)
+	if changed && !bytes.Equal(wgquick.InterfaceSection(raw),
+		wgquick.InterfaceSection(a.applied)) {
+		a.Log.Info("Interface settings are changed, those are applied on restart")
+	}
+
+	if err := a.configure(cfg); err != nil {
+		return err
+	}
+
+	if changed {
+		a.applied = raw
+		a.Log.Info("Config is applied", "peers", len(cfg.Device.Peers))
+	}
+
+	rules, err := a.readRules()
+	if err != nil {
+		return err
+	}
+
+	// rules are reset by themselves, so those are not re-run on resync
+	if bytes.Equal(rules, a.appliedRules) {
+		return nil
+	}
+
+	if err := a.runHooks(ctx, commands(rules)); err != nil {
+		return err
+	}
+
+	a.appliedRules = rules
+	a.Log.Info("Rules are applied")
+	return nil
+}
+
+// Runs down hooks and deletes the interface
+func (a *Agent) Down(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, cfg, err := a.readConfig()
+	if err != nil {
+		// interface must be deleted anyway
+		a.Log.Error(err, "Cannot read config, hooks are skipped")
+	}
+
+	return errors.Join(
+		a.runHooks(ctx, cfg.Interface.PreDown),
+		a.rtnl.deleteLink(a.Device),
+		a.runHooks(ctx, cfg.Interface.PostDown),
+	)
+}
+
+// Returns whether the interface is up and readable
+func (a *Agent) Healthy() error {
+	_, err := a.device()
+	return err
+}
+
+func (a *Agent) device() (*wgtypes.Device, error) {
+	a.wgMu.Lock()
+	defer a.wgMu.Unlock()
+
+	return a.wg.Device(a.Device)
+}
+
+func (a *Agent) configureDevice(cfg wgtypes.Config) error {
+	a.wgMu.Lock()
+	defer a.wgMu.Unlock()
+
+	return a.wg.ConfigureDevice(a.Device, cfg)
+}
+
+func (a *Agent) readConfig() ([]byte, wgquick.Config, error) {
+	raw, err := os.ReadFile(a.ConfigPath)
+	if err != nil {
+		return nil, wgquick.Config{}, err
+	}
+
+	cfg, err := wgquick.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return nil, wgquick.Config{}, err
+	}
+
+	return raw, cfg, nil
+}
+
+// Returns rules, empty when those are not configured
+func (a *Agent) readRules() ([]byte, error) {
+	if a.RulesPath == "" {
+		return []byte{}, nil
+	}
+
+	raw, err := os.ReadFile(a.RulesPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return []byte{}, nil
+	}
+
+	return raw, err
+}
+
+// Configures wireguard device and routes to the peers
+func (a *Agent) configure(cfg wgquick.Config) error {
+	routes, defaults := peerRoutes(cfg)
+	device := cfg.Device
+	if len(defaults) > 0 && device.FirewallMark == nil {
+		// traffic of the tunnel itself must not be routed into the tunnel
+		device.FirewallMark = toPtr(defaultTable)
+	}
+
+	current, err := a.device()
+	if err != nil {
+		return err
+	}
+	device.Peers = syncPeers(current.Peers, device.Peers)
+	device.ReplacePeers = false
+
+	if err := a.configureDevice(device); err != nil {
+		return fmt.Errorf("cannot configure device: %w", err)
+	}
+
+	for _, route := range routes {
+		if err := a.rtnl.addRoute(a.index, route, mainTable); err != nil {
+			return fmt.Errorf("cannot add route %s: %w", route, err)
+		}
+	}
+
+	for _, route := range defaults {
+		if err := a.rtnl.addRoute(a.index, route, defaultTable); err != nil {
+			return fmt.Errorf("cannot add route %s: %w", route, err)
+		}
+
+		ipv4 := route.Addr().Is4()
+		if err := a.rtnl.addDefaultRules(ipv4, *device.FirewallMark, defaultTable); err != nil {
+			return fmt.Errorf("cannot add rules of %s: %w", route, err)
+		}
+	}
+
+	return nil
+}
+
+// Runs hooks with %i replaced by the interface name, same as wg-quick does
+func (a *Agent) runHooks(ctx context.Context, hooks []string) error {
+	for _, hook := range hooks {
+		command := strings.ReplaceAll(hook, "%i", a.Device)
+		out, err := exec.CommandContext(ctx, "/bin/sh", "-c", command).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("hook %q failed: %w: %s", command, err, out)
+		}
+		a.Log.Info("Hook is executed", "command", command)
+	}
+
+	return nil
+}
+
+// Returns commands of the rules skipping empty lines and comments
+func commands(rules []byte) []string {
+	var result []string
+	for _, line := range strings.Split(string(rules), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		result = append(result, line)
+	}
+
+	return result
+}
+
+// Returns peers removing ones absent in desired config. Replacing all peers
+// at once would drop sessions of the unchanged ones, as wg syncconf avoids
+func syncPeers(current []wgtypes.Peer, desired []wgtypes.PeerConfig) []wgtypes.PeerConfig {
+	wanted := map[wgtypes.Key]bool{}
+	for _, peer := range desired {
+		wanted[peer.PublicKey] = true
+	}
+
+	result := slices.Clone(desired)
+	for _, peer := range current {
+		if !wanted[peer.PublicKey] {
+			result = append(result, wgtypes.PeerConfig{
+				PublicKey: peer.PublicKey,
+				Remove:    true,
+			})
+		}
+	}
+
+	return result
+}
+
+// Routing table of the main routes
+const mainTable = 254
+
+// Returns routes to the allowed IPs of the peers, which are not covered by
+// addresses of the interface. Default routes are returned separately, as
+// those are routed through own table
+func peerRoutes(cfg wgquick.Config) (routes, defaults []netip.Prefix) {
+	covered := func(prefix netip.Prefix) bool {
+		for _, address := range cfg.Interface.Addresses {
+			if address.Bits() <= prefix.Bits() && address.Contains(prefix.Addr()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, peer := range cfg.Device.Peers {
+		for _, ipNet := range peer.AllowedIPs {
+			prefix := toPrefix(ipNet)
+			switch {
+			case prefix.Bits() == 0:
+				defaults = append(defaults, prefix)
+			case !covered(prefix):
+				routes = append(routes, prefix)
+			}
+		}
+	}
+
+	compare := func(a, b netip.Prefix) int {
+		if c := b.Bits() - a.Bits(); c != 0 {
+			return c
+		}
+		return a.Addr().Compare(b.Addr())
+	}
+	slices.SortFunc(routes, compare)
+	slices.SortFunc(defaults, compare)
+
+	return slices.Compact(routes), slices.Compact(defaults)
+}
+
+func toPrefix(ipNet net.IPNet) netip.Prefix {
+	addr, _ := netip.AddrFromSlice(ipNet.IP)
+	ones, _ := ipNet.Mask.Size()
+	return netip.PrefixFrom(addr.Unmap(), ones)
+}
+
+func toPtr[V any](o V) *V { return &o }
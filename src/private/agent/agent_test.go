@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/cornbuddy/wireguard-operator/src/private/wgquick"
+)
+
+func TestPeerRoutes(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		description  string
+		addresses    []string
+		allowedIPs   [][]string
+		wantRoutes   []netip.Prefix
+		wantDefaults []netip.Prefix
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		cfg := wgquick.Config{}
+		for _, address := range tc.addresses {
+			cfg.Interface.Addresses = append(cfg.Interface.Addresses,
+				netip.MustParsePrefix(address))
+		}
+		for _, ips := range tc.allowedIPs {
+			peer := wgtypes.PeerConfig{}
+			for _, ip := range ips {
+				_, network, err := net.ParseCIDR(ip)
+				assert.Nil(t, err)
+				peer.AllowedIPs = append(peer.AllowedIPs, *network)
+			}
+			cfg.Device.Peers = append(cfg.Device.Peers, peer)
+		}
+
+		routes, defaults := peerRoutes(cfg)
+		assert.Equal(t, tc.wantRoutes, routes)
+		assert.Equal(t, tc.wantDefaults, defaults)
+	})
+
+	spec.Entry("peers inside of the interface network", testCase{
+		addresses:  []string{"10.8.0.1/24"},
+		allowedIPs: [][]string{{"10.8.0.2/32"}, {"10.8.0.3/32"}},
+	})
+	spec.Entry("networks behind the peers", testCase{
+		addresses:  []string{"10.8.0.1/24"},
+		allowedIPs: [][]string{{"10.8.0.2/32", "192.168.0.0/24"}, {"10.0.0.0/8"}},
+		wantRoutes: []netip.Prefix{
+			netip.MustParsePrefix("192.168.0.0/24"),
+			netip.MustParsePrefix("10.0.0.0/8"),
+		},
+	})
+	spec.Entry("duplicated networks", testCase{
+		addresses:  []string{"10.8.0.1/24"},
+		allowedIPs: [][]string{{"192.168.0.0/24"}, {"192.168.0.0/24"}},
+		wantRoutes: []netip.Prefix{netip.MustParsePrefix("192.168.0.0/24")},
+	})
+	spec.Entry("default routes", testCase{
+		addresses:  []string{"10.8.0.2/32"},
+		allowedIPs: [][]string{{"0.0.0.0/0", "::/0"}},
+		wantDefaults: []netip.Prefix{
+			netip.MustParsePrefix("0.0.0.0/0"),
+			netip.MustParsePrefix("::/0"),
+		},
+	})
+}
+
+func TestSyncPeers(t *testing.T) {
+	t.Parallel()
+
+	key := func() wgtypes.Key {
+		k, err := wgtypes.GeneratePrivateKey()
+		assert.Nil(t, err)
+		return k.PublicKey()
+	}
+	kept, added, removed := key(), key(), key()
+
+	current := []wgtypes.Peer{{PublicKey: kept}, {PublicKey: removed}}
+	desired := []wgtypes.PeerConfig{{PublicKey: kept}, {PublicKey: added}}
+
+	want := []wgtypes.PeerConfig{
+		{PublicKey: kept},
+		{PublicKey: added},
+		{PublicKey: removed, Remove: true},
+	}
+	assert.Equal(t, want, syncPeers(current, desired))
+}
+
+func TestCommands(t *testing.T) {
+	t.Parallel()
+
+	rules := []byte("# isolation\niptables --flush WIREGUARD-PEERS\n\n" +
+		"  iptables --append WIREGUARD-PEERS --jump ACCEPT  \n")
+	want := []string{
+		"iptables --flush WIREGUARD-PEERS",
+		"iptables --append WIREGUARD-PEERS --jump ACCEPT",
+	}
+	assert.Equal(t, want, commands(rules))
+	assert.Empty(t, commands([]byte{}))
+}
+
+func TestHealthyWhileChanging(t *testing.T) {
+	t.Parallel()
+
+	wg, err := wgctrl.New()
+	if err != nil {
+		t.Skipf("wireguard client is not available: %v", err)
+	}
+	defer wg.Close()
+
+	a := &Agent{Device: "wgtest0", wg: wg}
+	// Up and Reload hold the lock while hooks run
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	done := make(chan error)
+	go func() { done <- a.Healthy() }()
+
+	select {
+	case err := <-done:
+		assert.NotNil(t, err, "should report missing interface")
+	case <-time.After(5 * time.Second):
+		t.Error("health check is blocked by the hooks")
+	}
+}
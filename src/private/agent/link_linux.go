@@ -0,0 +1,207 @@
+package agent
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Configures links, addresses, routes and rules over rtnetlink
+type rtnl struct {
+	conn *netlink.Conn
+}
+
+func dialRtnl() (*rtnl, error) {
+	conn, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rtnl{conn: conn}, nil
+}
+
+func (r *rtnl) Close() error {
+	return r.conn.Close()
+}
+
+// Creates wireguard link, replacing the one left by previous run of the
+// container in the same network namespace. Returns index of the link
+func (r *rtnl) createLink(name string) (int, error) {
+	if err := r.deleteLink(name); err != nil {
+		return 0, err
+	}
+
+	ae := netlink.NewAttributeEncoder()
+	ae.String(unix.IFLA_IFNAME, name)
+	ae.Nested(unix.IFLA_LINKINFO, func(nae *netlink.AttributeEncoder) error {
+		nae.String(unix.IFLA_INFO_KIND, "wireguard")
+		return nil
+	})
+	attrs, err := ae.Encode()
+	if err != nil {
+		return 0, err
+	}
+
+	flags := netlink.Create | netlink.Excl
+	if err := r.execute(unix.RTM_NEWLINK, flags, ifInfoMsg(0, 0), attrs); err != nil {
+		return 0, err
+	}
+
+	link, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return link.Index, nil
+}
+
+// Deletes the link if it exists
+func (r *rtnl) deleteLink(name string) error {
+	link, err := net.InterfaceByName(name)
+	if err != nil {
+		// link does not exist
+		return nil
+	}
+
+	return r.execute(unix.RTM_DELLINK, 0, ifInfoMsg(link.Index, 0), nil)
+}
+
+// Sets MTU of the link unless zero and brings it up
+func (r *rtnl) setUp(index, mtu int) error {
+	ae := netlink.NewAttributeEncoder()
+	if mtu > 0 {
+		ae.Uint32(unix.IFLA_MTU, uint32(mtu))
+	}
+	attrs, err := ae.Encode()
+	if err != nil {
+		return err
+	}
+
+	return r.execute(unix.RTM_NEWLINK, 0, ifInfoMsg(index, unix.IFF_UP), attrs)
+}
+
+func (r *rtnl) addAddress(index int, prefix netip.Prefix) error {
+	// struct ifaddrmsg
+	msg := make([]byte, unix.SizeofIfAddrmsg)
+	msg[0] = family(prefix)
+	msg[1] = byte(prefix.Bits())
+	binary.NativeEndian.PutUint32(msg[4:], uint32(index))
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.IFA_LOCAL, prefix.Addr().AsSlice())
+	ae.Bytes(unix.IFA_ADDRESS, prefix.Addr().AsSlice())
+	attrs, err := ae.Encode()
+	if err != nil {
+		return err
+	}
+
+	return r.execute(unix.RTM_NEWADDR, netlink.Create|netlink.Replace, msg, attrs)
+}
+
+// Routes prefix through the link in the given table
+func (r *rtnl) addRoute(index int, prefix netip.Prefix, table int) error {
+	// struct rtmsg
+	msg := make([]byte, unix.SizeofRtMsg)
+	msg[0] = family(prefix)
+	msg[1] = byte(prefix.Bits())
+	msg[4] = unix.RT_TABLE_UNSPEC
+	msg[5] = unix.RTPROT_BOOT
+	msg[6] = unix.RT_SCOPE_LINK
+	msg[7] = unix.RTN_UNICAST
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.RTA_DST, prefix.Masked().Addr().AsSlice())
+	ae.Uint32(unix.RTA_OIF, uint32(index))
+	ae.Uint32(unix.RTA_TABLE, uint32(table))
+	attrs, err := ae.Encode()
+	if err != nil {
+		return err
+	}
+
+	return r.execute(unix.RTM_NEWROUTE, netlink.Create|netlink.Replace, msg, attrs)
+}
+
+// Adds rules routing traffic without the mark through the table, while
+// more specific routes of the main table still take precedence. Same as
+// wg-quick does for default routes
+func (r *rtnl) addDefaultRules(ipv4 bool, mark, table int) error {
+	fam := byte(unix.AF_INET6)
+	if ipv4 {
+		fam = unix.AF_INET
+	}
+
+	// not fwmark <mark> table <table>
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(unix.FRA_FWMARK, uint32(mark))
+	ae.Uint32(unix.FRA_TABLE, uint32(table))
+	attrs, err := ae.Encode()
+	if err != nil {
+		return err
+	}
+	err = r.execute(unix.RTM_NEWRULE, netlink.Create|netlink.Excl,
+		fibRuleHdr(fam, unix.FIB_RULE_INVERT), attrs)
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return err
+	}
+
+	// table main suppress_prefixlength 0
+	ae = netlink.NewAttributeEncoder()
+	ae.Uint32(unix.FRA_TABLE, unix.RT_TABLE_MAIN)
+	ae.Uint32(unix.FRA_SUPPRESS_PREFIXLEN, 0)
+	attrs, err = ae.Encode()
+	if err != nil {
+		return err
+	}
+	err = r.execute(unix.RTM_NEWRULE, netlink.Create|netlink.Excl,
+		fibRuleHdr(fam, 0), attrs)
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return err
+	}
+
+	return nil
+}
+
+func (r *rtnl) execute(typ uint16, flags netlink.HeaderFlags,
+	msg, attrs []byte) error {
+
+	_, err := r.conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(typ),
+			Flags: netlink.Request | netlink.Acknowledge | flags,
+		},
+		Data: append(msg, attrs...),
+	})
+
+	return err
+}
+
+// Returns struct ifinfomsg
+func ifInfoMsg(index int, flags uint32) []byte {
+	msg := make([]byte, unix.SizeofIfInfomsg)
+	msg[0] = unix.AF_UNSPEC
+	binary.NativeEndian.PutUint32(msg[4:], uint32(index))
+	binary.NativeEndian.PutUint32(msg[8:], flags)
+	binary.NativeEndian.PutUint32(msg[12:], flags)
+	return msg
+}
+
+// Returns struct fib_rule_hdr routing to the table given by attribute
+func fibRuleHdr(fam byte, flags uint32) []byte {
+	msg := make([]byte, 12)
+	msg[0] = fam
+	msg[7] = unix.FR_ACT_TO_TBL
+	binary.NativeEndian.PutUint32(msg[8:], flags)
+	return msg
+}
+
+func family(prefix netip.Prefix) byte {
+	if prefix.Addr().Is4() {
+		return unix.AF_INET
+	}
+
+	return unix.AF_INET6
+}
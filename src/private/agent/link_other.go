@@ -0,0 +1,44 @@
+//go:build !linux
+
+package agent
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+var errUnsupported = fmt.Errorf("wireguard interface is managed on linux only")
+
+type rtnl struct{}
+
+func dialRtnl() (*rtnl, error) {
+	return nil, errUnsupported
+}
+
+func (r *rtnl) Close() error {
+	return nil
+}
+
+func (r *rtnl) createLink(name string) (int, error) {
+	return 0, errUnsupported
+}
+
+func (r *rtnl) deleteLink(name string) error {
+	return errUnsupported
+}
+
+func (r *rtnl) setUp(index, mtu int) error {
+	return errUnsupported
+}
+
+func (r *rtnl) addAddress(index int, prefix netip.Prefix) error {
+	return errUnsupported
+}
+
+func (r *rtnl) addRoute(index int, prefix netip.Prefix, table int) error {
+	return errUnsupported
+}
+
+func (r *rtnl) addDefaultRules(ipv4 bool, mark, table int) error {
+	return errUnsupported
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "wireguard_agent"
+
+var (
+	receiveBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "peer", "receive_bytes_total"),
+		"Bytes received from the peer since the interface is up",
+		[]string{"public_key"}, nil,
+	)
+	transmitBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "peer", "transmit_bytes_total"),
+		"Bytes sent to the peer since the interface is up",
+		[]string{"public_key"}, nil,
+	)
+	latestHandshakeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "peer", "latest_handshake_seconds"),
+		"Unix time of the latest handshake with the peer, zero when none",
+		[]string{"public_key"}, nil,
+	)
+	upDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "up"),
+		"Whether the wireguard interface is readable",
+		nil, nil,
+	)
+)
+
+type metrics struct {
+	reloads *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "config_reloads_total",
+			Help:      "Number of config reloads, by result",
+		}, []string{"result"}),
+	}
+}
+
+func (m *metrics) observeReload(err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.reloads.WithLabelValues(result).Inc()
+}
+
+// Returns handler serving /healthz and /metrics. Counters of the peers are
+// read from the interface on scrape
+func (a *Agent) Handler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(a.metrics.reloads, collector{a})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := a.Healthy(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+type collector struct {
+	agent *Agent
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- receiveBytesDesc
+	ch <- transmitBytesDesc
+	ch <- latestHandshakeDesc
+	ch <- upDesc
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	dev, err := c.agent.device()
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1)
+
+	for _, peer := range dev.Peers {
+		key := peer.PublicKey.String()
+		ch <- prometheus.MustNewConstMetric(receiveBytesDesc,
+			prometheus.CounterValue, float64(peer.ReceiveBytes), key)
+		ch <- prometheus.MustNewConstMetric(transmitBytesDesc,
+			prometheus.CounterValue, float64(peer.TransmitBytes), key)
+
+		handshake := 0.0
+		if !peer.LastHandshakeTime.IsZero() {
+			handshake = float64(peer.LastHandshakeTime.Unix())
+		}
+		ch <- prometheus.MustNewConstMetric(latestHandshakeDesc,
+			prometheus.GaugeValue, handshake, key)
+	}
+}
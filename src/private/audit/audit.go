@@ -0,0 +1,175 @@
+// Package audit turns periodic snapshots of the wireguard interface into
+// connection events of the peers
+package audit
+
+import (
+	"bufio"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Kinds of the audit events
+const (
+	Connected    = "connected"
+	Disconnected = "disconnected"
+	// Peer is connected, but remote endpoint is changed
+	Roamed = "roamed"
+)
+
+// Session is considered finished once handshake is older than this. Active
+// sessions renew handshake every 2 minutes and keys are rejected after 3
+const DefaultTimeout = 3 * time.Minute
+
+// Connection event of the peer
+type Event struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	Peer      string    `json:"peer,omitempty"`
+	PublicKey string    `json:"publicKey"`
+	Endpoint  string    `json:"endpoint,omitempty"`
+	// Bytes received from the peer during the session so far
+	ReceivedBytes int64 `json:"receivedBytes"`
+	// Bytes sent to the peer during the session so far
+	SentBytes int64 `json:"sentBytes"`
+}
+
+type session struct {
+	endpoint string
+	// counters at the start of the session
+	received int64
+	sent     int64
+	// last observed counters
+	lastReceived int64
+	lastSent     int64
+}
+
+// Auditor tracks sessions of the peers across snapshots of the interface.
+// It's not safe for concurrent use
+type Auditor struct {
+	// Names of the peers by public key
+	Names map[string]string
+	// Session is finished once handshake is older than timeout
+	Timeout  time.Duration
+	sessions map[string]*session
+}
+
+func NewAuditor() *Auditor {
+	return &Auditor{
+		Names:    map[string]string{},
+		Timeout:  DefaultTimeout,
+		sessions: map[string]*session{},
+	}
+}
+
+// Returns events happened since previous snapshot. Sessions already active
+// on the first snapshot are reported as connected
+func (a *Auditor) Observe(now time.Time, peers []wgtypes.Peer) []Event {
+	events := []Event{}
+	seen := map[string]bool{}
+	for _, peer := range peers {
+		key := peer.PublicKey.String()
+		seen[key] = true
+
+		endpoint := ""
+		if peer.Endpoint != nil {
+			endpoint = peer.Endpoint.String()
+		}
+
+		active := !peer.LastHandshakeTime.IsZero() &&
+			now.Sub(peer.LastHandshakeTime) < a.Timeout
+		s, ok := a.sessions[key]
+		if ok {
+			a.update(s, peer)
+		}
+
+		switch {
+		case active && !ok:
+			a.sessions[key] = &session{
+				endpoint:     endpoint,
+				received:     peer.ReceiveBytes,
+				sent:         peer.TransmitBytes,
+				lastReceived: peer.ReceiveBytes,
+				lastSent:     peer.TransmitBytes,
+			}
+			events = append(events, a.event(now, Connected, key, a.sessions[key]))
+		case active && endpoint != s.endpoint:
+			s.endpoint = endpoint
+			events = append(events, a.event(now, Roamed, key, s))
+		case !active && ok:
+			events = append(events, a.event(now, Disconnected, key, s))
+			delete(a.sessions, key)
+		}
+	}
+
+	// peers removed from the interface can't be connected anymore
+	keys := []string{}
+	for key := range a.sessions {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		events = append(events, a.event(now, Disconnected, key, a.sessions[key]))
+		delete(a.sessions, key)
+	}
+
+	return events
+}
+
+func (a *Auditor) update(s *session, peer wgtypes.Peer) {
+	// counters are reset when peer is re-added to the interface
+	if peer.ReceiveBytes < s.lastReceived || peer.TransmitBytes < s.lastSent {
+		s.received, s.sent = 0, 0
+	}
+
+	s.lastReceived = peer.ReceiveBytes
+	s.lastSent = peer.TransmitBytes
+}
+
+func (a *Auditor) event(now time.Time, kind, key string, s *session) Event {
+	return Event{
+		Time:          now.UTC(),
+		Event:         kind,
+		Peer:          a.Names[key],
+		PublicKey:     key,
+		Endpoint:      s.endpoint,
+		ReceivedBytes: s.lastReceived - s.received,
+		SentBytes:     s.lastSent - s.sent,
+	}
+}
+
+// Returns names of the peers by public key, taken from friendly_name
+// comments of the wireguard config
+func ParseNames(config io.Reader) (map[string]string, error) {
+	names := map[string]string{}
+	name := ""
+	scanner := bufio.NewScanner(config)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "[Peer]" {
+			name = ""
+			continue
+		}
+
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "#"), "=")
+		if !ok {
+			continue
+		}
+
+		switch strings.TrimSpace(key) {
+		case "friendly_name":
+			name = strings.TrimSpace(value)
+		case "PublicKey":
+			if name != "" {
+				names[strings.TrimSpace(value)] = name
+			}
+		}
+	}
+
+	return names, scanner.Err()
+}
@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestAuditor(t *testing.T) {
+	t.Parallel()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	assert.Nil(t, err)
+	pubKey := key.PublicKey()
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	home := &net.UDPAddr{IP: net.ParseIP("203.0.113.10"), Port: 51820}
+	office := &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 40000}
+	peer := func(handshake time.Time, endpoint *net.UDPAddr,
+		received, sent int64) []wgtypes.Peer {
+
+		return []wgtypes.Peer{{
+			PublicKey:         pubKey,
+			Endpoint:          endpoint,
+			LastHandshakeTime: handshake,
+			ReceiveBytes:      received,
+			TransmitBytes:     sent,
+		}}
+	}
+
+	type snapshot struct {
+		now   time.Time
+		peers []wgtypes.Peer
+		want  []Event
+	}
+
+	type testCase struct {
+		description string
+		snapshots   []snapshot
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		auditor := NewAuditor()
+		auditor.Names = map[string]string{pubKey.String(): "laptop"}
+		for i, s := range tc.snapshots {
+			got := auditor.Observe(s.now, s.peers)
+			assert.Equal(t, s.want, got, "snapshot %d", i)
+		}
+	})
+
+	event := func(now time.Time, kind string, endpoint *net.UDPAddr,
+		received, sent int64) Event {
+
+		return Event{
+			Time:          now,
+			Event:         kind,
+			Peer:          "laptop",
+			PublicKey:     pubKey.String(),
+			Endpoint:      endpoint.String(),
+			ReceivedBytes: received,
+			SentBytes:     sent,
+		}
+	}
+
+	later := start.Add(time.Minute)
+	expired := start.Add(DefaultTimeout + time.Minute)
+	spec.Entry("no events without handshake", testCase{
+		snapshots: []snapshot{{
+			now:   start,
+			peers: peer(time.Time{}, nil, 0, 0),
+			want:  []Event{},
+		}},
+	})
+	spec.Entry("session starts and finishes", testCase{
+		snapshots: []snapshot{{
+			now:   start,
+			peers: peer(start, home, 100, 200),
+			want:  []Event{event(start, Connected, home, 0, 0)},
+		}, {
+			now:   later,
+			peers: peer(start, home, 500, 1000),
+			want:  []Event{},
+		}, {
+			now:   expired,
+			peers: peer(start, home, 600, 1200),
+			want:  []Event{event(expired, Disconnected, home, 500, 1000)},
+		}},
+	})
+	spec.Entry("endpoint change is reported", testCase{
+		snapshots: []snapshot{{
+			now:   start,
+			peers: peer(start, home, 0, 0),
+			want:  []Event{event(start, Connected, home, 0, 0)},
+		}, {
+			now:   later,
+			peers: peer(later, office, 10, 20),
+			want:  []Event{event(later, Roamed, office, 10, 20)},
+		}},
+	})
+	spec.Entry("removed peer is disconnected", testCase{
+		snapshots: []snapshot{{
+			now:   start,
+			peers: peer(start, home, 0, 0),
+			want:  []Event{event(start, Connected, home, 0, 0)},
+		}, {
+			now:   later,
+			peers: nil,
+			want:  []Event{event(later, Disconnected, home, 0, 0)},
+		}},
+	})
+}
+
+func TestParseNames(t *testing.T) {
+	t.Parallel()
+
+	config := `[Interface]
+Address = 192.168.254.1/24
+ListenPort = 51820
+
+[Peer]
+# friendly_name = laptop
+PublicKey = bGFwdG9wbGFwdG9wbGFwdG9wbGFwdG9wbGFwdG9wbGE=
+AllowedIPs = 192.168.254.2/32
+
+[Peer]
+PublicKey = dW5uYW1lZHVubmFtZWR1bm5hbWVkdW5uYW1lZHVubmE=
+AllowedIPs = 192.168.254.3/32
+`
+	names, err := ParseNames(strings.NewReader(config))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"bGFwdG9wbGFwdG9wbGFwdG9wbGFwdG9wbGFwdG9wbGE=": "laptop",
+	}, names)
+}
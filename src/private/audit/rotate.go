@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"os"
+)
+
+// RotatingFile appends to the file and moves it aside once it would grow
+// over the limit, so at most two files of the limit are kept on disk.
+// Previous file gets .1 suffix, replacing the one rotated before
+type RotatingFile struct {
+	Path string
+	// Size in bytes the file is rotated at, never rotated when zero
+	MaxSize int64
+
+	file *os.File
+	size int64
+}
+
+func OpenRotating(path string, maxSize int64) (*RotatingFile, error) {
+	r := &RotatingFile{Path: path, MaxSize: maxSize}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	if r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	return r.file.Close()
+}
+
+// Opens the file, which might be left by the previous run of the container
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+// Moves the file aside and starts the new one. File is reopened even when
+// it can't be moved, so writes are not lost for good
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	renameErr := os.Rename(r.Path, r.Path+".1")
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	return renameErr
+}
@@ -0,0 +1,34 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	assert.Nil(t, os.WriteFile(path, []byte("old\n"), 0644))
+
+	f, err := OpenRotating(path, 10)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		_, err := f.Write([]byte(line))
+		assert.Nil(t, err)
+	}
+
+	current, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "four\n", string(current))
+
+	previous, err := os.ReadFile(path + ".1")
+	assert.Nil(t, err)
+	assert.Equal(t, "two\nthree\n", string(previous),
+		"should replace file rotated before")
+}
@@ -0,0 +1,94 @@
+package factory
+
+import (
+	"path"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+const (
+	auditInterval = 10 * time.Second
+	auditVolume   = "audit"
+	auditDir      = "/var/log/wireguard"
+	// Log is rotated at this size keeping one previous file, volume has
+	// room for both, as pod is evicted once volume exceeds its limit
+	auditMaxSize   = 10 << 20
+	auditSizeLimit = 4 * auditMaxSize
+)
+
+func auditEnabled(spec v1alpha1.WireguardSpec) bool {
+	return spec.Audit != nil && spec.Audit.Enabled
+}
+
+// Returns container writing audit events of the peer connections. It
+// shares network namespace with wireguard container, so it can poll the
+// interface. Default image is used unless spec sets one
+func auditContainer(spec v1alpha1.WireguardSpec, defaultImage string) corev1.Container {
+	cfg := spec.Audit
+	image := cfg.Image
+	if image == "" {
+		image = defaultImage
+	}
+
+	interval := auditInterval
+	if cfg.Interval != nil {
+		interval = cfg.Interval.Duration
+	}
+
+	args := []string{
+		"--device", "wg0",
+		"--config", "/etc/wireguard/wg0.conf",
+		"--interval", interval.String(),
+	}
+	mounts := []corev1.VolumeMount{{
+		Name:      "config",
+		MountPath: "/etc/wireguard",
+		ReadOnly:  true,
+	}}
+	if cfg.Sink == v1alpha1.AuditFile {
+		args = append(args,
+			"--output", path.Join(auditDir, "audit.log"),
+			"--max-size", strconv.Itoa(auditMaxSize),
+		)
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      auditVolume,
+			MountPath: auditDir,
+		})
+	}
+
+	return corev1.Container{
+		Name:            "audit",
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/wg-audit"},
+		Args:            args,
+		VolumeMounts:    mounts,
+		SecurityContext: &corev1.SecurityContext{
+			// querying wireguard over netlink requires effective
+			// capability, which non-root users don't get
+			RunAsUser:    toPtr[int64](0),
+			RunAsNonRoot: toPtr(false),
+			Capabilities: &corev1.Capabilities{
+				Add:  []corev1.Capability{"NET_ADMIN"},
+				Drop: []corev1.Capability{"ALL"},
+			},
+		},
+	}
+}
+
+// Returns volume holding audit log of the file sink
+func auditLogVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: auditVolume,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{
+				SizeLimit: resource.NewQuantity(auditSizeLimit, resource.BinarySI),
+			},
+		},
+	}
+}
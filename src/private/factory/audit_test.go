@@ -0,0 +1,102 @@
+package factory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
+)
+
+func TestWireguardAudit(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		description string
+		audit       *v1alpha1.Audit
+		args        []string
+		mounts      int
+		volume      bool
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		wg := dsl.GenerateWireguard(v1alpha1.WireguardSpec{
+			Audit: tc.audit,
+		}, v1alpha1.WireguardStatus{})
+		fact := Wireguard{
+			Scheme:        scheme,
+			Wireguard:     wg,
+			OperatorImage: "registry.local/wireguard-operator:v1.2.3",
+		}
+
+		deploy, err := fact.Deployment("")
+		assert.Nil(t, err)
+
+		pod := deploy.Spec.Template.Spec
+		hasVolume := false
+		for _, v := range pod.Volumes {
+			if v.Name != auditVolume {
+				continue
+			}
+
+			hasVolume = true
+			assert.Equal(t, "40Mi", v.EmptyDir.SizeLimit.String(),
+				"should not let audit log fill the node")
+		}
+		assert.Equal(t, tc.volume, hasVolume)
+
+		if tc.args == nil {
+			assert.Len(t, pod.Containers, 1)
+			return
+		}
+
+		assert.Len(t, pod.Containers, 2)
+		container := pod.Containers[1]
+		assert.Equal(t, "audit", container.Name)
+		assert.Equal(t, "registry.local/wireguard-operator:v1.2.3", container.Image,
+			"should default to the image of the operator")
+		assert.Equal(t, []string{"/wg-audit"}, container.Command)
+		assert.Equal(t, tc.args, container.Args)
+		assert.Len(t, container.VolumeMounts, tc.mounts)
+		assert.Equal(t, []corev1.Capability{"NET_ADMIN"},
+			container.SecurityContext.Capabilities.Add)
+	})
+
+	spec.Entry("disabled by default", testCase{})
+	spec.Entry("disabled explicitly", testCase{
+		audit: &v1alpha1.Audit{Enabled: false},
+	})
+	spec.Entry("writes to stdout", testCase{
+		audit: &v1alpha1.Audit{Enabled: true, Sink: v1alpha1.AuditStdout},
+		args: []string{
+			"--device", "wg0",
+			"--config", "/etc/wireguard/wg0.conf",
+			"--interval", "10s",
+		},
+		mounts: 1,
+	})
+	spec.Entry("writes to file on shared volume", testCase{
+		audit: &v1alpha1.Audit{
+			Enabled:  true,
+			Sink:     v1alpha1.AuditFile,
+			Interval: &metav1.Duration{Duration: time.Minute},
+		},
+		args: []string{
+			"--device", "wg0",
+			"--config", "/etc/wireguard/wg0.conf",
+			"--interval", "1m0s",
+			"--output", "/var/log/wireguard/audit.log",
+			"--max-size", "10485760",
+		},
+		mounts: 2,
+		volume: true,
+	})
+}
@@ -0,0 +1,92 @@
+package factory
+
+import (
+	"fmt"
+	"net/netip"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+var ErrInvalidBandwidth = fmt.Errorf("invalid bandwidth limit")
+
+// Bandwidth limits of the peer, rendered as tc rules on the wireguard
+// interface. Rates are in bits per second, zero means unlimited
+type peerLimit struct {
+	// minor of the htb class, hexadecimal
+	ClassID string
+	Address string
+	// traffic to the peer, shaped by htb class
+	Egress int64
+	// traffic from the peer, policed on ingress
+	Ingress int64
+	// burst of the ingress policer in bytes
+	Burst int64
+}
+
+// Returns bandwidth limits of the peers. Peer limits take precedence over
+// defaults of the wireguard, per direction
+func (fact Wireguard) peerLimits(peers []v1alpha1.WireguardPeer) ([]peerLimit, error) {
+	defaults := fact.Wireguard.Spec.PeerBandwidth
+	if defaults == nil {
+		defaults = &v1alpha1.Bandwidth{}
+	}
+
+	limits := []peerLimit{}
+	for _, peer := range peers {
+		bandwidth := peer.Spec.Bandwidth
+		if bandwidth == nil {
+			bandwidth = &v1alpha1.Bandwidth{}
+		}
+
+		egress, err := bitsPerSecond(bandwidth.Egress, defaults.Egress)
+		if err != nil {
+			return nil, fmt.Errorf("peer %s: %w", peer.GetName(), err)
+		}
+
+		ingress, err := bitsPerSecond(bandwidth.Ingress, defaults.Ingress)
+		if err != nil {
+			return nil, fmt.Errorf("peer %s: %w", peer.GetName(), err)
+		}
+
+		if egress == 0 && ingress == 0 {
+			continue
+		}
+
+		// address might be set with the mask of the tunnel subnet
+		prefix, err := netip.ParsePrefix(string(peer.Spec.Address))
+		if err != nil {
+			return nil, err
+		}
+
+		limits = append(limits, peerLimit{
+			ClassID: fmt.Sprintf("%x", len(limits)+1),
+			Address: prefix.Addr().String(),
+			Egress:  egress,
+			Ingress: ingress,
+			// 100ms of traffic, but at least a few full-sized packets
+			Burst: max(ingress/8/10, 15000),
+		})
+	}
+
+	return limits, nil
+}
+
+// Returns the first set rate in bits per second, zero when none is set
+func bitsPerSecond(rates ...*resource.Quantity) (int64, error) {
+	for _, rate := range rates {
+		if rate == nil {
+			continue
+		}
+
+		value := rate.Value()
+		if value <= 0 {
+			return 0, fmt.Errorf("%w: %s", ErrInvalidBandwidth, rate.String())
+		}
+
+		return value, nil
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,134 @@
+package factory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
+)
+
+func TestWireguardBandwidth(t *testing.T) {
+	t.Parallel()
+
+	quantity := func(s string) *resource.Quantity {
+		q := resource.MustParse(s)
+		return &q
+	}
+
+	makePeer := func(name, address string, bw *v1alpha1.Bandwidth) v1alpha1.WireguardPeer {
+		return dsl.NamedPeer(name, v1alpha1.WireguardPeerSpec{
+			Address:   v1alpha1.Address(address),
+			Bandwidth: bw,
+		})
+	}
+
+	type testCase struct {
+		description string
+		defaults    *v1alpha1.Bandwidth
+		peers       []v1alpha1.WireguardPeer
+		want        []string
+		notWant     []string
+		err         error
+	}
+
+	const (
+		reset = "printf '%s\\n' " +
+			"'qdisc replace dev %i root handle 1: htb' 'qdisc del dev %i root' " +
+			"'qdisc replace dev %i handle ffff: ingress' 'qdisc del dev %i ingress' "
+		root    = "'qdisc add dev %i root handle 1: htb' "
+		ingress = "'qdisc add dev %i handle ffff: ingress' "
+	)
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		wg := dsl.GenerateWireguard(v1alpha1.WireguardSpec{
+			PeerBandwidth: tc.defaults,
+		}, v1alpha1.WireguardStatus{})
+		fact := Wireguard{
+			Scheme:    scheme,
+			Wireguard: wg,
+			Peers:     v1alpha1.WireguardPeerList{Items: tc.peers},
+		}
+
+		secret, err := fact.Secret("public", "private")
+		assert.ErrorIs(t, err, tc.err)
+		if tc.err != nil {
+			return
+		}
+
+		assert.NotContains(t, string(secret.Data["config"]), "tc ",
+			"should keep limits out of the config, which rolls the pods")
+
+		rules := string(secret.Data["rules"])
+		assert.True(t, strings.HasPrefix(rules, reset),
+			"should reset limits applied before")
+		for _, line := range tc.want {
+			assert.Contains(t, rules, line)
+		}
+		for _, line := range tc.notWant {
+			assert.NotContains(t, rules, line)
+		}
+	})
+
+	spec.Entry("no qdiscs without limits", testCase{
+		peers:   []v1alpha1.WireguardPeer{makePeer("laptop", "192.168.254.2/32", nil)},
+		notWant: []string{"qdisc add"},
+	})
+	spec.Entry("shapes and polices traffic of the peer", testCase{
+		peers: []v1alpha1.WireguardPeer{
+			makePeer("laptop", "192.168.254.2/24", &v1alpha1.Bandwidth{
+				Ingress: quantity("10M"),
+				Egress:  quantity("50M"),
+			}),
+		},
+		want: []string{
+			root + ingress +
+				"'class add dev %i parent 1: classid 1:1 htb rate 50000000bit' " +
+				"'filter add dev %i parent 1: protocol ip prio 1 u32 match ip dst 192.168.254.2/32 flowid 1:1' " +
+				"'filter add dev %i parent ffff: protocol ip prio 1 u32 match ip src 192.168.254.2/32 police rate 10000000bit burst 125000 drop' " +
+				"| tc -batch -\n",
+		},
+	})
+	spec.Entry("peer limits override defaults per direction", testCase{
+		defaults: &v1alpha1.Bandwidth{
+			Ingress: quantity("1M"),
+			Egress:  quantity("2M"),
+		},
+		peers: []v1alpha1.WireguardPeer{
+			makePeer("laptop", "192.168.254.2/32", nil),
+			makePeer("phone", "192.168.254.3/32", &v1alpha1.Bandwidth{
+				Egress: quantity("20M"),
+			}),
+		},
+		want: []string{
+			"classid 1:1 htb rate 2000000bit",
+			"match ip src 192.168.254.2/32 police rate 1000000bit burst 15000 drop",
+			"classid 1:2 htb rate 20000000bit",
+			"match ip src 192.168.254.3/32 police rate 1000000bit burst 15000 drop",
+		},
+	})
+	spec.Entry("skips egress class when only ingress is limited", testCase{
+		peers: []v1alpha1.WireguardPeer{
+			makePeer("laptop", "192.168.254.2/32", &v1alpha1.Bandwidth{
+				Ingress: quantity("10M"),
+			}),
+		},
+		want:    []string{root + ingress},
+		notWant: []string{"class add"},
+	})
+	spec.Entry("errors on zero rate", testCase{
+		peers: []v1alpha1.WireguardPeer{
+			makePeer("laptop", "192.168.254.2/32", &v1alpha1.Bandwidth{
+				Egress: quantity("0"),
+			}),
+		},
+		err: ErrInvalidBandwidth,
+	})
+}
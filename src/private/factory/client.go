@@ -0,0 +1,284 @@
+package factory
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net"
+	"strings"
+	"text/template"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+// Label selecting pods of the client. Pods of the client don't have
+// managed-by label, otherwise those would be selected by wireguard services
+const ClientLabel = "vpn.ahova.com/client"
+
+var ErrInvalidTarget = fmt.Errorf("invalid target of the client port")
+
+type WireguardClient struct {
+	*runtime.Scheme
+	v1alpha1.WireguardClient
+	// Value of .spec.server.presharedKeyRef, if set
+	PresharedKey string
+	// Image of the operator the agent is installed from
+	OperatorImage string
+}
+
+// Returns labels for the resources of the client
+func (fact WireguardClient) Labels() map[string]string {
+	labels := map[string]string{
+		"app.kubernetes.io/managed-by": "wireguard-operator",
+	}
+
+	maps.Copy(labels, fact.podLabels())
+	return labels
+}
+
+// Returns labels of the client pods
+func (fact WireguardClient) podLabels() map[string]string {
+	labels := map[string]string{}
+	maps.Copy(labels, fact.WireguardClient.Spec.Labels)
+	maps.Copy(labels, fact.selectorLabels())
+	return labels
+}
+
+func (fact WireguardClient) selectorLabels() map[string]string {
+	return map[string]string{
+		ClientLabel: fact.WireguardClient.GetName(),
+	}
+}
+
+// Returns desired secret with configuration of the client
+func (fact WireguardClient) Secret(pubKey, privKey string) (*corev1.Secret, error) {
+	tmpl, err := template.New("client").Parse(clientConfigTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := fact.WireguardClient.Spec
+	ports := []clientPort{}
+	for _, port := range spec.Ports {
+		if _, _, err := net.SplitHostPort(port.Target); err != nil {
+			return nil, fmt.Errorf("%w %s: %w", ErrInvalidTarget, port.Name, err)
+		}
+
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+
+		ports = append(ports, clientPort{
+			Protocol: strings.ToLower(string(protocol)),
+			Port:     port.Port,
+			Target:   port.Target,
+		})
+	}
+
+	cfg := clientConfig{
+		Address:             spec.Address,
+		PrivateKey:          privKey,
+		Ports:               ports,
+		PublicKey:           spec.Server.PublicKey,
+		PresharedKey:        fact.PresharedKey,
+		Endpoint:            spec.Server.Endpoint,
+		AllowedIPs:          strings.Join(spec.Server.AllowedIPs, ", "),
+		PersistentKeepalive: spec.Server.PersistentKeepalive,
+		Egress:              fact.EgressEnabled(),
+	}
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, cfg); err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: fact.objectMeta(),
+		Type:       corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"config":      buf.Bytes(),
+			"public-key":  []byte(pubKey),
+			"private-key": []byte(privKey),
+		},
+	}
+
+	result, err := fact.decorate(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*corev1.Secret), nil
+}
+
+// Returns desired deployment of the client. There is always a single
+// replica, as the server accepts only one endpoint per public key
+func (fact WireguardClient) Deployment(configHash string) (*appsv1.Deployment, error) {
+	wgClient := fact.WireguardClient
+	readiness := &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"wg", "show", "wg0", "peers"},
+			},
+		},
+		FailureThreshold:    2,
+		SuccessThreshold:    1,
+		InitialDelaySeconds: 5,
+		TimeoutSeconds:      5,
+		PeriodSeconds:       10,
+	}
+	container := wireguardContainer(readiness)
+	for _, port := range wgClient.Spec.Ports {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			ContainerPort: port.Port,
+			Name:          port.Name,
+			Protocol:      port.Protocol,
+		})
+	}
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: fact.objectMeta(),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: toPtr[int32](1),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: fact.selectorLabels(),
+			},
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RecreateDeploymentStrategyType,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: fact.podLabels(),
+					Annotations: map[string]string{
+						configHashAnnotation: configHash,
+					},
+				},
+				Spec: corev1.PodSpec{
+					Affinity: wgClient.Spec.Affinity,
+					InitContainers: []corev1.Container{
+						agentInitContainer(imageOrDefault(fact.OperatorImage)),
+					},
+					Containers:      []corev1.Container{container},
+					SecurityContext: wireguardPodSecurityContext(),
+					Volumes:         wireguardVolumes(wgClient.GetName()),
+				},
+			},
+		},
+	}
+
+	result, err := fact.decorate(deploy)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*appsv1.Deployment), nil
+}
+
+// Returns true when service exposing remote hosts should exist
+func (fact WireguardClient) ServiceEnabled() bool {
+	return len(fact.WireguardClient.Spec.Ports) > 0
+}
+
+// Returns service exposing remote hosts to the cluster. Traffic to the
+// ports is forwarded by the client pod to the targets
+func (fact WireguardClient) Service() (*corev1.Service, error) {
+	var ports []corev1.ServicePort
+	for _, port := range fact.WireguardClient.Spec.Ports {
+		ports = append(ports, corev1.ServicePort{
+			Name:       port.Name,
+			Protocol:   port.Protocol,
+			Port:       port.Port,
+			TargetPort: intstr.FromInt32(port.Port),
+		})
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: fact.objectMeta(),
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeClusterIP,
+			Selector: fact.selectorLabels(),
+			Ports:    ports,
+		},
+	}
+
+	result, err := fact.decorate(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*corev1.Service), nil
+}
+
+func (fact WireguardClient) objectMeta() metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      fact.WireguardClient.GetName(),
+		Namespace: fact.WireguardClient.GetNamespace(),
+		Labels:    fact.Labels(),
+	}
+}
+
+func (fact WireguardClient) decorate(obj client.Object) (client.Object, error) {
+	wgClient := &fact.WireguardClient
+	if err := ctrl.SetControllerReference(wgClient, obj, fact.Scheme); err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+type clientPort struct {
+	Protocol string
+	Port     int32
+	Target   string
+}
+
+type clientConfig struct {
+	Address             v1alpha1.Address
+	PrivateKey          string
+	Ports               []clientPort
+	PublicKey           string
+	PresharedKey        string
+	Endpoint            string
+	AllowedIPs          string
+	PersistentKeepalive int32
+	Egress              bool
+}
+
+// Traffic from the cluster is masqueraded, so the server sees it as coming
+// from the client address. Ports are forwarded to the remote targets.
+// Egress traffic arrives from the nodes encapsulated, fallback IPIP device
+// accepts it from any node
+const clientConfigTemplate = `[Interface]
+Address = {{ .Address }}
+PrivateKey = {{ .PrivateKey }}
+PostUp = iptables --append FORWARD --out-interface %i --jump ACCEPT
+PostUp = iptables -t nat -A POSTROUTING -o %i -j MASQUERADE
+{{- range .Ports }}
+PostUp = iptables -t nat -A PREROUTING -i eth0 -p {{ .Protocol }} --dport {{ .Port }} -j DNAT --to-destination {{ .Target }}
+{{- end }}
+{{- if .Egress }}
+PostUp = modprobe ipip
+PostUp = sysctl -w net.ipv4.conf.tunl0.rp_filter=0
+PostUp = ip link set tunl0 up
+PostUp = iptables --append FORWARD --in-interface tunl0 --out-interface %i --jump ACCEPT
+{{- end }}
+SaveConfig = false
+
+[Peer]
+PublicKey = {{ .PublicKey }}
+{{- if .PresharedKey }}
+PresharedKey = {{ .PresharedKey }}
+{{- end }}
+Endpoint = {{ .Endpoint }}
+AllowedIPs = {{ .AllowedIPs }}
+{{- if .PersistentKeepalive }}
+PersistentKeepalive = {{ .PersistentKeepalive }}
+{{- end }}
+`
@@ -0,0 +1,127 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
+)
+
+const serverPublicKey = "WsFemZZdyC+ajbvOtKA7dltaNCaPOusKmkJffjMOMmg="
+
+func TestWireguardClient(t *testing.T) {
+	t.Parallel()
+
+	makeFact := func(ports ...v1alpha1.ClientPort) WireguardClient {
+		wgClient := dsl.GenerateClient(v1alpha1.WireguardClientSpec{
+			Address: "10.8.0.2/32",
+			Server: v1alpha1.WireguardServer{
+				PublicKey:           serverPublicKey,
+				Endpoint:            "vpn.example.com:51820",
+				AllowedIPs:          []string{"10.0.0.0/8", "172.16.0.0/12"},
+				PersistentKeepalive: 25,
+			},
+			Ports:  ports,
+			Labels: map[string]string{"team": "infra"},
+		}, v1alpha1.WireguardClientStatus{})
+
+		return WireguardClient{Scheme: scheme, WireguardClient: wgClient}
+	}
+
+	postgres := v1alpha1.ClientPort{
+		Name:     "postgres",
+		Port:     5432,
+		Protocol: corev1.ProtocolTCP,
+		Target:   "10.0.0.5:5432",
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	o.Spec("renders config for the server", func(t *testing.T) {
+		fact := makeFact(postgres)
+		fact.PresharedKey = "preshared"
+
+		secret, err := fact.Secret("public", "private")
+		assert.Nil(t, err)
+
+		want := `[Interface]
+Address = 10.8.0.2/32
+PrivateKey = private
+PostUp = iptables --append FORWARD --out-interface %i --jump ACCEPT
+PostUp = iptables -t nat -A POSTROUTING -o %i -j MASQUERADE
+PostUp = iptables -t nat -A PREROUTING -i eth0 -p tcp --dport 5432 -j DNAT --to-destination 10.0.0.5:5432
+SaveConfig = false
+
+[Peer]
+PublicKey = ` + serverPublicKey + `
+PresharedKey = preshared
+Endpoint = vpn.example.com:51820
+AllowedIPs = 10.0.0.0/8, 172.16.0.0/12
+PersistentKeepalive = 25
+`
+		assert.Equal(t, want, string(secret.Data["config"]))
+		assert.Equal(t, "public", string(secret.Data["public-key"]))
+		shouldHaveProperDecorations(t, secret)
+	})
+
+	o.Spec("rejects invalid target", func(t *testing.T) {
+		port := postgres
+		port.Target = "10.0.0.5"
+		_, err := makeFact(port).Secret("public", "private")
+		assert.ErrorIs(t, err, ErrInvalidTarget)
+	})
+
+	o.Spec("pods are not selected by wireguards", func(t *testing.T) {
+		fact := makeFact()
+		deploy, err := fact.Deployment("hash")
+		assert.Nil(t, err)
+
+		labels := deploy.Spec.Template.GetLabels()
+		assert.NotContains(t, labels, "app.kubernetes.io/managed-by")
+		assert.Equal(t, fact.GetName(), labels[ClientLabel])
+		assert.Equal(t, "infra", labels["team"])
+		assert.Equal(t,
+			&metav1.LabelSelector{MatchLabels: map[string]string{
+				ClientLabel: fact.GetName(),
+			}},
+			deploy.Spec.Selector)
+		assert.Equal(t, int32(1), *deploy.Spec.Replicas)
+		assert.Equal(t, "wireguard-operator",
+			deploy.GetLabels()["app.kubernetes.io/managed-by"])
+		shouldHaveProperDecorations(t, deploy)
+	})
+
+	o.Spec("installs agent from the operator image", func(t *testing.T) {
+		fact := makeFact()
+		fact.OperatorImage = "registry.local/wireguard-operator:v1.2.3"
+		deploy, err := fact.Deployment("hash")
+		assert.Nil(t, err)
+
+		initContainers := deploy.Spec.Template.Spec.InitContainers
+		assert.Len(t, initContainers, 1)
+		assert.Equal(t, fact.OperatorImage, initContainers[0].Image)
+	})
+
+	o.Spec("exposes ports", func(t *testing.T) {
+		fact := makeFact(postgres)
+		assert.True(t, fact.ServiceEnabled())
+		assert.False(t, makeFact().ServiceEnabled())
+
+		svc, err := fact.Service()
+		assert.Nil(t, err)
+		assert.Len(t, svc.Spec.Ports, 1)
+		assert.Equal(t, int32(5432), svc.Spec.Ports[0].Port)
+		assert.Equal(t, fact.selectorLabels(), svc.Spec.Selector)
+
+		deploy, err := fact.Deployment("hash")
+		assert.Nil(t, err)
+		container := deploy.Spec.Template.Spec.Containers[0]
+		assert.Equal(t, int32(5432), container.Ports[0].ContainerPort)
+	})
+}
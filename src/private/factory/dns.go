@@ -0,0 +1,179 @@
+package factory
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"slices"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/resolver"
+)
+
+const (
+	dnsForwarderImage = "coredns/coredns:1.12.1"
+	dnsPort           = 53
+	clusterDomain     = "cluster.local"
+)
+
+// Returns true when DNS forwarder should run inside the wireguard pod
+func dnsForwarderEnabled(spec v1alpha1.WireguardSpec) bool {
+	return spec.DNSForwarder != nil && spec.DNSForwarder.Enabled
+}
+
+// Returns DNS servers to be used by peers. With DNS forwarder enabled it
+// is the tunnel address of the wireguard, otherwise upstream servers
+func peerDNSServers(
+	res resolver.Resolver, spec v1alpha1.WireguardSpec) ([]string, error) {
+
+	if !dnsForwarderEnabled(spec) {
+		return upstreamDNSServers(res, spec)
+	}
+
+	ip, _, err := net.ParseCIDR(string(spec.Address))
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{ip.String()}, nil
+}
+
+// Returns resolved .spec.dns followed by resolved .spec.dnsServers
+func upstreamDNSServers(
+	res resolver.Resolver, spec v1alpha1.WireguardSpec) ([]string, error) {
+
+	var servers []string
+	for _, addr := range append([]string{spec.DNS}, spec.DNSServers...) {
+		if addr == "" {
+			continue
+		}
+
+		ip, err := resolveDNSServer(res, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		servers = append(servers, ip)
+	}
+
+	return servers, nil
+}
+
+// Returns IP address of the given DNS server, resolving hostname if needed
+func resolveDNSServer(res resolver.Resolver, addr string) (string, error) {
+	if ip := net.ParseIP(addr); ip != nil {
+		// string is valid ip addres, can use as DNS config
+		return ip.String(), nil
+	}
+
+	if res == nil {
+		return "", ErrResolverNotSet
+	}
+
+	// seems like a hostname, try to resolve to ip
+	addrs, err := res.Resolve(addr)
+	if err != nil {
+		return "", fmt.Errorf("%w %s: %w", ErrDNSResolution, addr, err)
+	} else if len(addrs) == 0 {
+		return "", fmt.Errorf("%w %s: no addresses found", ErrDNSResolution, addr)
+	}
+
+	// lookup output is not not determenistic, so let's sort it to
+	// avoid infinite reconcilation loop
+	return slices.Min(addrs), nil
+}
+
+// Returns configuration of the DNS forwarder
+func corefile(
+	res resolver.Resolver, spec v1alpha1.WireguardSpec) (string, error) {
+
+	tmpl, err := template.New("corefile").Parse(corefileTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	upstreams, err := upstreamDNSServers(res, spec)
+	if err != nil {
+		return "", err
+	}
+	if len(upstreams) == 0 {
+		// no upstreams configured, falling back to cluster DNS
+		upstreams = []string{"/etc/resolv.conf"}
+	}
+
+	domain := spec.DNSForwarder.ClusterDomain
+	if domain == "" {
+		domain = clusterDomain
+	}
+
+	cfg := corefileConfig{
+		ClusterDomain: domain,
+		Port:          dnsPort,
+		Upstreams:     upstreams,
+	}
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, cfg); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// Returns sidecar container running DNS forwarder
+func dnsForwarderContainer(spec v1alpha1.WireguardSpec) corev1.Container {
+	image := spec.DNSForwarder.Image
+	if image == "" {
+		image = dnsForwarderImage
+	}
+
+	return corev1.Container{
+		Name:            "dns-forwarder",
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Args:            []string{"-conf", "/etc/coredns/Corefile"},
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      "dns-forwarder",
+			MountPath: "/etc/coredns",
+			ReadOnly:  true,
+		}},
+		Ports: []corev1.ContainerPort{{
+			ContainerPort: dnsPort,
+			Name:          "dns",
+			Protocol:      corev1.ProtocolUDP,
+		}, {
+			ContainerPort: dnsPort,
+			Name:          "dns-tcp",
+			Protocol:      corev1.ProtocolTCP,
+		}},
+		SecurityContext: &corev1.SecurityContext{
+			Capabilities: &corev1.Capabilities{
+				Add: []corev1.Capability{
+					"NET_BIND_SERVICE",
+				},
+			},
+		},
+	}
+}
+
+type corefileConfig struct {
+	ClusterDomain string
+	Port          int32
+	Upstreams     []string
+}
+
+const corefileTemplate = `{{ .ClusterDomain }}:{{ .Port }} {
+    errors
+    cache 30
+    reload
+    forward . /etc/resolv.conf
+}
+.:{{ .Port }} {
+    errors
+    cache 30
+    reload
+    forward .{{ range .Upstreams }} {{ . }}{{ end }}
+}
+`
@@ -0,0 +1,241 @@
+package factory
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"slices"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Label selecting egress pods of the client. Those are separate from the
+// client pods, so services and gateway never pick them
+const EgressLabel = "vpn.ahova.com/egress"
+
+const (
+	// Range of the routing tables, which operator allocates to the egress
+	// of the clients
+	MinEgressTable = 20000
+	MaxEgressTable = 29999
+
+	// Priority of the routing rules, must be lower than priority of the
+	// main table
+	egressRulePriority = 31000
+
+	egressSh = `#!/bin/sh
+# Routes traffic of the selected pods to the client pod over IPIP tunnel.
+# Config map is mounted as directory, so it's re-applied on every update
+set -u
+
+config=/opt/egress
+device=$(cat "$config/device")
+table=$(cat "$config/table")
+priority=$(cat "$config/priority")
+
+flush () {
+	while ip rule del table "$table" 2> /dev/null; do :; done
+}
+
+apply () {
+	gateway=$(cat "$config/gateway")
+	if [ -z "$gateway" ]; then
+		# client pod is not ready, traffic is dropped instead of
+		# leaking outside of the tunnel
+		ip tunnel del "$device" 2> /dev/null
+		ip route replace blackhole default table "$table"
+	elif ip tunnel show "$device" > /dev/null 2>&1; then
+		ip tunnel change "$device" mode ipip remote "$gateway"
+	else
+		ip tunnel add "$device" mode ipip remote "$gateway"
+	fi
+
+	if [ -n "$gateway" ]; then
+		ip link set "$device" up
+		ip route replace default dev "$device" table "$table"
+	fi
+
+	flush
+	while read -r from to; do
+		ip rule add from "$from" to "$to" table "$table" priority "$priority"
+	done < "$config/rules"
+}
+
+finish () {
+	echo "$(date): Removing egress rules"
+	flush
+	ip tunnel del "$device" 2> /dev/null
+	ip route flush table "$table"
+	exit 0
+}
+
+trap finish TERM INT QUIT
+echo "$(date): Starting egress"
+applied=""
+while true; do
+	current=$(cat "$config/gateway" "$config/rules" | md5sum)
+	if [ "$current" != "$applied" ]; then
+		echo "$(date): Applying egress rules"
+		apply
+		applied="$current"
+	fi
+
+	sleep 5 &
+	wait $!
+done`
+)
+
+var ErrInvalidDestination = fmt.Errorf("invalid destination of the egress")
+
+// Returns true when traffic of the selected pods should be routed through
+// the client
+func (fact WireguardClient) EgressEnabled() bool {
+	return fact.WireguardClient.Spec.Egress != nil
+}
+
+// Returns config map with egress routing rules. Gateway is IP address of
+// the client pod, nil while pod is not ready. Sources are IP addresses of
+// the selected pods
+func (fact WireguardClient) EgressConfigMap(
+	gateway *string, sources []string) (*corev1.ConfigMap, error) {
+
+	var destinations []string
+	if egress := fact.WireguardClient.Spec.Egress; egress != nil {
+		destinations = egress.DestinationCIDRs
+	}
+
+	for _, dst := range destinations {
+		if _, _, err := net.ParseCIDR(dst); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidDestination, err)
+		}
+	}
+
+	sorted := slices.Sorted(slices.Values(sources))
+	rules := new(strings.Builder)
+	for _, src := range slices.Compact(sorted) {
+		for _, dst := range destinations {
+			fmt.Fprintf(rules, "%s %s\n", src, dst)
+		}
+	}
+
+	gw := ""
+	if gateway != nil {
+		gw = *gateway
+	}
+
+	table, device := fact.egressRouting()
+	cm := &corev1.ConfigMap{
+		ObjectMeta: fact.egressObjectMeta(),
+		Data: map[string]string{
+			"egress.sh": egressSh,
+			"device":    device,
+			"table":     fmt.Sprint(table),
+			"priority":  fmt.Sprint(egressRulePriority),
+			"gateway":   gw,
+			"rules":     rules.String(),
+		},
+	}
+
+	result, err := fact.decorate(cm)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*corev1.ConfigMap), nil
+}
+
+// Returns daemon set applying egress routing rules on every node. Pods
+// run in host network, as rules are evaluated when traffic of the
+// selected pods leaves them
+func (fact WireguardClient) EgressDaemonSet() (*appsv1.DaemonSet, error) {
+	meta := fact.egressObjectMeta()
+	selector := map[string]string{
+		EgressLabel: fact.WireguardClient.GetName(),
+	}
+
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: meta,
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: selector,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: selector,
+				},
+				Spec: corev1.PodSpec{
+					HostNetwork: true,
+					Tolerations: []corev1.Toleration{{
+						Operator: corev1.TolerationOpExists,
+					}},
+					Containers: []corev1.Container{{
+						Image:           wireguardImage,
+						Name:            "egress",
+						Command:         []string{"/opt/egress/egress.sh"},
+						ImagePullPolicy: corev1.PullIfNotPresent,
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "egress",
+							MountPath: "/opt/egress",
+						}},
+						SecurityContext: &corev1.SecurityContext{
+							Privileged: toPtr(true),
+							Capabilities: &corev1.Capabilities{
+								Add: []corev1.Capability{
+									"NET_ADMIN",
+									"SYS_MODULE",
+								},
+							},
+						},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "egress",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{
+									Name: meta.GetName(),
+								},
+								DefaultMode: toPtr[int32](0755),
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+
+	result, err := fact.decorate(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*appsv1.DaemonSet), nil
+}
+
+// Returns routing table and tunnel device of the client on the nodes.
+// Table is the one allocated in status, so clients don't interfere with
+// each other. Until it's allocated, e.g. when rendered offline, table is
+// derived from the client just as the device
+func (fact WireguardClient) egressRouting() (int32, string) {
+	h := fnv.New32a()
+	h.Write([]byte(fact.WireguardClient.GetNamespace()))
+	h.Write([]byte("/"))
+	h.Write([]byte(fact.WireguardClient.GetName()))
+	sum := h.Sum32()
+	device := fmt.Sprintf("wgeg%08x", sum)
+
+	if table := fact.WireguardClient.Status.EgressTable; table != nil {
+		return *table, device
+	}
+
+	size := uint32(MaxEgressTable - MinEgressTable + 1)
+	return MinEgressTable + int32(sum%size), device
+}
+
+func (fact WireguardClient) egressObjectMeta() metav1.ObjectMeta {
+	meta := fact.objectMeta()
+	meta.Name = fact.WireguardClient.GetName() + "-egress"
+	return meta
+}
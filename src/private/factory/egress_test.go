@@ -0,0 +1,125 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
+)
+
+func TestEgress(t *testing.T) {
+	t.Parallel()
+
+	makeFact := func(egress *v1alpha1.Egress) WireguardClient {
+		wgClient := dsl.GenerateClient(v1alpha1.WireguardClientSpec{
+			Address: "10.8.0.2/32",
+			Server: v1alpha1.WireguardServer{
+				PublicKey:  serverPublicKey,
+				Endpoint:   "vpn.example.com:51820",
+				AllowedIPs: []string{"10.20.0.0/16"},
+			},
+			Egress: egress,
+		}, v1alpha1.WireguardClientStatus{})
+
+		return WireguardClient{Scheme: scheme, WireguardClient: wgClient}
+	}
+
+	egress := &v1alpha1.Egress{
+		PodSelector: metav1.LabelSelector{
+			MatchLabels: map[string]string{"app": "billing"},
+		},
+		DestinationCIDRs: []string{"10.20.0.0/16", "10.21.0.0/16"},
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	o.Spec("is disabled by default", func(t *testing.T) {
+		fact := makeFact(nil)
+		assert.False(t, fact.EgressEnabled())
+
+		secret, err := fact.Secret("public", "private")
+		assert.Nil(t, err)
+		assert.NotContains(t, string(secret.Data["config"]), "tunl0")
+	})
+
+	o.Spec("accepts encapsulated traffic", func(t *testing.T) {
+		fact := makeFact(egress)
+		assert.True(t, fact.EgressEnabled())
+
+		secret, err := fact.Secret("public", "private")
+		assert.Nil(t, err)
+		assert.Contains(t, string(secret.Data["config"]),
+			"PostUp = iptables --append FORWARD --in-interface tunl0 --out-interface %i --jump ACCEPT")
+	})
+
+	o.Spec("renders rules for every source and destination", func(t *testing.T) {
+		fact := makeFact(egress)
+		cm, err := fact.EgressConfigMap(toPtr("10.244.0.7"),
+			[]string{"10.244.1.5", "10.244.0.3", "10.244.1.5"})
+		assert.Nil(t, err)
+
+		want := "10.244.0.3 10.20.0.0/16\n" +
+			"10.244.0.3 10.21.0.0/16\n" +
+			"10.244.1.5 10.20.0.0/16\n" +
+			"10.244.1.5 10.21.0.0/16\n"
+		assert.Equal(t, want, cm.Data["rules"])
+		assert.Equal(t, "10.244.0.7", cm.Data["gateway"])
+		assert.Equal(t, fact.GetName()+"-egress", cm.GetName())
+		assert.Len(t, cm.Data["device"], 12)
+		shouldHaveProperDecorations(t, cm)
+	})
+
+	o.Spec("renders empty gateway while client is pending", func(t *testing.T) {
+		cm, err := makeFact(egress).EgressConfigMap(nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "", cm.Data["gateway"])
+		assert.Equal(t, "", cm.Data["rules"])
+	})
+
+	o.Spec("rejects invalid destination", func(t *testing.T) {
+		invalid := *egress
+		invalid.DestinationCIDRs = []string{"10.20.0.0"}
+		_, err := makeFact(&invalid).EgressConfigMap(nil, nil)
+		assert.ErrorIs(t, err, ErrInvalidDestination)
+	})
+
+	o.Spec("routing is stable per client", func(t *testing.T) {
+		fact := makeFact(egress)
+		table, device := fact.egressRouting()
+		sameTable, sameDevice := fact.egressRouting()
+		assert.Equal(t, table, sameTable)
+		assert.Equal(t, device, sameDevice)
+		assert.GreaterOrEqual(t, table, int32(MinEgressTable))
+		assert.LessOrEqual(t, table, int32(MaxEgressTable))
+
+		_, otherDevice := makeFact(egress).egressRouting()
+		assert.NotEqual(t, device, otherDevice)
+	})
+
+	o.Spec("uses routing table allocated in status", func(t *testing.T) {
+		fact := makeFact(egress)
+		fact.WireguardClient.Status.EgressTable = toPtr[int32](20042)
+		cm, err := fact.EgressConfigMap(nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "20042", cm.Data["table"])
+	})
+
+	o.Spec("daemon set pods are not selected by the client", func(t *testing.T) {
+		fact := makeFact(egress)
+		ds, err := fact.EgressDaemonSet()
+		assert.Nil(t, err)
+
+		pod := ds.Spec.Template
+		assert.True(t, pod.Spec.HostNetwork)
+		assert.NotContains(t, pod.GetLabels(), ClientLabel)
+		assert.Equal(t, fact.GetName(), pod.GetLabels()[EgressLabel])
+		assert.Equal(t, fact.GetName()+"-egress",
+			pod.Spec.Volumes[0].ConfigMap.Name)
+		shouldHaveProperDecorations(t, ds)
+	})
+}
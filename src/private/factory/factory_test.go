@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/resolver"
 	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
 
 	corev1 "k8s.io/api/core/v1"
@@ -21,6 +22,11 @@ var (
 	defaultWgFact   Wireguard
 	defaultPeerFact Peer
 
+	fakeResolver = resolver.Fake{
+		"one.one.one.one":  {"1.1.1.1", "1.0.0.1"},
+		"internal.dns.svc": {"10.96.0.10"},
+	}
+
 	defaultWireguard = dsl.GenerateWireguard(v1alpha1.WireguardSpec{
 		Address:     "192.168.1.1/24",
 		ServiceType: corev1.ServiceTypeClusterIP,
@@ -50,21 +56,22 @@ func TestMain(m *testing.M) {
 		Peers: v1alpha1.WireguardPeerList{
 			Items: []v1alpha1.WireguardPeer{defaultPeer},
 		},
+		Resolver: fakeResolver,
 	}
 	defaultPeerFact = Peer{
 		Scheme:    scheme,
 		Peer:      defaultPeer,
 		Wireguard: defaultWireguard,
+		Resolver:  fakeResolver,
 	}
 
 	os.Exit(m.Run())
 }
 
-func shouldHaveProperAnnotations(t *testing.T, obj metav1.Object) {
+func shouldHaveProperDecorations(t *testing.T, obj metav1.Object) {
 	t.Helper()
 
-	annotations := obj.GetAnnotations()
-	assert.Contains(t, annotations, lastAppliedAnnotation)
-	assert.NotEmpty(t, annotations[lastAppliedAnnotation])
-	assert.Len(t, obj.GetOwnerReferences(), 1)
+	refs := obj.GetOwnerReferences()
+	assert.Len(t, refs, 1)
+	assert.True(t, *refs[0].Controller, "should be owned by controller")
 }
@@ -0,0 +1,113 @@
+package factory
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+var ErrInvalidPeerSelector = fmt.Errorf("invalid peer selector")
+
+// Chain holding rules of the allow-list, so those can be replaced by the
+// agent without restarting the pods
+const isolationChain = "WIREGUARD-PEERS"
+
+// Returns arguments of iptables rules controlling traffic between peers.
+// Traffic between peers both enters and leaves wireguard interface, so
+// interface rules are appended to FORWARD before generic ACCEPT of the
+// interface. Those depend only on the mode, while peer rules fill the chain
+// of allow-list and are applied live, as peers come and go. Peer rules are
+// input of iptables-restore, which replaces the chain at once, so allowed
+// traffic is not dropped while the chain is rebuilt
+func (fact Wireguard) isolationRules(peers []v1alpha1.WireguardPeer) (
+	iface, peer []string, err error) {
+
+	isolation := fact.Wireguard.Spec.PeerIsolation
+	if isolation == nil {
+		return nil, nil, nil
+	}
+
+	const peerToPeer = "--append FORWARD --in-interface %i --out-interface %i"
+	switch isolation.Mode {
+	case v1alpha1.IsolationIsolated:
+		return []string{peerToPeer + " --jump DROP"}, nil, nil
+	case v1alpha1.IsolationAllowList:
+	default:
+		return nil, nil, nil
+	}
+
+	iface = []string{
+		// chain is left by the previous run of the container in the pod
+		"--new-chain " + isolationChain + " || iptables --flush " + isolationChain,
+		peerToPeer + " --match conntrack --ctstate RELATED,ESTABLISHED --jump ACCEPT",
+		peerToPeer + " --jump " + isolationChain,
+		peerToPeer + " --jump DROP",
+	}
+	// declared chain is flushed, even though tables are not
+	peer = []string{"*filter", ":" + isolationChain + " - [0:0]"}
+	for _, rule := range isolation.Rules {
+		from, err := selectPeers(peers, rule.From)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		to, err := selectPeers(peers, rule.To)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, src := range from {
+			for _, dst := range to {
+				if src == dst {
+					continue
+				}
+
+				allow := fmt.Sprintf("--append %s --source %s --destination %s --jump ACCEPT",
+					isolationChain, src, dst)
+				if !slices.Contains(peer, allow) {
+					peer = append(peer, allow)
+				}
+			}
+		}
+	}
+	peer = append(peer, "COMMIT")
+
+	return iface, peer, nil
+}
+
+// Returns addresses of the peers matching the selector, in order of peers
+func selectPeers(peers []v1alpha1.WireguardPeer, sel v1alpha1.PeerSelector) (
+	[]string, error) {
+
+	selector := labels.Nothing()
+	if sel.Selector != nil {
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(sel.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPeerSelector, err)
+		}
+	}
+
+	addresses := []string{}
+	for _, peer := range peers {
+		if !slices.Contains(sel.Names, peer.GetName()) &&
+			!selector.Matches(labels.Set(peer.GetLabels())) {
+			continue
+		}
+
+		// address might be set with the mask of the tunnel subnet
+		prefix, err := netip.ParsePrefix(string(peer.Spec.Address))
+		if err != nil {
+			return nil, err
+		}
+
+		addresses = append(addresses, prefix.Addr().String())
+	}
+
+	return addresses, nil
+}
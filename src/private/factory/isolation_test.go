@@ -0,0 +1,127 @@
+package factory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
+)
+
+func TestWireguardPeerIsolation(t *testing.T) {
+	t.Parallel()
+
+	makePeer := func(name, address string, labels map[string]string) v1alpha1.WireguardPeer {
+		peer := dsl.NamedPeer(name, v1alpha1.WireguardPeerSpec{
+			Address: v1alpha1.Address(address),
+		})
+		peer.SetLabels(labels)
+		return peer
+	}
+
+	peers := []v1alpha1.WireguardPeer{
+		makePeer("admin", "192.168.254.2/24", nil),
+		makePeer("db", "192.168.254.3/32", map[string]string{"role": "server"}),
+		makePeer("web", "192.168.254.4/32", map[string]string{"role": "server"}),
+		makePeer("laptop", "192.168.254.5/32", nil),
+	}
+
+	type testCase struct {
+		description string
+		isolation   *v1alpha1.PeerIsolation
+		want        []string
+		wantRules   string
+		err         error
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		wg := dsl.GenerateWireguard(v1alpha1.WireguardSpec{
+			PeerIsolation: tc.isolation,
+		}, v1alpha1.WireguardStatus{})
+		fact := Wireguard{
+			Scheme:    scheme,
+			Wireguard: wg,
+			Peers:     v1alpha1.WireguardPeerList{Items: peers},
+		}
+
+		secret, err := fact.Secret("public", "private")
+		assert.ErrorIs(t, err, tc.err)
+		if tc.err != nil {
+			return
+		}
+
+		config := string(secret.Data["config"])
+		for _, line := range tc.want {
+			assert.Contains(t, config, line)
+		}
+		assert.NotContains(t, config, "--source",
+			"should keep peer rules out of the config, which rolls the pods")
+
+		rules := string(secret.Data["rules"])
+		assert.True(t, strings.HasPrefix(rules, tc.wantRules))
+		assert.NotContains(t, rules, "--source 192.168.254.5")
+	})
+
+	const (
+		drop   = "PostUp = iptables --append FORWARD --in-interface %i --out-interface %i --jump DROP\n"
+		accept = "PostUp = iptables --append FORWARD --in-interface %i --jump ACCEPT"
+	)
+
+	spec.Entry("open by default", testCase{
+		want: []string{"ListenPort = 51820\nPostUp = iptables --append FORWARD --in-interface %i --jump ACCEPT"},
+	})
+	spec.Entry("drops traffic between peers before accepting it", testCase{
+		isolation: &v1alpha1.PeerIsolation{Mode: v1alpha1.IsolationIsolated},
+		want:      []string{drop + accept},
+	})
+	spec.Entry("allows listed peers only", testCase{
+		isolation: &v1alpha1.PeerIsolation{
+			Mode: v1alpha1.IsolationAllowList,
+			Rules: []v1alpha1.PeerIsolationRule{{
+				From: v1alpha1.PeerSelector{Names: []string{"admin"}},
+				To: v1alpha1.PeerSelector{
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "server"},
+					},
+				},
+			}, {
+				From: v1alpha1.PeerSelector{Names: []string{"web"}},
+				To:   v1alpha1.PeerSelector{Names: []string{"db", "web"}},
+			}},
+		},
+		want: []string{
+			"PostUp = iptables --new-chain WIREGUARD-PEERS || iptables --flush WIREGUARD-PEERS\n" +
+				"PostUp = iptables --append FORWARD --in-interface %i --out-interface %i --match conntrack --ctstate RELATED,ESTABLISHED --jump ACCEPT\n" +
+				"PostUp = iptables --append FORWARD --in-interface %i --out-interface %i --jump WIREGUARD-PEERS\n" +
+				drop + accept,
+		},
+		wantRules: "printf '%s\\n' '*filter' ':WIREGUARD-PEERS - [0:0]' " +
+			"'--append WIREGUARD-PEERS --source 192.168.254.2 --destination 192.168.254.3 --jump ACCEPT' " +
+			"'--append WIREGUARD-PEERS --source 192.168.254.2 --destination 192.168.254.4 --jump ACCEPT' " +
+			"'--append WIREGUARD-PEERS --source 192.168.254.4 --destination 192.168.254.3 --jump ACCEPT' " +
+			"'COMMIT' | iptables-restore --noflush\n",
+	})
+	spec.Entry("errors on invalid selector", testCase{
+		isolation: &v1alpha1.PeerIsolation{
+			Mode: v1alpha1.IsolationAllowList,
+			Rules: []v1alpha1.PeerIsolationRule{{
+				From: v1alpha1.PeerSelector{
+					Selector: &metav1.LabelSelector{
+						MatchExpressions: []metav1.LabelSelectorRequirement{{
+							Key:      "role",
+							Operator: "Unknown",
+						}},
+					},
+				},
+			}},
+		},
+		err: ErrInvalidPeerSelector,
+	})
+}
@@ -0,0 +1,222 @@
+package factory
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+const (
+	metricsImage    = "mindflavor/prometheus-wireguard-exporter:3.6.6"
+	metricsPort     = 9586
+	metricsPortName = "metrics"
+
+	componentLabel = "app.kubernetes.io/component"
+)
+
+var (
+	ErrUnsupportedMonitor = fmt.Errorf("unsupported monitor kind")
+
+	// Monitor kinds managed by operator, in the order they are reconciled
+	MonitorKinds = []v1alpha1.MonitorKind{
+		v1alpha1.ServiceMonitor,
+		v1alpha1.PodMonitor,
+	}
+)
+
+// Returns group version kind of the prometheus operator monitor
+func MonitorGVK(kind v1alpha1.MonitorKind) schema.GroupVersionKind {
+	return schema.GroupVersionKind{
+		Group:   "monitoring.coreos.com",
+		Version: "v1",
+		Kind:    string(kind),
+	}
+}
+
+func metricsEnabled(spec v1alpha1.WireguardSpec) bool {
+	return spec.Metrics != nil && spec.Metrics.Enabled
+}
+
+func monitorKind(spec v1alpha1.WireguardSpec) v1alpha1.MonitorKind {
+	if spec.Metrics == nil || spec.Metrics.Monitor == "" {
+		return v1alpha1.ServiceMonitor
+	}
+
+	return spec.Metrics.Monitor
+}
+
+// Returns container exporting wireguard metrics. It shares network
+// namespace with wireguard container, so it can read interface stats
+func metricsExporterContainer(spec v1alpha1.WireguardSpec) corev1.Container {
+	image := spec.Metrics.Image
+	if image == "" {
+		image = metricsImage
+	}
+
+	return corev1.Container{
+		Name:            "metrics-exporter",
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Args: []string{
+			"--port", fmt.Sprint(metricsPort),
+			// peer names are taken from friendly_name comments
+			"--extract_names_config_files", "/etc/wireguard/wg0.conf",
+			"--prepend_sudo", "false",
+		},
+		Ports: []corev1.ContainerPort{{
+			ContainerPort: metricsPort,
+			Name:          metricsPortName,
+			Protocol:      corev1.ProtocolTCP,
+		}},
+		SecurityContext: &corev1.SecurityContext{
+			Capabilities: &corev1.Capabilities{
+				Add: []corev1.Capability{"NET_ADMIN"},
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      "config",
+			MountPath: "/etc/wireguard",
+			ReadOnly:  true,
+		}},
+	}
+}
+
+// Returns prometheus operator monitor of the given kind scraping
+// endpoints named metrics of the objects matching labels
+func monitor(kind v1alpha1.MonitorKind, key metav1.ObjectMeta,
+	selector map[string]string) (*unstructured.Unstructured, error) {
+
+	endpoints := []any{
+		map[string]any{"port": metricsPortName},
+	}
+	spec := map[string]any{
+		"selector": map[string]any{
+			"matchLabels": toAnyMap(selector),
+		},
+	}
+	switch kind {
+	case v1alpha1.ServiceMonitor:
+		spec["endpoints"] = endpoints
+	case v1alpha1.PodMonitor:
+		spec["podMetricsEndpoints"] = endpoints
+	default:
+		return nil, ErrUnsupportedMonitor
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(MonitorGVK(kind))
+	obj.SetName(key.Name)
+	obj.SetNamespace(key.Namespace)
+	obj.SetLabels(key.Labels)
+	obj.Object["spec"] = spec
+	return obj, nil
+}
+
+// Returns pod monitor scraping metrics endpoint of the operator itself
+func ManagerMonitor(namespace string, selector map[string]string) (
+	*unstructured.Unstructured, error) {
+
+	labels := map[string]string{
+		"app.kubernetes.io/managed-by": "wireguard-operator",
+	}
+	key := metav1.ObjectMeta{
+		Name:      "wireguard-operator",
+		Namespace: namespace,
+		Labels:    labels,
+	}
+	return monitor(v1alpha1.PodMonitor, key, selector)
+}
+
+// Returns true when metrics exporter is running in the wireguard pod
+func (fact Wireguard) MetricsEnabled() bool {
+	return metricsEnabled(fact.Wireguard.Spec)
+}
+
+// Returns true when monitor of the given kind should exist for the
+// wireguard
+func (fact Wireguard) MonitorEnabled(kind v1alpha1.MonitorKind) bool {
+	spec := fact.Wireguard.Spec
+	return metricsEnabled(spec) && monitorKind(spec) == kind
+}
+
+// Returns labels of the metrics service
+func (fact Wireguard) metricsLabels() map[string]string {
+	labels := fact.Labels()
+	labels[componentLabel] = metricsPortName
+	return labels
+}
+
+// Returns cluster-local service exposing metrics exporter. It is separate
+// from the wireguard service, which might be exposed to the internet
+func (fact Wireguard) MetricsService() (*corev1.Service, error) {
+	wg := fact.Wireguard
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-metrics", wg.GetName()),
+			Namespace: wg.GetNamespace(),
+			Labels:    fact.metricsLabels(),
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeClusterIP,
+			Selector: fact.Labels(),
+			Ports: []corev1.ServicePort{{
+				Name:       metricsPortName,
+				Protocol:   corev1.ProtocolTCP,
+				Port:       metricsPort,
+				TargetPort: intstr.FromString(metricsPortName),
+			}},
+		},
+	}
+
+	result, err := fact.decorate(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*corev1.Service), nil
+}
+
+// Returns prometheus operator monitor of the given kind for the wireguard
+func (fact Wireguard) Monitor(kind v1alpha1.MonitorKind) (
+	*unstructured.Unstructured, error) {
+
+	wg := fact.Wireguard
+	key := metav1.ObjectMeta{
+		Name:      wg.GetName(),
+		Namespace: wg.GetNamespace(),
+		Labels:    fact.Labels(),
+	}
+
+	// service monitor selects services, pod monitor selects pods
+	selector := fact.Labels()
+	if kind == v1alpha1.ServiceMonitor {
+		selector = fact.metricsLabels()
+	}
+
+	mon, err := monitor(kind, key, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := fact.decorate(mon)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*unstructured.Unstructured), nil
+}
+
+func toAnyMap(in map[string]string) map[string]any {
+	out := make(map[string]any, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+
+	return out
+}
@@ -0,0 +1,91 @@
+package factory
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+var ErrInvalidNetwork = fmt.Errorf("invalid network")
+
+// Returns the smallest list of networks covering exactly the same
+// addresses. Node pod networks are usually allocated from a single range,
+// so those collapse into it as long as every node is present
+func AggregateNetworks(cidrs []string) ([]string, error) {
+	prefixes := []netip.Prefix{}
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidNetwork, err)
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	slices.SortFunc(prefixes, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+
+	merged := []netip.Prefix{}
+	for _, prefix := range prefixes {
+		// sorted, so covering network always comes first
+		if n := len(merged); n > 0 && merged[n-1].Overlaps(prefix) {
+			continue
+		}
+
+		merged = append(merged, prefix)
+		for n := len(merged); n > 1; n = len(merged) {
+			a, b := merged[n-2], merged[n-1]
+			if a.Bits() != b.Bits() || a.Bits() == 0 || parent(a) != parent(b) {
+				break
+			}
+
+			merged = append(merged[:n-2], parent(a))
+		}
+	}
+
+	result := []string{}
+	for _, prefix := range merged {
+		result = append(result, prefix.String())
+	}
+
+	return result, nil
+}
+
+// Returns allowed IPs of the peers: .spec.allowedIPs followed by
+// discovered networks of the cluster, unless all traffic is already
+// routed through the tunnel
+func peerAllowedIPs(wg v1alpha1.Wireguard) string {
+	allowedIPs := []string{}
+	for _, ip := range strings.Split(wg.Spec.AllowedIPs, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			allowedIPs = append(allowedIPs, ip)
+		}
+	}
+	if len(allowedIPs) == 0 {
+		allowedIPs = []string{"0.0.0.0/0"}
+	}
+
+	cfg := wg.Spec.ClusterNetworks
+	if cfg == nil || !cfg.Enabled || slices.Contains(allowedIPs, "0.0.0.0/0") {
+		return strings.Join(allowedIPs, ", ")
+	}
+
+	for _, network := range wg.Status.ClusterNetworks {
+		if !slices.Contains(allowedIPs, network) {
+			allowedIPs = append(allowedIPs, network)
+		}
+	}
+
+	return strings.Join(allowedIPs, ", ")
+}
+
+func parent(prefix netip.Prefix) netip.Prefix {
+	return netip.PrefixFrom(prefix.Addr(), prefix.Bits()-1).Masked()
+}
@@ -0,0 +1,109 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
+)
+
+func TestAggregateNetworks(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		networks []string
+		want     []string
+		err      error
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		got, err := AggregateNetworks(tc.networks)
+		assert.ErrorIs(t, err, tc.err)
+		if tc.err == nil {
+			assert.Equal(t, tc.want, got)
+		}
+	})
+
+	spec.Entry("keeps disjoint networks", testCase{
+		networks: []string{"10.96.0.0/12", "10.244.0.0/24"},
+		want:     []string{"10.96.0.0/12", "10.244.0.0/24"},
+	})
+	spec.Entry("merges node networks", testCase{
+		networks: []string{
+			"10.244.3.0/24", "10.244.1.0/24", "10.244.0.0/24", "10.244.2.0/24",
+		},
+		want: []string{"10.244.0.0/22"},
+	})
+	spec.Entry("keeps gaps between node networks", testCase{
+		networks: []string{"10.244.0.0/24", "10.244.2.0/24"},
+		want:     []string{"10.244.0.0/24", "10.244.2.0/24"},
+	})
+	spec.Entry("drops covered and duplicate networks", testCase{
+		networks: []string{"10.244.1.0/24", "10.244.0.0/16", "10.244.1.0/24"},
+		want:     []string{"10.244.0.0/16"},
+	})
+	spec.Entry("supports ipv6", testCase{
+		networks: []string{"fd00:10:244::/64", "fd00:10:244:1::/64"},
+		want:     []string{"fd00:10:244::/63"},
+	})
+	spec.Entry("returns empty list", testCase{
+		networks: nil,
+		want:     []string{},
+	})
+	spec.Entry("errors on invalid network", testCase{
+		networks: []string{"10.244.0.0"},
+		err:      ErrInvalidNetwork,
+	})
+}
+
+func TestPeerAllowedIPs(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		allowedIPs string
+		networks   *v1alpha1.ClusterNetworks
+		want       string
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		wg := dsl.GenerateWireguard(v1alpha1.WireguardSpec{
+			AllowedIPs:      tc.allowedIPs,
+			ClusterNetworks: tc.networks,
+		}, v1alpha1.WireguardStatus{
+			ClusterNetworks: []string{"10.96.0.0/12", "10.244.0.0/16"},
+		})
+		assert.Equal(t, tc.want, peerAllowedIPs(wg))
+	})
+
+	spec.Entry("routes everything by default", testCase{
+		want: "0.0.0.0/0",
+	})
+	spec.Entry("uses allowed IPs of the wireguard", testCase{
+		allowedIPs: "10.0.0.0/8,192.168.0.0/16",
+		want:       "10.0.0.0/8, 192.168.0.0/16",
+	})
+	spec.Entry("ignores cluster networks unless enabled", testCase{
+		allowedIPs: "192.168.0.0/16",
+		networks:   &v1alpha1.ClusterNetworks{},
+		want:       "192.168.0.0/16",
+	})
+	spec.Entry("adds cluster networks", testCase{
+		allowedIPs: "192.168.0.0/16, 10.96.0.0/12",
+		networks:   &v1alpha1.ClusterNetworks{Enabled: true},
+		want:       "192.168.0.0/16, 10.96.0.0/12, 10.244.0.0/16",
+	})
+	spec.Entry("skips cluster networks for full tunnel", testCase{
+		allowedIPs: "0.0.0.0/0",
+		networks:   &v1alpha1.ClusterNetworks{Enabled: true},
+		want:       "0.0.0.0/0",
+	})
+}
@@ -2,8 +2,9 @@ package factory
 
 import (
 	"bytes"
-	"net"
-	"sort"
+	"fmt"
+	"slices"
+	"strings"
 	"text/template"
 
 	corev1 "k8s.io/api/core/v1"
@@ -12,12 +13,20 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/resolver"
 )
 
+var ErrInvalidTemplate = fmt.Errorf("invalid peer config template")
+
 type Peer struct {
 	*runtime.Scheme
 	Peer      v1alpha1.WireguardPeer
 	Wireguard v1alpha1.Wireguard
+	// Go text/template to render peer configuration with. Built-in
+	// template is used when empty. See peerConfig for the data model
+	ConfigTemplate string
+	// Resolver for DNS server hostnames
+	Resolver resolver.Resolver
 }
 
 func (fact Peer) Secret(endpoint, pubKey, privKey string) (
@@ -28,10 +37,6 @@ func (fact Peer) Secret(endpoint, pubKey, privKey string) (
 		return nil, err
 	}
 
-	if err := annotator.SetLastAppliedAnnotation(secret); err != nil {
-		return nil, err
-	}
-
 	if err := ctrl.SetControllerReference(&fact.Peer, secret, fact.Scheme); err != nil {
 		return nil, err
 	}
@@ -57,39 +62,45 @@ func (fact Peer) secret(endpoint, publicKey, privateKey string) (
 		}, nil
 	}
 
-	tmpl, err := template.New("peer").Parse(peerConfigTemplate)
+	tmpl, err := fact.template()
 	if err != nil {
 		return nil, err
 	}
 
-	var dns string
-	if net.ParseIP(fact.Wireguard.Spec.DNS) == nil {
-		// seems like a hostname, try to resolve to ip
-		addrs, err := net.LookupHost(fact.Wireguard.Spec.DNS)
-		if err != nil {
-			return nil, err
-		}
-		// lookup output is not not determenistic, so let's sort it to
-		// avoid infinite reconcilation loop
-		sort.Strings(addrs)
-		dns = addrs[0]
-	} else {
-		// string is valid ip addres, can use as DNS config
-		dns = net.ParseIP(fact.Wireguard.Spec.DNS).String()
+	dnsServers, err := peerDNSServers(fact.Resolver, fact.Wireguard.Spec)
+	if err != nil {
+		return nil, err
 	}
 
+	searchDomains := fact.Wireguard.Spec.DNSSearchDomains
+	dns := strings.Join(append(slices.Clone(dnsServers), searchDomains...), ", ")
+
 	address := fact.Peer.Spec.Address
 	spec := peerConfig{
-		Address:       address,
-		PrivateKey:    privateKey,
-		DNS:           dns,
-		PeerPublicKey: peerPublicKey,
-		Endpoint:      endpoint,
-		AllowedIPs:    "0.0.0.0/0",
+		Name:                peer.GetName(),
+		Namespace:           peer.GetNamespace(),
+		WireguardName:       fact.Wireguard.GetName(),
+		Address:             address,
+		PrivateKey:          privateKey,
+		PublicKey:           publicKey,
+		DNS:                 dns,
+		DNSServers:          dnsServers,
+		SearchDomains:       searchDomains,
+		PeerPublicKey:       peerPublicKey,
+		Endpoint:            endpoint,
+		AllowedIPs:          peerAllowedIPs(fact.Wireguard),
+		MTU:                 fact.mtu(),
+		ListenPort:          ptrOrZero(peer.Spec.ListenPort),
+		FwMark:              peer.Spec.FwMark,
+		Table:               peer.Spec.Table,
+		PersistentKeepalive: defaultKeepalive,
+	}
+	if keepalive := peer.Spec.PersistentKeepalive; keepalive != nil {
+		spec.PersistentKeepalive = *keepalive
 	}
 	buf := new(bytes.Buffer)
 	if err := tmpl.Execute(buf, spec); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTemplate, err)
 	}
 
 	config := buf.Bytes()
@@ -105,28 +116,109 @@ func (fact Peer) secret(endpoint, publicKey, privateKey string) (
 	return secret, nil
 }
 
+// Returns MTU of the peer, zero when neither peer nor wireguard sets it.
+// Auto MTU of the wireguard is detected in the pod, so peers always use
+// the value
+func (fact Peer) mtu() int32 {
+	if mtu := fact.Peer.Spec.MTU; mtu != nil {
+		return *mtu
+	}
+
+	if mtu := fact.Wireguard.Spec.MTU; mtu != nil {
+		return mtu.Value
+	}
+
+	return 0
+}
+
+// Returns parsed template for the peer configuration, either custom or
+// built-in one
+func (fact Peer) template() (*template.Template, error) {
+	text := peerConfigTemplate
+	if fact.ConfigTemplate != "" {
+		text = fact.ConfigTemplate
+	}
+
+	tmpl, err := template.New("peer").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTemplate, err)
+	}
+
+	return tmpl, nil
+}
+
+// Keepalive of the peers without their own, so the tunnel survives NAT
+const defaultKeepalive = 25
+
 const peerConfigTemplate = `[Interface]
 Address = {{ .Address }}
 PrivateKey = {{ .PrivateKey }}
+{{- if .ListenPort }}
+ListenPort = {{ .ListenPort }}
+{{- end }}
+{{- if .FwMark }}
+FwMark = {{ .FwMark }}
+{{- end }}
+{{- if .Table }}
+Table = {{ .Table }}
+{{- end }}
 DNS = {{ .DNS }}
+{{- if .MTU }}
+MTU = {{ .MTU }}
+{{- end }}
 
 [Peer]
 PublicKey = {{ .PeerPublicKey }}
 Endpoint = {{ .Endpoint }}
 AllowedIPs = {{ .AllowedIPs }}
-PersistentKeepalive = 25
+{{- if .PersistentKeepalive }}
+PersistentKeepalive = {{ .PersistentKeepalive }}
+{{- end }}
 `
 
+// Data model available in peer configuration templates
 type peerConfig struct {
+	// name of the peer resource
+	Name string
+	// namespace of the peer resource
+	Namespace string
+	// name of the parent wireguard resource
+	WireguardName string
 	// .spec.Address
 	Address v1alpha1.Address
 	// private key of the peer
 	PrivateKey string
-	// wireguard.spec.DNS.address
+	// public key of the peer
+	PublicKey string
+	// comma separated DNS servers followed by search domains, ready to be
+	// used in DNS field of wg-quick config
 	DNS string
+	// resolved DNS servers of the peer
+	DNSServers []string
+	// wireguard.spec.DNSSearchDomains
+	SearchDomains []string
 	// public key of the parent wireguard resource
 	PeerPublicKey string
 	// public endpoint of the wireguard service
 	Endpoint   string
 	AllowedIPs string
+	// MTU of the peer interface, zero when not set
+	MTU int32
+	// .spec.listenPort, zero when not set
+	ListenPort int32
+	// .spec.fwMark
+	FwMark string
+	// .spec.table
+	Table string
+	// keepalive interval in seconds, zero when disabled
+	PersistentKeepalive int32
+}
+
+func ptrOrZero[V any](ptr *V) V {
+	if ptr == nil {
+		var zero V
+		return zero
+	}
+
+	return *ptr
 }
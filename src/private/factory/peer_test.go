@@ -57,6 +57,7 @@ func TestPeerDnsConfigurations(t *testing.T) {
 			Scheme:    scheme,
 			Peer:      tc.peer,
 			Wireguard: wg,
+			Resolver:  fakeResolver,
 		}
 		secret, err := fact.Secret(ep, tc.pubKey, tc.privKey)
 		assert.NotNil(t, err)
@@ -72,6 +73,7 @@ func TestPeerDnsConfigurations(t *testing.T) {
 			Scheme:    scheme,
 			Peer:      testCtx.peer,
 			Wireguard: wg,
+			Resolver:  fakeResolver,
 		}
 		secret, err := fact.Secret(ep, testCtx.pubKey, testCtx.privKey)
 		assert.Nil(t, err)
@@ -84,6 +86,90 @@ func TestPeerDnsConfigurations(t *testing.T) {
 	}).
 		Entry("uses ip", table{"127.0.0.1", "127.0.0.1"}).
 		Entry("resolves external url", table{"one.one.one.one", "1.0.0.1"})
+
+	o.Spec("errors if resolver is not set", func(tc testContext) {
+		wg := dsl.GenerateWireguard(v1alpha1.WireguardSpec{
+			DNS: "one.one.one.one",
+		}, defaultWireguard.Status)
+		fact := Peer{
+			Scheme:    scheme,
+			Peer:      tc.peer,
+			Wireguard: wg,
+		}
+		_, err := fact.Secret(tc.endpoint, tc.pubKey, tc.privKey)
+		assert.ErrorIs(t, err, ErrResolverNotSet)
+	})
+
+	o.Spec("errors if hostname cannot be resolved", func(tc testContext) {
+		wg := dsl.GenerateWireguard(v1alpha1.WireguardSpec{
+			DNS: "unknown.dns.svc",
+		}, defaultWireguard.Status)
+		fact := Peer{
+			Scheme:    scheme,
+			Peer:      tc.peer,
+			Wireguard: wg,
+			Resolver:  fakeResolver,
+		}
+		_, err := fact.Secret(tc.endpoint, tc.pubKey, tc.privKey)
+		assert.ErrorIs(t, err, ErrDNSResolution)
+	})
+}
+
+func TestPeerSplitDns(t *testing.T) {
+	t.Parallel()
+
+	type table struct {
+		description string
+		spec        v1alpha1.WireguardSpec
+		want        string
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tt table) {
+		wg := dsl.GenerateWireguard(tt.spec, defaultWireguard.Status)
+		fact := Peer{
+			Scheme:    scheme,
+			Peer:      defaultPeer,
+			Wireguard: wg,
+		}
+		secret, err := fact.Secret("127.0.0.1:51820", "kekeke", "lelele")
+		assert.Nil(t, err)
+
+		config := string(secret.Data["config"])
+		assert.Contains(t, config, fmt.Sprintf("DNS = %s\n", tt.want))
+	})
+
+	testCases := []table{{
+		description: "should use multiple dns servers",
+		spec: v1alpha1.WireguardSpec{
+			DNS:        "1.1.1.1",
+			DNSServers: []string{"8.8.8.8", "9.9.9.9"},
+		},
+		want: "1.1.1.1, 8.8.8.8, 9.9.9.9",
+	}, {
+		description: "should append search domains",
+		spec: v1alpha1.WireguardSpec{
+			DNS:              "1.1.1.1",
+			DNSSearchDomains: []string{"svc.cluster.local", "cluster.local"},
+		},
+		want: "1.1.1.1, svc.cluster.local, cluster.local",
+	}, {
+		description: "should use wireguard address when forwarder is enabled",
+		spec: v1alpha1.WireguardSpec{
+			Address:          "192.168.254.1/24",
+			DNS:              "1.1.1.1",
+			DNSServers:       []string{"8.8.8.8"},
+			DNSSearchDomains: []string{"svc.cluster.local"},
+			DNSForwarder:     &v1alpha1.DNSForwarder{Enabled: true},
+		},
+		want: "192.168.254.1, svc.cluster.local",
+	}}
+
+	for _, tt := range testCases {
+		spec.Entry(tt.description, tt)
+	}
 }
 
 func TestPeerDefaultConfigurations(t *testing.T) {
@@ -124,6 +210,130 @@ func TestPeerDefaultConfigurations(t *testing.T) {
 	}
 }
 
+func TestPeerMTU(t *testing.T) {
+	t.Parallel()
+
+	type table struct {
+		description string
+		peerMTU     *int32
+		wgMTU       *v1alpha1.MTU
+		want        string
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tt table) {
+		peer := defaultPeer
+		peer.Spec.MTU = tt.peerMTU
+		wg := defaultWireguard
+		wg.Spec.MTU = tt.wgMTU
+		fact := Peer{
+			Scheme:    scheme,
+			Peer:      peer,
+			Wireguard: wg,
+		}
+		secret, err := fact.Secret("127.0.0.1:51820", "kekeke", "lelele")
+		assert.Nil(t, err)
+
+		config := string(secret.Data["config"])
+		if tt.want == "" {
+			assert.NotContains(t, config, "MTU")
+		} else {
+			assert.Contains(t, config, "\nMTU = "+tt.want+"\n\n[Peer]")
+		}
+	})
+
+	testCases := []table{{
+		description: "should not set mtu by default",
+	}, {
+		description: "should use mtu of the wireguard",
+		wgMTU:       &v1alpha1.MTU{Value: 1380},
+		want:        "1380",
+	}, {
+		description: "should use value with auto mtu of the wireguard",
+		wgMTU:       &v1alpha1.MTU{Value: 1380, Auto: true},
+		want:        "1380",
+	}, {
+		description: "should not set mtu with auto mtu only",
+		wgMTU:       &v1alpha1.MTU{Auto: true},
+	}, {
+		description: "should prefer mtu of the peer",
+		peerMTU:     toPtr[int32](1280),
+		wgMTU:       &v1alpha1.MTU{Value: 1380},
+		want:        "1280",
+	}}
+
+	for _, tt := range testCases {
+		spec.Entry(tt.description, tt)
+	}
+}
+
+func TestPeerTunables(t *testing.T) {
+	t.Parallel()
+
+	type table struct {
+		description string
+		spec        v1alpha1.WireguardPeerSpec
+		want        []string
+		notWant     []string
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tt table) {
+		peer := dsl.GeneratePeer(tt.spec, defaultPeer.Status)
+		fact := Peer{
+			Scheme:    scheme,
+			Peer:      peer,
+			Wireguard: defaultWireguard,
+		}
+		secret, err := fact.Secret("127.0.0.1:51820", "kekeke", "lelele")
+		assert.Nil(t, err)
+
+		config := string(secret.Data["config"])
+		for _, line := range tt.want {
+			assert.Contains(t, config, line)
+		}
+		for _, line := range tt.notWant {
+			assert.NotContains(t, config, line)
+		}
+	})
+
+	testCases := []table{{
+		description: "should use default keepalive only",
+		want:        []string{"PersistentKeepalive = 25\n"},
+		notWant:     []string{"ListenPort", "FwMark", "Table"},
+	}, {
+		description: "should disable keepalive",
+		spec: v1alpha1.WireguardPeerSpec{
+			PersistentKeepalive: toPtr[int32](0),
+		},
+		notWant: []string{"PersistentKeepalive"},
+	}, {
+		description: "should lower keepalive",
+		spec: v1alpha1.WireguardPeerSpec{
+			PersistentKeepalive: toPtr[int32](10),
+		},
+		want: []string{"PersistentKeepalive = 10\n"},
+	}, {
+		description: "should render interface tunables",
+		spec: v1alpha1.WireguardPeerSpec{
+			ListenPort: toPtr[int32](51821),
+			FwMark:     "0xca6c",
+			Table:      "off",
+		},
+		want: []string{
+			"PrivateKey = lelele\nListenPort = 51821\nFwMark = 0xca6c\nTable = off\nDNS = ",
+		},
+	}}
+
+	for _, tt := range testCases {
+		spec.Entry(tt.description, tt)
+	}
+}
+
 func TestPeerKeyIsProvided(t *testing.T) {
 	t.Parallel()
 
@@ -167,5 +377,70 @@ func TestPeerDecorations(t *testing.T) {
 	secret, err := defaultPeerFact.Secret("kekeke", "kekeke", "kekeke")
 	assert.Nil(t, err)
 
-	shouldHaveProperAnnotations(t, secret)
+	shouldHaveProperDecorations(t, secret)
+}
+
+func TestPeerConfigTemplate(t *testing.T) {
+	t.Parallel()
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	ep := "127.0.0.1:51820"
+
+	o.Spec("should render custom template", func(t *testing.T) {
+		fact := defaultPeerFact
+		fact.ConfigTemplate = `# {{ .Namespace }}/{{ .Name }} @ {{ .WireguardName }}
+[Interface]
+Address = {{ .Address }}
+PrivateKey = {{ .PrivateKey }}
+MTU = 1380
+
+[Peer]
+PublicKey = {{ .PeerPublicKey }}
+Endpoint = {{ .Endpoint }}
+`
+		secret, err := fact.Secret(ep, "kekeke", "lelele")
+		assert.Nil(t, err)
+
+		peer := fact.Peer
+		config := string(secret.Data["config"])
+		lines := []string{
+			fmt.Sprintf("# %s/%s @ %s",
+				peer.GetNamespace(), peer.GetName(), fact.Wireguard.GetName()),
+			fmt.Sprintf("Address = %s", peer.Spec.Address),
+			"PrivateKey = lelele",
+			"MTU = 1380",
+			fmt.Sprintf("Endpoint = %s", ep),
+		}
+		for _, line := range lines {
+			assert.Contains(t, config, line)
+		}
+		assert.NotContains(t, config, "PersistentKeepalive")
+	})
+
+	type table struct {
+		description string
+		template    string
+	}
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tt table) {
+		fact := defaultPeerFact
+		fact.ConfigTemplate = tt.template
+		secret, err := fact.Secret(ep, "kekeke", "lelele")
+		assert.ErrorIs(t, err, ErrInvalidTemplate)
+		assert.Nil(t, secret)
+	})
+
+	invalidTemplates := []table{{
+		description: "should fail on syntax error",
+		template:    "Address = {{ .Address ",
+	}, {
+		description: "should fail on unknown field",
+		template:    "Keepalive = {{ .Keepalive }}",
+	}}
+
+	for _, tt := range invalidTemplates {
+		spec.Entry(tt.description, tt)
+	}
 }
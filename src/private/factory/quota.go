@@ -0,0 +1,16 @@
+package factory
+
+import (
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+)
+
+// Returns whether the peer has transferred its quota during the current
+// period. Usage is reset by operator when the period changes
+func QuotaExceeded(peer v1alpha1.WireguardPeer) bool {
+	quota, usage := peer.Spec.Quota, peer.Status.Usage
+	if quota == nil || usage == nil {
+		return false
+	}
+
+	return usage.ReceivedBytes+usage.SentBytes >= quota.Limit.Value()
+}
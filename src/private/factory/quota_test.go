@@ -0,0 +1,77 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
+)
+
+func TestWireguardQuota(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		description string
+		quota       *v1alpha1.Quota
+		usage       *v1alpha1.TrafficUsage
+		excluded    bool
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		peer := dsl.GeneratePeer(v1alpha1.WireguardPeerSpec{
+			Address: "192.168.254.2/32",
+			Quota:   tc.quota,
+		}, v1alpha1.WireguardPeerStatus{
+			PublicKey: toPtr("kekeke"),
+			Usage:     tc.usage,
+		})
+		assert.Equal(t, tc.excluded, QuotaExceeded(peer))
+
+		fact := Wireguard{
+			Scheme: scheme,
+			Wireguard: dsl.GenerateWireguard(
+				v1alpha1.WireguardSpec{},
+				v1alpha1.WireguardStatus{},
+			),
+			Peers: v1alpha1.WireguardPeerList{
+				Items: []v1alpha1.WireguardPeer{peer},
+			},
+		}
+		secret, err := fact.Secret("public", "private")
+		assert.Nil(t, err)
+
+		config := string(secret.Data["config"])
+		if tc.excluded {
+			assert.NotContains(t, config, "kekeke")
+		} else {
+			assert.Contains(t, config, "kekeke")
+		}
+	})
+
+	limit := &v1alpha1.Quota{Limit: resource.MustParse("1Ki")}
+	spec.Entry("without quota", testCase{
+		usage:    &v1alpha1.TrafficUsage{ReceivedBytes: 4096},
+		excluded: false,
+	})
+	spec.Entry("without usage", testCase{
+		quota:    limit,
+		excluded: false,
+	})
+	spec.Entry("under the limit", testCase{
+		quota:    limit,
+		usage:    &v1alpha1.TrafficUsage{ReceivedBytes: 512, SentBytes: 511},
+		excluded: false,
+	})
+	spec.Entry("both directions count towards the limit", testCase{
+		quota:    limit,
+		usage:    &v1alpha1.TrafficUsage{ReceivedBytes: 512, SentBytes: 512},
+		excluded: true,
+	})
+}
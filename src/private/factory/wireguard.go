@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"maps"
+	"path"
 	"strings"
 	"text/template"
 
-	"github.com/cisco-open/k8s-objectmatcher/patch"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -17,43 +19,43 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/resolver"
 )
 
 const (
 	wireguardImage = "linuxserver/wireguard:1.0.20210914"
 	wireguardPort  = 51820
-
-	configHashAnnotation  = "vpn.ahova.com/config-hash"
-	lastAppliedAnnotation = "vpn.ahova.com/last-applied"
-
-	entrypointSh = `#!/bin/sh
-set -e
-
-finish () {
-	echo "$(date): Shutting down Wireguard"
-	wg-quick down wg0
-	exit 0
-}
-
-trap finish TERM INT QUIT
-echo "$(date): Starting up Wireguard"
-wg-quick up wg0
-
-echo "Wireguard started, sleeping..."
-sleep infinity`
+	// Port of the agent health and metrics endpoints, counters of the peers
+	// are read there by the operator
+	AgentPort   = 9587
+	agentVolume = "agent"
+	agentDir    = "/opt/agent"
+	// Image of the operator, which includes wg-agent and wg-audit binaries.
+	// Used when factory is not given the image of the running operator
+	operatorImage = "ghcr.io/cornbuddy/wireguard-operator:latest"
+
+	configHashAnnotation = "vpn.ahova.com/config-hash"
 )
 
 var (
 	ErrEndpointNotSet         = fmt.Errorf("public ip not yet set")
 	ErrUnsupportedServiceType = fmt.Errorf("unsupported service type")
-
-	annotator = patch.NewAnnotator(lastAppliedAnnotation)
+	ErrResolverNotSet         = fmt.Errorf("resolver is required for hostnames")
+	ErrDNSResolution          = fmt.Errorf("cannot resolve DNS server")
 )
 
 type Wireguard struct {
 	*runtime.Scheme
 	v1alpha1.Wireguard
 	Peers v1alpha1.WireguardPeerList
+	// Resolver for DNS server hostnames
+	Resolver resolver.Resolver
+	// Image of the operator the agent is installed from, so it matches the
+	// operator rendering its config
+	OperatorImage string
+	// Namespace of the operator, which pods are admitted to the agent by
+	// network policy to read quotas. Agent is not admitted when empty
+	OperatorNamespace string
 }
 
 // Returns labels for the wireguard resource
@@ -66,6 +68,13 @@ func (fact Wireguard) Labels() map[string]string {
 	return labels
 }
 
+// Returns labels of the operator pods, as set by its manifest
+func OperatorLabels() map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name": "wireguard-operator",
+	}
+}
+
 // Returns desired endpoint address for peer
 func (fact Wireguard) ExtractEndpoint(svc corev1.Service) (*string, error) {
 	if fact.Wireguard.Spec.EndpointAddress != nil {
@@ -113,6 +122,11 @@ func (fact Wireguard) extractEndpointFromLoadBalancer(svc corev1.Service) string
 	}
 }
 
+// Returns resolved addresses of the upstream DNS servers
+func (fact Wireguard) DNSServers() ([]string, error) {
+	return upstreamDNSServers(fact.Resolver, fact.Wireguard.Spec)
+}
+
 func (fact Wireguard) ConfigMap() (*corev1.ConfigMap, error) {
 	cm := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
@@ -120,9 +134,16 @@ func (fact Wireguard) ConfigMap() (*corev1.ConfigMap, error) {
 			Namespace: fact.Wireguard.GetNamespace(),
 			Labels:    fact.Labels(),
 		},
-		Data: map[string]string{
-			"entrypoint.sh": entrypointSh,
-		},
+		Data: map[string]string{},
+	}
+
+	if dnsForwarderEnabled(fact.Wireguard.Spec) {
+		cfg, err := corefile(fact.Resolver, fact.Wireguard.Spec)
+		if err != nil {
+			return nil, err
+		}
+
+		cm.Data["Corefile"] = cfg
 	}
 
 	result, err := fact.decorate(cm)
@@ -178,31 +199,64 @@ func (fact Wireguard) Secret(pubKey, privKey string) (*corev1.Secret, error) {
 	}
 
 	var wireguardPeers []serverPeer
+	var activePeers []v1alpha1.WireguardPeer
 	for _, peer := range fact.Peers.Items {
 		// somehow expected: peer crd is created, but not yet reconciled
 		if peer.Status.PublicKey == nil {
 			continue
 		}
 
+		// suspended peers keep their keys, but must not be able to connect
+		if peer.Spec.Suspended || QuotaExceeded(peer) {
+			continue
+		}
+
 		allowedIPs := peer.Spec.Address
 		wireguardPeers = append(wireguardPeers, serverPeer{
 			AllowedIPs:   allowedIPs,
 			FriendlyName: peer.GetName(),
 			PublicKey:    *peer.Status.PublicKey,
 		})
+		activePeers = append(activePeers, peer)
+	}
+
+	isolationRules, isolationPeerRules, err := fact.isolationRules(activePeers)
+	if err != nil {
+		return nil, err
+	}
+
+	limits, err := fact.peerLimits(activePeers)
+	if err != nil {
+		return nil, err
 	}
+
 	spec := serverConfig{
 		Address:           fact.Wireguard.Spec.Address,
 		PrivateKey:        string(privKey),
 		ListenPort:        wireguardPort,
 		DropConnectionsTo: fact.Wireguard.Spec.DropConnectionsTo,
+		IsolationRules:    isolationRules,
 		Peers:             wireguardPeers,
 	}
+	if mtu := fact.Wireguard.Spec.MTU; mtu != nil {
+		spec.AutoMTU = mtu.Auto
+		if !mtu.Auto {
+			spec.MTU = mtu.Value
+		}
+	}
 	buf := new(bytes.Buffer)
 	if err := tmpl.Execute(buf, spec); err != nil {
 		return nil, err
 	}
 
+	rules, err := peerRules(peerRulesSpec{
+		IsolationRules: isolationPeerRules,
+		Limits:         limits,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fact.Wireguard.Name,
@@ -212,6 +266,7 @@ func (fact Wireguard) Secret(pubKey, privKey string) (*corev1.Secret, error) {
 		Type: corev1.SecretTypeOpaque,
 		Data: map[string][]byte{
 			"config":      buf.Bytes(),
+			"rules":       rules,
 			"public-key":  []byte(pubKey),
 			"private-key": []byte(privKey),
 		},
@@ -225,6 +280,115 @@ func (fact Wireguard) Secret(pubKey, privKey string) (*corev1.Secret, error) {
 	return result.(*corev1.Secret), nil
 }
 
+// Returns true when pod disruption budget should exist for the wireguard
+func (fact Wireguard) PodDisruptionBudgetEnabled() bool {
+	pdb := fact.Wireguard.Spec.PodDisruptionBudget
+	replicas := fact.Wireguard.Spec.Replicas
+	return pdb != nil && pdb.Enabled && (replicas == nil || *replicas > 1)
+}
+
+func (fact Wireguard) PodDisruptionBudget() (*policyv1.PodDisruptionBudget, error) {
+	wg := fact.Wireguard
+	maxUnavailable := intstr.FromInt32(1)
+	if wg.Spec.PodDisruptionBudget != nil &&
+		wg.Spec.PodDisruptionBudget.MaxUnavailable != nil {
+		maxUnavailable = *wg.Spec.PodDisruptionBudget.MaxUnavailable
+	}
+
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      wg.GetName(),
+			Namespace: wg.GetNamespace(),
+			Labels:    fact.Labels(),
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MaxUnavailable: &maxUnavailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: fact.Labels(),
+			},
+		},
+	}
+
+	result, err := fact.decorate(pdb)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*policyv1.PodDisruptionBudget), nil
+}
+
+// Returns true when network policy should exist for the wireguard
+func (fact Wireguard) NetworkPolicyEnabled() bool {
+	np := fact.Wireguard.Spec.NetworkPolicy
+	return np != nil && np.Enabled
+}
+
+// Returns network policy admitting only wireguard traffic to the pods,
+// scrapes of metrics exporter if it's enabled, and operator reading quotas
+// from the agent. Agent exposes the peers, so only operator is admitted
+func (fact Wireguard) NetworkPolicy() (*networkingv1.NetworkPolicy, error) {
+	wg := fact.Wireguard
+	udp := corev1.ProtocolUDP
+	port := intstr.FromInt32(wireguardPort)
+	tcp := corev1.ProtocolTCP
+	ports := []networkingv1.NetworkPolicyPort{{
+		Protocol: &udp,
+		Port:     &port,
+	}}
+	if metricsEnabled(wg.Spec) {
+		metrics := intstr.FromInt32(metricsPort)
+		ports = append(ports, networkingv1.NetworkPolicyPort{
+			Protocol: &tcp,
+			Port:     &metrics,
+		})
+	}
+	rules := []networkingv1.NetworkPolicyIngressRule{{
+		Ports: ports,
+	}}
+	if fact.OperatorNamespace != "" {
+		agent := intstr.FromInt32(AgentPort)
+		rules = append(rules, networkingv1.NetworkPolicyIngressRule{
+			Ports: []networkingv1.NetworkPolicyPort{{
+				Protocol: &tcp,
+				Port:     &agent,
+			}},
+			From: []networkingv1.NetworkPolicyPeer{{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						corev1.LabelMetadataName: fact.OperatorNamespace,
+					},
+				},
+				PodSelector: &metav1.LabelSelector{
+					MatchLabels: OperatorLabels(),
+				},
+			}},
+		})
+	}
+	np := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      wg.GetName(),
+			Namespace: wg.GetNamespace(),
+			Labels:    fact.Labels(),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: fact.Labels(),
+			},
+			PolicyTypes: []networkingv1.PolicyType{
+				networkingv1.PolicyTypeIngress,
+			},
+			Ingress: rules,
+		},
+	}
+
+	result, err := fact.decorate(np)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*networkingv1.NetworkPolicy), nil
+}
+
 func (fact Wireguard) Deployment(configHash string) (*appsv1.Deployment, error) {
 	deploy := fact.deployment(configHash)
 	result, err := fact.decorate(&deploy)
@@ -238,11 +402,91 @@ func (fact Wireguard) Deployment(configHash string) (*appsv1.Deployment, error)
 // Returns desired deployment for the current wireguard instance
 func (fact Wireguard) deployment(configHash string) appsv1.Deployment {
 	wireguard := fact.Wireguard
-	volumes := []corev1.Volume{{
+	volumes := wireguardVolumes(wireguard.Name)
+	volumes[0].Secret.Items = append(volumes[0].Secret.Items, corev1.KeyToPath{
+		Key:  "rules",
+		Path: "wg0.rules",
+	})
+	wireguardContainer := wireguardContainer(readinessProbe(wireguard.Spec))
+	wireguardContainer.Command = append(wireguardContainer.Command,
+		"--rules", "/etc/wireguard/wg0.rules")
+	wireguardContainer.Ports = []corev1.ContainerPort{{
+		ContainerPort: wireguardPort,
+		Name:          "wireguard",
+		Protocol:      "UDP",
+	}}
+	containers := []corev1.Container{wireguardContainer}
+	if dnsForwarderEnabled(wireguard.Spec) {
+		containers = append(containers, dnsForwarderContainer(wireguard.Spec))
+		volumes = append(volumes, corev1.Volume{
+			Name: "dns-forwarder",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: wireguard.Name,
+					},
+					Items: []corev1.KeyToPath{{
+						Key:  "Corefile",
+						Path: "Corefile",
+					}},
+				},
+			},
+		})
+	}
+	if metricsEnabled(wireguard.Spec) {
+		containers = append(containers, metricsExporterContainer(wireguard.Spec))
+	}
+	if auditEnabled(wireguard.Spec) {
+		containers = append(containers,
+			auditContainer(wireguard.Spec, imageOrDefault(fact.OperatorImage)))
+		if wireguard.Spec.Audit.Sink == v1alpha1.AuditFile {
+			volumes = append(volumes, auditLogVolume())
+		}
+	}
+	containers = append(containers, wireguard.Spec.Sidecars...)
+	podTemplate := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: fact.Labels(),
+			Annotations: map[string]string{
+				configHashAnnotation: configHash,
+			},
+		},
+		Spec: corev1.PodSpec{
+			Affinity: wireguard.Spec.Affinity,
+			InitContainers: []corev1.Container{
+				agentInitContainer(imageOrDefault(fact.OperatorImage)),
+			},
+			Containers:      containers,
+			SecurityContext: wireguardPodSecurityContext(),
+			Volumes:         volumes,
+		},
+	}
+
+	return appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      wireguard.Name,
+			Namespace: wireguard.Namespace,
+			Labels:    fact.Labels(),
+		},
+		Spec: appsv1.DeploymentSpec{
+			// nil leaves replicas to the other field managers
+			Replicas: wireguard.Spec.Replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: fact.Labels(),
+			},
+			Template: podTemplate,
+		},
+	}
+}
+
+// Returns volumes with wireguard config from the secret named after the
+// owner and the agent binary installed by agentInitContainer
+func wireguardVolumes(name string) []corev1.Volume {
+	return []corev1.Volume{{
 		Name: "config",
 		VolumeSource: corev1.VolumeSource{
 			Secret: &corev1.SecretVolumeSource{
-				SecretName: wireguard.Name,
+				SecretName: name,
 				Items: []corev1.KeyToPath{{
 					Key:  "config",
 					Path: "wg0.conf",
@@ -250,31 +494,52 @@ func (fact Wireguard) deployment(configHash string) appsv1.Deployment {
 			},
 		},
 	}, {
-		Name: "entrypoint",
+		Name: agentVolume,
 		VolumeSource: corev1.VolumeSource{
-			ConfigMap: &corev1.ConfigMapVolumeSource{
-				LocalObjectReference: corev1.LocalObjectReference{
-					Name: wireguard.Name,
-				},
-				Items: []corev1.KeyToPath{{
-					Key:  "entrypoint.sh",
-					Path: "entrypoint.sh",
-					Mode: toPtr[int32](0755),
-				}},
-			},
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
 		},
 	}}
+}
+
+// Returns container copying the agent binary from the operator image, so
+// it can run in the wireguard image, which provides tools for the hooks
+func agentInitContainer(image string) corev1.Container {
+	return corev1.Container{
+		Name:            "install-agent",
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command: []string{
+			"/wg-agent",
+			"--install", path.Join(agentDir, "wg-agent"),
+		},
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      agentVolume,
+			MountPath: agentDir,
+		}},
+	}
+}
+
+// Returns container running the agent, which brings wg0 interface up and
+// applies config changes live. Volumes are expected to be created by
+// wireguardVolumes and the agent installed by agentInitContainer
+func wireguardContainer(readiness *corev1.Probe) corev1.Container {
 	mounts := []corev1.VolumeMount{{
 		Name:      "config",
 		MountPath: "/etc/wireguard",
 	}, {
-		Name:      "entrypoint",
-		MountPath: "/opt/bin",
+		Name:      agentVolume,
+		MountPath: agentDir,
+		ReadOnly:  true,
 	}}
-	wireguardContainer := corev1.Container{
-		Image:           wireguardImage,
-		Name:            "wireguard",
-		Command:         []string{"/opt/bin/entrypoint.sh"},
+	return corev1.Container{
+		Image: wireguardImage,
+		Name:  "wireguard",
+		Command: []string{
+			path.Join(agentDir, "wg-agent"),
+			"--device", "wg0",
+			"--config", "/etc/wireguard/wg0.conf",
+			"--bind-address", fmt.Sprintf(":%d", AgentPort),
+		},
 		ImagePullPolicy: corev1.PullIfNotPresent,
 		VolumeMounts:    mounts,
 		SecurityContext: &corev1.SecurityContext{
@@ -286,19 +551,11 @@ func (fact Wireguard) deployment(configHash string) appsv1.Deployment {
 				},
 			},
 		},
-		Ports: []corev1.ContainerPort{{
-			ContainerPort: wireguardPort,
-			Name:          "wireguard",
-			Protocol:      "UDP",
-		}},
 		LivenessProbe: &corev1.Probe{
 			ProbeHandler: corev1.ProbeHandler{
-				Exec: &corev1.ExecAction{
-					Command: []string{
-						"/bin/sh",
-						"-c",
-						"ip link show wg0 up",
-					},
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: "/healthz",
+					Port: intstr.FromInt32(AgentPort),
 				},
 			},
 			FailureThreshold:    2,
@@ -307,72 +564,68 @@ func (fact Wireguard) deployment(configHash string) appsv1.Deployment {
 			TimeoutSeconds:      1,
 			PeriodSeconds:       10,
 		},
-		ReadinessProbe: &corev1.Probe{
-			ProbeHandler: corev1.ProbeHandler{
-				HTTPGet: &corev1.HTTPGetAction{
-					Host:   "google.com",
-					Scheme: "HTTPS",
-					Path:   "/",
-					Port:   intstr.IntOrString{IntVal: 443},
-					HTTPHeaders: []corev1.HTTPHeader{{
-						Name:  "Host",
-						Value: "www.google.com",
-					}},
-				},
-			},
-			FailureThreshold:    2,
-			SuccessThreshold:    3,
-			InitialDelaySeconds: 10,
-			TimeoutSeconds:      10,
-			PeriodSeconds:       10,
-		},
+		ReadinessProbe: readiness,
 	}
-	containers := append(
-		[]corev1.Container{wireguardContainer},
-		wireguard.Spec.Sidecars...,
-	)
-	podTemplate := corev1.PodTemplateSpec{
-		ObjectMeta: metav1.ObjectMeta{
-			Labels: fact.Labels(),
-			Annotations: map[string]string{
-				configHashAnnotation: configHash,
-			},
-		},
-		Spec: corev1.PodSpec{
-			Affinity:   wireguard.Spec.Affinity,
-			Containers: containers,
-			SecurityContext: &corev1.PodSecurityContext{
-				Sysctls: []corev1.Sysctl{{
-					Name:  "net.ipv4.ip_forward",
-					Value: "1",
-				}, {
-					Name:  "net.ipv4.conf.all.src_valid_mark",
-					Value: "1",
-				}, {
-					Name:  "net.ipv4.conf.all.rp_filter",
-					Value: "0",
-				}, {
-					Name:  "net.ipv4.conf.all.route_localnet",
-					Value: "1",
-				}},
-			},
-			Volumes: volumes,
-		},
+}
+
+// Returns sysctls required for routing traffic through the tunnel
+func wireguardPodSecurityContext() *corev1.PodSecurityContext {
+	return &corev1.PodSecurityContext{
+		Sysctls: []corev1.Sysctl{{
+			Name:  "net.ipv4.ip_forward",
+			Value: "1",
+		}, {
+			Name:  "net.ipv4.conf.all.src_valid_mark",
+			Value: "1",
+		}, {
+			Name:  "net.ipv4.conf.all.rp_filter",
+			Value: "0",
+		}, {
+			Name:  "net.ipv4.conf.all.route_localnet",
+			Value: "1",
+		}},
 	}
+}
 
-	return appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      wireguard.Name,
-			Namespace: wireguard.Namespace,
-			Labels:    fact.Labels(),
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &wireguard.Spec.Replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: fact.Labels(),
+// Returns readiness probe of the wireguard container. Built-in one does
+// not require internet access, so it works in air-gapped clusters
+func readinessProbe(spec v1alpha1.WireguardSpec) *corev1.Probe {
+	cfg := spec.ReadinessProbe
+	if cfg == nil {
+		cfg = &v1alpha1.ReadinessProbe{}
+	}
+
+	if cfg.Disabled {
+		return nil
+	} else if cfg.Custom != nil {
+		return cfg.Custom
+	}
+
+	// interface is up and configured
+	command := []string{"wg", "show", "wg0", "listen-port"}
+	if cfg.Target != "" {
+		// target is passed as an argument, so it's never interpreted
+		// by shell
+		command = []string{
+			"/bin/sh",
+			"-c",
+			`wg show wg0 listen-port > /dev/null && ping -c 1 -W 2 "$1"`,
+			"readiness",
+			cfg.Target,
+		}
+	}
+
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: command,
 			},
-			Template: podTemplate,
 		},
+		FailureThreshold:    2,
+		SuccessThreshold:    1,
+		InitialDelaySeconds: 5,
+		TimeoutSeconds:      5,
+		PeriodSeconds:       10,
 	}
 }
 
@@ -383,15 +636,20 @@ func (fact Wireguard) decorate(obj client.Object) (client.Object, error) {
 		return nil, err
 	}
 
-	if err := annotator.SetLastAppliedAnnotation(obj); err != nil {
-		return nil, err
-	}
-
 	return obj, nil
 }
 
 func toPtr[V any](o V) *V { return &o }
 
+// Returns image of the operator, the default one when not set
+func imageOrDefault(image string) string {
+	if image == "" {
+		return operatorImage
+	}
+
+	return image
+}
+
 type serverPeer struct {
 	AllowedIPs   v1alpha1.Address
 	FriendlyName string
@@ -402,17 +660,31 @@ type serverConfig struct {
 	Address           v1alpha1.Address
 	PrivateKey        string
 	ListenPort        int32
+	MTU               int32
+	AutoMTU           bool
 	DropConnectionsTo []string
+	IsolationRules    []string
 	Peers             []serverPeer
 }
 
+// Auto MTU leaves room for 80 bytes of wireguard overhead over IPv6, same
+// as wg-quick does
 const serverConfigTemplate = `[Interface]
 Address = {{ .Address }}
 PrivateKey = {{ .PrivateKey }}
 ListenPort = {{ .ListenPort }}
+{{- if .MTU }}
+MTU = {{ .MTU }}
+{{- end }}
+{{- if .AutoMTU }}
+PostUp = ip link set dev %i mtu $(( $(cat /sys/class/net/eth0/mtu) - 80 ))
+{{- end }}
 {{- range .DropConnectionsTo }}
 PostUp = iptables --insert FORWARD --source {{ $.Address }} --destination {{ . }} --jump DROP
 {{- end }}
+{{- range .IsolationRules }}
+PostUp = iptables {{ . }}
+{{- end }}
 PostUp = iptables --append FORWARD --in-interface %i --jump ACCEPT
 PostUp = iptables --append FORWARD --out-interface %i --jump ACCEPT
 PostUp = iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE
@@ -423,3 +695,82 @@ SaveConfig = false
 PublicKey = {{ .PublicKey }}
 AllowedIPs = {{ .AllowedIPs }}
 {{ end }}`
+
+type peerRulesSpec struct {
+	IsolationRules []string
+	Limits         []peerLimit
+	// input of tc batch, rendered from the limits
+	LimitCommands []string
+}
+
+// Rules depending on the peers. Those are kept out of the config, which
+// interface section rolls the pods, and are run by the agent on every
+// change, so each rule set replaces the previous one
+const peerRulesTemplate = `
+{{- with .IsolationRules }}
+{{ pipe "iptables-restore --noflush" . }}
+{{- end }}
+{{ pipe "tc -batch -" .LimitCommands }}
+`
+
+// Commands of tc rebuilding limits of the peers. Qdiscs are recreated, as
+// classes and filters can't be replaced at once, but within a single batch,
+// so traffic is not left unlimited between runs of tc. Qdiscs are replaced
+// before deletion, so those exist and batch is not stopped by the error
+const peerLimitsTemplate = `
+qdisc replace dev %i root handle 1: htb
+qdisc del dev %i root
+qdisc replace dev %i handle ffff: ingress
+qdisc del dev %i ingress
+{{- if . }}
+qdisc add dev %i root handle 1: htb
+qdisc add dev %i handle ffff: ingress
+{{- end }}
+{{- range . }}
+{{- if .Egress }}
+class add dev %i parent 1: classid 1:{{ .ClassID }} htb rate {{ .Egress }}bit
+filter add dev %i parent 1: protocol ip prio 1 u32 match ip dst {{ .Address }}/32 flowid 1:{{ .ClassID }}
+{{- end }}
+{{- if .Ingress }}
+filter add dev %i parent ffff: protocol ip prio 1 u32 match ip src {{ .Address }}/32 police rate {{ .Ingress }}bit burst {{ .Burst }} drop
+{{- end }}
+{{- end }}
+`
+
+// Returns script of the rules depending on the peers, one command per line
+func peerRules(spec peerRulesSpec) ([]byte, error) {
+	limitsTmpl, err := template.New("limits").Parse(peerLimitsTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := new(bytes.Buffer)
+	if err := limitsTmpl.Execute(limits, spec.Limits); err != nil {
+		return nil, err
+	}
+	spec.LimitCommands = strings.Split(strings.TrimSpace(limits.String()), "\n")
+
+	funcs := template.FuncMap{"pipe": pipe}
+	tmpl, err := template.New("rules").Funcs(funcs).Parse(peerRulesTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, spec); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimLeft(buf.Bytes(), "\n"), nil
+}
+
+// Returns command line feeding the lines into stdin of the command, so
+// those are applied by a single run of it. Lines must not contain quotes
+func pipe(command string, lines []string) string {
+	quoted := make([]string, 0, len(lines))
+	for _, line := range lines {
+		quoted = append(quoted, "'"+line+"'")
+	}
+
+	return fmt.Sprintf("printf '%%s\\n' %s | %s", strings.Join(quoted, " "), command)
+}
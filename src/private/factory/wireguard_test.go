@@ -1,6 +1,7 @@
 package factory
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 
@@ -9,8 +10,10 @@ import (
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/cornbuddy/wireguard-operator/src/api/v1alpha1"
+	"github.com/cornbuddy/wireguard-operator/src/private/wgquick"
 	"github.com/cornbuddy/wireguard-operator/src/test/dsl"
 )
 
@@ -74,7 +77,7 @@ func TestWireguardResourcesShouldHaveProperDecorations(t *testing.T) {
 
 	defaults := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
 		assert.Equal(t, defaultLabels, tc.Resource.GetLabels())
-		shouldHaveProperAnnotations(t, tc.Resource)
+		shouldHaveProperDecorations(t, tc.Resource)
 	})
 
 	testCases := []testCase{{
@@ -224,16 +227,91 @@ func TestWireguardConfigMap(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, configMap)
 
-	data := configMap.Data
-	assert.Len(t, data, 1)
-	assert.Contains(t, data, "entrypoint.sh")
-	assert.NotEmpty(t, data["entrypoint.sh"])
+	assert.Empty(t, configMap.Data,
+		"should not hold anything without dns forwarder")
+}
+
+func TestWireguardDnsForwarder(t *testing.T) {
+	t.Parallel()
+
+	wg := dsl.GenerateWireguard(v1alpha1.WireguardSpec{
+		DNS:        "1.1.1.1",
+		DNSServers: []string{"8.8.8.8"},
+		DNSForwarder: &v1alpha1.DNSForwarder{
+			Enabled:       true,
+			ClusterDomain: "kek.local",
+		},
+	}, v1alpha1.WireguardStatus{})
+	fact := Wireguard{
+		Scheme:    scheme,
+		Wireguard: wg,
+		Peers:     v1alpha1.WireguardPeerList{},
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	o.Spec("config map should contain corefile", func(t *testing.T) {
+		cm, err := fact.ConfigMap()
+		assert.Nil(t, err)
+		assert.Contains(t, cm.Data, "Corefile")
+
+		corefile := cm.Data["Corefile"]
+		lines := []string{
+			"kek.local:53 {",
+			"forward . /etc/resolv.conf",
+			".:53 {",
+			"forward . 1.1.1.1 8.8.8.8",
+		}
+		for _, line := range lines {
+			assert.Contains(t, corefile, line)
+		}
+	})
+
+	o.Spec("deployment should run forwarder", func(t *testing.T) {
+		deploy, err := fact.Deployment("kekeke")
+		assert.Nil(t, err)
+
+		podSpec := deploy.Spec.Template.Spec
+		assert.Len(t, podSpec.Containers, 2)
+		assert.Len(t, podSpec.Volumes, 3)
+
+		forwarder := podSpec.Containers[1]
+		assert.Equal(t, "dns-forwarder", forwarder.Name)
+		assert.Equal(t, dnsForwarderImage, forwarder.Image)
+	})
+
+	o.Spec("should resolve upstream hostnames", func(t *testing.T) {
+		wg := dsl.GenerateWireguard(v1alpha1.WireguardSpec{
+			DNS:          "one.one.one.one",
+			DNSServers:   []string{"internal.dns.svc", "8.8.8.8"},
+			DNSForwarder: &v1alpha1.DNSForwarder{Enabled: true},
+		}, v1alpha1.WireguardStatus{})
+		fact := Wireguard{
+			Scheme:    scheme,
+			Wireguard: wg,
+			Resolver:  fakeResolver,
+		}
 
-	ep := data["entrypoint.sh"]
-	assert.Contains(t, ep, "set -e")
-	assert.Contains(t, ep, "wg-quick up")
-	assert.Contains(t, ep, "wg-quick down")
+		dns, err := fact.DNSServers()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1.0.0.1", "10.96.0.10", "8.8.8.8"}, dns)
 
+		cm, err := fact.ConfigMap()
+		assert.Nil(t, err)
+		assert.Contains(t, cm.Data["Corefile"],
+			"forward . 1.0.0.1 10.96.0.10 8.8.8.8")
+	})
+
+	o.Spec("should not run forwarder by default", func(t *testing.T) {
+		cm, err := defaultWgFact.ConfigMap()
+		assert.Nil(t, err)
+		assert.NotContains(t, cm.Data, "Corefile")
+
+		deploy, err := defaultWgFact.Deployment("kekeke")
+		assert.Nil(t, err)
+		assert.Len(t, deploy.Spec.Template.Spec.Containers, 1)
+	})
 }
 
 func TestWireguardService(t *testing.T) {
@@ -281,7 +359,7 @@ func TestWireguardService(t *testing.T) {
 			v1alpha1.WireguardSpec{},
 			v1alpha1.WireguardStatus{},
 		),
-		serviceAnnotationsLen: 1,
+		serviceAnnotationsLen: 0,
 	}, {
 		description: "extra annotation",
 		wireguard: dsl.GenerateWireguard(
@@ -292,7 +370,7 @@ func TestWireguardService(t *testing.T) {
 			},
 			v1alpha1.WireguardStatus{},
 		),
-		serviceAnnotationsLen: 2,
+		serviceAnnotationsLen: 1,
 	}}
 
 	spec := onpar.TableSpec(o, func(t *testing.T, test testCase) {
@@ -361,6 +439,11 @@ func TestWireguardSecret(t *testing.T) {
 			assert.Contains(t, config, line)
 		}
 
+		// keys of the fixture peers are not valid
+		iface := wgquick.InterfaceSection(data["config"])
+		_, err = wgquick.Parse(bytes.NewReader(iface))
+		assert.Nil(t, err, "should be understood by the agent")
+
 		gotPrivKey := string(secret.Data["private-key"])
 		gotPubKey := string(secret.Data["public-key"])
 		assert.Equal(t, wantPrivKey, gotPrivKey)
@@ -395,6 +478,78 @@ func TestWireguardSecret(t *testing.T) {
 		assert.NotContains(t, config, peerAddr,
 			"should skip peers with empty public key in status")
 	})
+
+	o.Spec("should skip peer if it's suspended", func(t *testing.T) {
+		wg := dsl.GenerateWireguard(
+			v1alpha1.WireguardSpec{},
+			v1alpha1.WireguardStatus{},
+		)
+		peerAddr := v1alpha1.Address("192.168.254.42/24")
+		suspendedPeer := dsl.GeneratePeer(
+			v1alpha1.WireguardPeerSpec{
+				WireguardRef: wg.GetName(),
+				Address:      peerAddr,
+				Suspended:    true,
+			}, v1alpha1.WireguardPeerStatus{
+				PublicKey: toPtr("kekeke"),
+			},
+		)
+		fact := Wireguard{
+			Scheme:    scheme,
+			Wireguard: wg,
+			Peers: v1alpha1.WireguardPeerList{
+				Items: []v1alpha1.WireguardPeer{suspendedPeer},
+			},
+		}
+		secret, err := fact.Secret(wantPubKey, wantPrivKey)
+		assert.Nil(t, err)
+		assert.NotNil(t, secret)
+
+		config := string(secret.Data["config"])
+		assert.NotContains(t, config, "[Peer]",
+			"should skip suspended peers")
+		assert.NotContains(t, config, suspendedPeer.GetName())
+	})
+
+	type mtuCase struct {
+		description string
+		mtu         *v1alpha1.MTU
+		want        []string
+		notWant     []string
+	}
+
+	mtu := onpar.TableSpec(o, func(t *testing.T, tc mtuCase) {
+		wg := dsl.GenerateWireguard(
+			v1alpha1.WireguardSpec{MTU: tc.mtu},
+			v1alpha1.WireguardStatus{},
+		)
+		fact := Wireguard{Scheme: scheme, Wireguard: wg}
+		secret, err := fact.Secret(wantPubKey, wantPrivKey)
+		assert.Nil(t, err)
+
+		config := string(secret.Data["config"])
+		for _, line := range tc.want {
+			assert.Contains(t, config, line)
+		}
+		for _, line := range tc.notWant {
+			assert.NotContains(t, config, line)
+		}
+	})
+
+	autoMTU := "PostUp = ip link set dev %i mtu $(( $(cat /sys/class/net/eth0/mtu) - 80 ))"
+	mtu.Entry("should use kernel default mtu", mtuCase{
+		notWant: []string{"MTU =", autoMTU},
+	})
+	mtu.Entry("should set mtu", mtuCase{
+		mtu:     &v1alpha1.MTU{Value: 1380},
+		want:    []string{"ListenPort = 51820\nMTU = 1380\n"},
+		notWant: []string{autoMTU},
+	})
+	mtu.Entry("should derive mtu from eth0", mtuCase{
+		mtu:     &v1alpha1.MTU{Value: 1380, Auto: true},
+		want:    []string{autoMTU},
+		notWant: []string{"MTU ="},
+	})
 }
 
 func TestWireguardDeployment(t *testing.T) {
@@ -467,19 +622,32 @@ func TestWireguardDeployment(t *testing.T) {
 		assert.Nil(t, err)
 
 		podSpec := deploy.Spec.Template.Spec
-		epMode := podSpec.Volumes[1].ConfigMap.Items[0].Mode
-		assert.Equal(t, toPtr[int32](0755), epMode)
 		assert.Len(t, podSpec.Volumes, 2)
+		assert.NotNil(t, podSpec.Volumes[1].EmptyDir,
+			"should share agent binary through empty dir")
 		assert.Nil(t, podSpec.DNSConfig)
 
 		containers := podSpec.Containers
 		assert.Len(t, containers, len(test.wireguard.Spec.Sidecars)+1)
 
+		initContainers := podSpec.InitContainers
+		assert.Len(t, initContainers, 1)
+		assert.Equal(t, operatorImage, initContainers[0].Image,
+			"should install the agent from default image")
+		assert.Equal(t, []string{"/wg-agent", "--install", "/opt/agent/wg-agent"},
+			initContainers[0].Command, "should install the agent")
+
 		wgCont := containers[0]
 		assert.Equal(t, "wireguard", wgCont.Name)
-		assert.Len(t, wgCont.Command, 1)
-		assert.Contains(t, wgCont.Command, "/opt/bin/entrypoint.sh",
-			"should have proper entrypoint set")
+		assert.Equal(t, "/opt/agent/wg-agent", wgCont.Command[0],
+			"should run the agent")
+		assert.Equal(t, "/healthz", wgCont.LivenessProbe.HTTPGet.Path)
+		assert.Contains(t, podSpec.Volumes[0].Secret.Items, corev1.KeyToPath{
+			Key:  "rules",
+			Path: "wg0.rules",
+		}, "should mount rules applied live by the agent")
+		assert.Equal(t, []string{"--rules", "/etc/wireguard/wg0.rules"},
+			wgCont.Command[len(wgCont.Command)-2:])
 
 		wantContext := &corev1.SecurityContext{
 			Privileged: toPtr(true),
@@ -514,3 +682,269 @@ func TestWireguardDeployment(t *testing.T) {
 		spec.Entry(tc.description, tc)
 	}
 }
+
+func TestWireguardPodDisruptionBudget(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		description string
+		spec        v1alpha1.WireguardSpec
+		wantEnabled bool
+		wantMax     intstr.IntOrString
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		wg := dsl.GenerateWireguard(tc.spec, v1alpha1.WireguardStatus{})
+		fact := Wireguard{
+			Scheme:    scheme,
+			Wireguard: wg,
+		}
+		assert.Equal(t, tc.wantEnabled, fact.PodDisruptionBudgetEnabled())
+
+		pdb, err := fact.PodDisruptionBudget()
+		assert.Nil(t, err)
+		assert.Equal(t, tc.wantMax, *pdb.Spec.MaxUnavailable)
+		assert.Equal(t, fact.Labels(), pdb.Spec.Selector.MatchLabels)
+		shouldHaveProperDecorations(t, pdb)
+	})
+
+	testCases := []testCase{{
+		description: "disabled by default",
+		spec:        v1alpha1.WireguardSpec{Replicas: toPtr[int32](3)},
+		wantEnabled: false,
+		wantMax:     intstr.FromInt32(1),
+	}, {
+		description: "disabled for single replica",
+		spec: v1alpha1.WireguardSpec{
+			Replicas: toPtr[int32](1),
+			PodDisruptionBudget: &v1alpha1.PodDisruptionBudget{
+				Enabled: true,
+			},
+		},
+		wantEnabled: false,
+		wantMax:     intstr.FromInt32(1),
+	}, {
+		description: "enabled for multiple replicas",
+		spec: v1alpha1.WireguardSpec{
+			Replicas: toPtr[int32](3),
+			PodDisruptionBudget: &v1alpha1.PodDisruptionBudget{
+				Enabled:        true,
+				MaxUnavailable: toPtr(intstr.FromString("50%")),
+			},
+		},
+		wantEnabled: true,
+		wantMax:     intstr.FromString("50%"),
+	}, {
+		description: "enabled for autoscaled replicas",
+		spec: v1alpha1.WireguardSpec{
+			PodDisruptionBudget: &v1alpha1.PodDisruptionBudget{
+				Enabled: true,
+			},
+		},
+		wantEnabled: true,
+		wantMax:     intstr.FromInt32(1),
+	}}
+
+	for _, tc := range testCases {
+		spec.Entry(tc.description, tc)
+	}
+}
+
+func TestWireguardNetworkPolicy(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, defaultWgFact.NetworkPolicyEnabled())
+
+	wg := dsl.GenerateWireguard(v1alpha1.WireguardSpec{
+		NetworkPolicy: &v1alpha1.NetworkPolicy{Enabled: true},
+	}, v1alpha1.WireguardStatus{})
+	fact := Wireguard{
+		Scheme:    scheme,
+		Wireguard: wg,
+	}
+	assert.True(t, fact.NetworkPolicyEnabled())
+
+	np, err := fact.NetworkPolicy()
+	assert.Nil(t, err)
+	shouldHaveProperDecorations(t, np)
+	assert.Equal(t, fact.Labels(), np.Spec.PodSelector.MatchLabels)
+	assert.Len(t, np.Spec.Ingress, 1)
+
+	ports := np.Spec.Ingress[0].Ports
+	assert.Len(t, ports, 1)
+	assert.Equal(t, corev1.ProtocolUDP, *ports[0].Protocol)
+	assert.Equal(t, intstr.FromInt32(wireguardPort), *ports[0].Port)
+	assert.Empty(t, np.Spec.Ingress[0].From)
+
+	fact.OperatorNamespace = "wireguard-system"
+	np, err = fact.NetworkPolicy()
+	assert.Nil(t, err)
+	assert.Len(t, np.Spec.Ingress, 2)
+	assert.Len(t, np.Spec.Ingress[0].Ports, 1,
+		"should not admit everyone to the agent")
+
+	agent := np.Spec.Ingress[1]
+	assert.Len(t, agent.Ports, 1)
+	assert.Equal(t, corev1.ProtocolTCP, *agent.Ports[0].Protocol)
+	assert.Equal(t, intstr.FromInt32(AgentPort), *agent.Ports[0].Port)
+	assert.Len(t, agent.From, 1)
+	assert.Equal(t, map[string]string{
+		"kubernetes.io/metadata.name": "wireguard-system",
+	}, agent.From[0].NamespaceSelector.MatchLabels)
+	assert.Equal(t, OperatorLabels(), agent.From[0].PodSelector.MatchLabels,
+		"should admit only operator reading quotas")
+}
+
+func TestWireguardMetrics(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, defaultWgFact.MetricsEnabled())
+	for _, kind := range MonitorKinds {
+		assert.False(t, defaultWgFact.MonitorEnabled(kind))
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	type testCase struct {
+		description string
+		kind        v1alpha1.MonitorKind
+		endpoints   string
+	}
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		wg := dsl.GenerateWireguard(v1alpha1.WireguardSpec{
+			Metrics: &v1alpha1.Metrics{
+				Enabled: true,
+				Monitor: tc.kind,
+			},
+			NetworkPolicy: &v1alpha1.NetworkPolicy{Enabled: true},
+		}, v1alpha1.WireguardStatus{})
+		fact := Wireguard{
+			Scheme:    scheme,
+			Wireguard: wg,
+		}
+		assert.True(t, fact.MetricsEnabled())
+		for _, kind := range MonitorKinds {
+			assert.Equal(t, kind == tc.kind, fact.MonitorEnabled(kind))
+		}
+
+		deploy, err := fact.Deployment("")
+		assert.Nil(t, err)
+		containers := deploy.Spec.Template.Spec.Containers
+		assert.Len(t, containers, 2)
+		assert.Equal(t, "metrics-exporter", containers[1].Name)
+
+		svc, err := fact.MetricsService()
+		assert.Nil(t, err)
+		shouldHaveProperDecorations(t, svc)
+		assert.Equal(t, corev1.ServiceTypeClusterIP, svc.Spec.Type)
+		assert.Equal(t, fact.Labels(), svc.Spec.Selector)
+		assert.Equal(t, "metrics", svc.Labels[componentLabel])
+
+		mon, err := fact.Monitor(tc.kind)
+		assert.Nil(t, err)
+		shouldHaveProperDecorations(t, mon)
+		assert.Equal(t, string(tc.kind), mon.GetKind())
+		assert.Equal(t, "monitoring.coreos.com/v1", mon.GetAPIVersion())
+
+		spec := mon.Object["spec"].(map[string]any)
+		assert.Contains(t, spec, tc.endpoints)
+
+		np, err := fact.NetworkPolicy()
+		assert.Nil(t, err)
+		ports := np.Spec.Ingress[0].Ports
+		assert.Len(t, ports, 2)
+		assert.Equal(t, corev1.ProtocolTCP, *ports[1].Protocol)
+		assert.Equal(t, intstr.FromInt32(metricsPort), *ports[1].Port)
+	})
+
+	spec.Entry("service monitor", testCase{
+		kind:      v1alpha1.ServiceMonitor,
+		endpoints: "endpoints",
+	})
+	spec.Entry("pod monitor", testCase{
+		kind:      v1alpha1.PodMonitor,
+		endpoints: "podMetricsEndpoints",
+	})
+
+	o.Spec("manager monitor", func(t *testing.T) {
+		selector := map[string]string{"app": "operator"}
+		mon, err := ManagerMonitor("operator", selector)
+		assert.Nil(t, err)
+		assert.Equal(t, "PodMonitor", mon.GetKind())
+		assert.Equal(t, "operator", mon.GetNamespace())
+	})
+}
+
+func TestWireguardReadinessProbe(t *testing.T) {
+	t.Parallel()
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	custom := &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{Command: []string{"true"}},
+		},
+	}
+
+	type testCase struct {
+		description string
+		probe       *v1alpha1.ReadinessProbe
+		validate    func(*testing.T, *corev1.Probe)
+	}
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		wg := dsl.GenerateWireguard(v1alpha1.WireguardSpec{
+			ReadinessProbe: tc.probe,
+		}, v1alpha1.WireguardStatus{})
+		fact := Wireguard{
+			Scheme:    scheme,
+			Wireguard: wg,
+		}
+
+		deploy, err := fact.Deployment("")
+		assert.Nil(t, err)
+		container := deploy.Spec.Template.Spec.Containers[0]
+		assert.Equal(t, "wireguard", container.Name)
+		tc.validate(t, container.ReadinessProbe)
+	})
+
+	for _, tc := range []testCase{{
+		description: "checks interface by default",
+		validate: func(t *testing.T, probe *corev1.Probe) {
+			assert.NotNil(t, probe)
+			assert.Nil(t, probe.HTTPGet, "should not require internet")
+			assert.Equal(t,
+				[]string{"wg", "show", "wg0", "listen-port"},
+				probe.Exec.Command)
+		},
+	}, {
+		description: "pings target",
+		probe:       &v1alpha1.ReadinessProbe{Target: "192.168.254.2"},
+		validate: func(t *testing.T, probe *corev1.Probe) {
+			assert.NotNil(t, probe)
+			command := probe.Exec.Command
+			assert.Equal(t, "192.168.254.2", command[len(command)-1])
+			assert.Contains(t, command[2], "ping")
+		},
+	}, {
+		description: "can be disabled",
+		probe:       &v1alpha1.ReadinessProbe{Disabled: true},
+		validate: func(t *testing.T, probe *corev1.Probe) {
+			assert.Nil(t, probe)
+		},
+	}, {
+		description: "can be replaced",
+		probe:       &v1alpha1.ReadinessProbe{Custom: custom},
+		validate: func(t *testing.T, probe *corev1.Probe) {
+			assert.Equal(t, custom, probe)
+		},
+	}} {
+		spec.Entry(tc.description, tc)
+	}
+}
@@ -0,0 +1,136 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const lookupTimeout = 5 * time.Second
+
+// Resolves hostnames into IP addresses
+type Resolver interface {
+	Resolve(host string) ([]string, error)
+}
+
+// Returns true when any of the given addresses is a hostname and needs to
+// be resolved
+func NeedsLookup(addrs ...string) bool {
+	for _, addr := range addrs {
+		if addr != "" && net.ParseIP(addr) == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resolver doing actual network lookups
+type Net struct{}
+
+func (Net) Resolve(host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// Resolver caching results of the underlying resolver. Addresses are
+// refreshed once refresh interval has passed. When refresh fails, last
+// known addresses are returned until the next refresh, so lookup outage
+// does not break rendering
+type Cached struct {
+	Resolver
+	RefreshInterval time.Duration
+
+	// guards entries only, lookups are done outside of it
+	mu      sync.Mutex
+	entries map[string]entry
+	lookups singleflight.Group
+	now     func() time.Time
+}
+
+type entry struct {
+	addrs      []string
+	resolvedAt time.Time
+}
+
+func NewCached(resolver Resolver, refreshInterval time.Duration) *Cached {
+	return &Cached{
+		Resolver:        resolver,
+		RefreshInterval: refreshInterval,
+		entries:         map[string]entry{},
+		now:             time.Now,
+	}
+}
+
+// Returns sorted addresses of the host, so output is deterministic
+func (c *Cached) Resolve(host string) ([]string, error) {
+	c.mu.Lock()
+	cached, found := c.entries[host]
+	fresh := found && c.now().Sub(cached.resolvedAt) < c.RefreshInterval
+	c.mu.Unlock()
+	if fresh {
+		return slices.Clone(cached.addrs), nil
+	}
+
+	// concurrent lookups of the host share single query, lookups of the
+	// other hosts are not blocked by it
+	addrs, err, _ := c.lookups.Do(host, func() (any, error) {
+		return c.refresh(host)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.Clone(addrs.([]string)), nil
+}
+
+// Resolves host with the underlying resolver and caches the result
+func (c *Cached) refresh(host string) ([]string, error) {
+	addrs, err := c.Resolver.Resolve(host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	cached, found := c.entries[host]
+	if err != nil && found {
+		// serving stale addresses during outage, lookup is retried only
+		// once refresh interval passes again
+		cached.resolvedAt = now
+		c.entries[host] = cached
+		return cached.addrs, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	addrs = slices.Clone(addrs)
+	slices.Sort(addrs)
+	c.entries[host] = entry{
+		addrs:      addrs,
+		resolvedAt: now,
+	}
+
+	return addrs, nil
+}
+
+// Resolver returning predefined addresses. Useful for tests
+type Fake map[string][]string
+
+func (f Fake) Resolve(host string) ([]string, error) {
+	addrs, ok := f[host]
+	if !ok {
+		return nil, &net.DNSError{
+			Err:        "no such host",
+			Name:       host,
+			IsNotFound: true,
+		}
+	}
+
+	return addrs, nil
+}
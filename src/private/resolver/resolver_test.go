@@ -0,0 +1,165 @@
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+)
+
+// Resolver counting lookups and returning configured addresses
+type countingResolver struct {
+	addrs []string
+	err   error
+	calls int
+}
+
+func (r *countingResolver) Resolve(_ string) ([]string, error) {
+	r.calls++
+	return r.addrs, r.err
+}
+
+// Resolver blocking lookups of the slow host until released
+type blockingResolver struct {
+	slow    string
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r blockingResolver) Resolve(host string) ([]string, error) {
+	if host == r.slow {
+		close(r.started)
+		<-r.release
+	}
+
+	return []string{"10.0.0.1"}, nil
+}
+
+func TestNeedsLookup(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, NeedsLookup())
+	assert.False(t, NeedsLookup("", "1.1.1.1", "::1"))
+	assert.True(t, NeedsLookup("1.1.1.1", "one.one.one.one"))
+}
+
+func TestCached(t *testing.T) {
+	t.Parallel()
+
+	type testContext struct {
+		upstream *countingResolver
+		cached   *Cached
+		now      time.Time
+	}
+
+	o := onpar.BeforeEach(onpar.New(t), func(t *testing.T) *testContext {
+		tc := &testContext{
+			upstream: &countingResolver{addrs: []string{"1.1.1.1", "1.0.0.1"}},
+			now:      time.Now(),
+		}
+		tc.cached = NewCached(tc.upstream, time.Minute)
+		tc.cached.now = func() time.Time { return tc.now }
+		return tc
+	})
+	defer o.Run()
+
+	o.Spec("should return sorted addresses", func(tc *testContext) {
+		addrs, err := tc.cached.Resolve("one.one.one.one")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1.0.0.1", "1.1.1.1"}, addrs)
+	})
+
+	o.Spec("should not resolve again within refresh interval", func(tc *testContext) {
+		for range 3 {
+			_, err := tc.cached.Resolve("one.one.one.one")
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, 1, tc.upstream.calls)
+	})
+
+	o.Spec("should refresh after interval", func(tc *testContext) {
+		_, err := tc.cached.Resolve("one.one.one.one")
+		assert.Nil(t, err)
+
+		tc.now = tc.now.Add(2 * time.Minute)
+		tc.upstream.addrs = []string{"8.8.8.8"}
+		addrs, err := tc.cached.Resolve("one.one.one.one")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"8.8.8.8"}, addrs)
+		assert.Equal(t, 2, tc.upstream.calls)
+	})
+
+	o.Spec("should serve stale addresses on failure", func(tc *testContext) {
+		_, err := tc.cached.Resolve("one.one.one.one")
+		assert.Nil(t, err)
+
+		tc.now = tc.now.Add(2 * time.Minute)
+		tc.upstream.err = assert.AnError
+		addrs, err := tc.cached.Resolve("one.one.one.one")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1.0.0.1", "1.1.1.1"}, addrs)
+	})
+
+	o.Spec("should not retry failed refresh within interval", func(tc *testContext) {
+		_, err := tc.cached.Resolve("one.one.one.one")
+		assert.Nil(t, err)
+
+		tc.now = tc.now.Add(2 * time.Minute)
+		tc.upstream.err = assert.AnError
+		for range 3 {
+			_, err := tc.cached.Resolve("one.one.one.one")
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, 2, tc.upstream.calls)
+
+		tc.now = tc.now.Add(2 * time.Minute)
+		_, err = tc.cached.Resolve("one.one.one.one")
+		assert.Nil(t, err)
+		assert.Equal(t, 3, tc.upstream.calls)
+	})
+
+	o.Spec("should fail when nothing is cached", func(tc *testContext) {
+		tc.upstream.err = assert.AnError
+		_, err := tc.cached.Resolve("one.one.one.one")
+		assert.ErrorIs(t, err, assert.AnError)
+	})
+}
+
+func TestCachedConcurrentHosts(t *testing.T) {
+	t.Parallel()
+
+	upstream := blockingResolver{
+		slow:    "slow.local",
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	cached := NewCached(upstream, time.Minute)
+
+	slow := make(chan error)
+	go func() {
+		_, err := cached.Resolve("slow.local")
+		slow <- err
+	}()
+	<-upstream.started
+
+	addrs, err := cached.Resolve("fast.local")
+	assert.Nil(t, err, "should not wait for lookup of the other host")
+	assert.Equal(t, []string{"10.0.0.1"}, addrs)
+
+	close(upstream.release)
+	assert.Nil(t, <-slow)
+}
+
+func TestFake(t *testing.T) {
+	t.Parallel()
+
+	fake := Fake{"kek.local": {"10.0.0.1"}}
+
+	addrs, err := fake.Resolve("kek.local")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, addrs)
+
+	_, err = fake.Resolve("lel.local")
+	assert.NotNil(t, err)
+}
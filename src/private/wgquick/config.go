@@ -0,0 +1,238 @@
+// Package wgquick parses wg-quick configuration rendered by the operator
+package wgquick
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+var ErrInvalidConfig = fmt.Errorf("invalid wireguard config")
+
+// Settings of the [Interface] section handled by wg-quick rather than
+// wireguard itself
+type Interface struct {
+	Addresses []netip.Prefix
+	// Zero means MTU of the interface is not changed
+	MTU      int
+	PreUp    []string
+	PostUp   []string
+	PreDown  []string
+	PostDown []string
+}
+
+type Config struct {
+	Interface Interface
+	// Settings of the wireguard device, peers are always replaced
+	Device wgtypes.Config
+}
+
+// Parses wg-quick config. Endpoints of the peers are resolved, so parsing
+// might block on DNS
+func Parse(r io.Reader) (Config, error) {
+	cfg := Config{
+		Device: wgtypes.Config{ReplacePeers: true},
+	}
+
+	var peer *wgtypes.PeerConfig
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			section = line
+			switch section {
+			case "[Interface]":
+			case "[Peer]":
+				cfg.Device.Peers = append(cfg.Device.Peers, wgtypes.PeerConfig{
+					ReplaceAllowedIPs: true,
+				})
+				peer = &cfg.Device.Peers[len(cfg.Device.Peers)-1]
+			default:
+				return Config{}, fmt.Errorf("%w: line %d: unknown section %s",
+					ErrInvalidConfig, lineNo, section)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return Config{}, fmt.Errorf("%w: line %d: expected key = value",
+				ErrInvalidConfig, lineNo)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		var err error
+		switch section {
+		case "[Interface]":
+			err = cfg.parseInterface(key, value)
+		case "[Peer]":
+			err = parsePeer(peer, key, value)
+		default:
+			err = fmt.Errorf("key outside of section")
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf("%w: line %d: %s: %w",
+				ErrInvalidConfig, lineNo, key, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return Config{}, err
+	}
+	if cfg.Device.PrivateKey == nil {
+		return Config{}, fmt.Errorf("%w: PrivateKey is required", ErrInvalidConfig)
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) parseInterface(key, value string) error {
+	iface := &cfg.Interface
+	switch key {
+	case "Address":
+		for _, item := range splitList(value) {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return err
+			}
+			iface.Addresses = append(iface.Addresses, prefix)
+		}
+	case "MTU":
+		mtu, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		iface.MTU = mtu
+	case "PreUp":
+		iface.PreUp = append(iface.PreUp, value)
+	case "PostUp":
+		iface.PostUp = append(iface.PostUp, value)
+	case "PreDown":
+		iface.PreDown = append(iface.PreDown, value)
+	case "PostDown":
+		iface.PostDown = append(iface.PostDown, value)
+	case "PrivateKey":
+		privateKey, err := wgtypes.ParseKey(value)
+		if err != nil {
+			return err
+		}
+		cfg.Device.PrivateKey = &privateKey
+	case "ListenPort":
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		cfg.Device.ListenPort = &port
+	case "FwMark":
+		mark, err := parseFwMark(value)
+		if err != nil {
+			return err
+		}
+		cfg.Device.FirewallMark = &mark
+	case "SaveConfig", "DNS", "Table":
+		// not applicable to the pod, so ignored
+	default:
+		return fmt.Errorf("unknown key")
+	}
+
+	return nil
+}
+
+func parsePeer(peer *wgtypes.PeerConfig, key, value string) error {
+	switch key {
+	case "PublicKey":
+		publicKey, err := wgtypes.ParseKey(value)
+		if err != nil {
+			return err
+		}
+		peer.PublicKey = publicKey
+	case "PresharedKey":
+		presharedKey, err := wgtypes.ParseKey(value)
+		if err != nil {
+			return err
+		}
+		peer.PresharedKey = &presharedKey
+	case "Endpoint":
+		endpoint, err := net.ResolveUDPAddr("udp", value)
+		if err != nil {
+			return err
+		}
+		peer.Endpoint = endpoint
+	case "AllowedIPs":
+		for _, item := range splitList(value) {
+			_, network, err := net.ParseCIDR(item)
+			if err != nil {
+				return err
+			}
+			peer.AllowedIPs = append(peer.AllowedIPs, *network)
+		}
+	case "PersistentKeepalive":
+		if value == "off" {
+			return nil
+		}
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		interval := time.Duration(seconds) * time.Second
+		peer.PersistentKeepaliveInterval = &interval
+	default:
+		return fmt.Errorf("unknown key")
+	}
+
+	return nil
+}
+
+// Firewall mark is either decimal or hexadecimal, off means zero
+func parseFwMark(value string) (int, error) {
+	if value == "off" {
+		return 0, nil
+	}
+
+	mark, err := strconv.ParseUint(value, 0, 32)
+	return int(mark), err
+}
+
+// Returns [Interface] section of the config. Changes outside of it are
+// applied to running interface, see Config.Device
+func InterfaceSection(config []byte) []byte {
+	var buf bytes.Buffer
+	inside := false
+	for _, line := range strings.Split(string(config), "\n") {
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "[") {
+			inside = trimmed == "[Interface]"
+		}
+
+		if inside {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func splitList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
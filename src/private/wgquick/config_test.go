@@ -0,0 +1,135 @@
+package wgquick
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := wgtypes.GeneratePrivateKey()
+	assert.Nil(t, err)
+	publicKey := privateKey.PublicKey()
+
+	config := `[Interface]
+PrivateKey = ` + privateKey.String() + `
+Address = 10.8.0.1/24, fd00::1/64
+ListenPort = 51820
+MTU = 1420
+SaveConfig = false
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+PostUp = iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE
+
+# friendly_name = laptop
+[Peer]
+PublicKey = ` + publicKey.String() + `
+PresharedKey = ` + privateKey.String() + `
+Endpoint = 127.0.0.1:51821
+AllowedIPs = 10.8.0.2/32, 192.168.0.0/24
+PersistentKeepalive = 25
+`
+
+	cfg, err := Parse(strings.NewReader(config))
+	assert.Nil(t, err)
+
+	wantIface := Interface{
+		Addresses: []netip.Prefix{
+			netip.MustParsePrefix("10.8.0.1/24"),
+			netip.MustParsePrefix("fd00::1/64"),
+		},
+		MTU: 1420,
+		PostUp: []string{
+			"iptables -A FORWARD -i %i -j ACCEPT",
+			"iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE",
+		},
+	}
+	assert.Equal(t, wantIface, cfg.Interface)
+
+	device := cfg.Device
+	assert.Equal(t, privateKey, *device.PrivateKey)
+	assert.Equal(t, 51820, *device.ListenPort)
+	assert.Nil(t, device.FirewallMark)
+	assert.Len(t, device.Peers, 1)
+
+	peer := device.Peers[0]
+	assert.Equal(t, publicKey, peer.PublicKey)
+	assert.Equal(t, privateKey, *peer.PresharedKey)
+	assert.Equal(t, "127.0.0.1:51821", peer.Endpoint.String())
+	assert.Equal(t, 25*time.Second, *peer.PersistentKeepaliveInterval)
+	assert.True(t, peer.ReplaceAllowedIPs)
+	assert.Equal(t, []net.IPNet{{
+		IP:   net.IPv4(10, 8, 0, 2).To4(),
+		Mask: net.CIDRMask(32, 32),
+	}, {
+		IP:   net.IPv4(192, 168, 0, 0).To4(),
+		Mask: net.CIDRMask(24, 32),
+	}}, peer.AllowedIPs)
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := wgtypes.GeneratePrivateKey()
+	assert.Nil(t, err)
+
+	type testCase struct {
+		description string
+		config      string
+	}
+
+	o := onpar.New(t)
+	defer o.Run()
+
+	spec := onpar.TableSpec(o, func(t *testing.T, tc testCase) {
+		_, err := Parse(strings.NewReader(tc.config))
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+	})
+
+	spec.Entry("missing private key", testCase{
+		config: "[Interface]\nAddress = 10.8.0.1/24\n",
+	})
+	spec.Entry("unknown section", testCase{
+		config: "[Interface]\nPrivateKey = " + privateKey.String() + "\n[Kek]\n",
+	})
+	spec.Entry("unknown key", testCase{
+		config: "[Interface]\nPrivateKey = " + privateKey.String() + "\nKek = 1\n",
+	})
+	spec.Entry("key outside of section", testCase{
+		config: "PrivateKey = " + privateKey.String() + "\n",
+	})
+	spec.Entry("invalid address", testCase{
+		config: "[Interface]\nPrivateKey = " + privateKey.String() + "\nAddress = kek\n",
+	})
+	spec.Entry("invalid public key", testCase{
+		config: "[Interface]\nPrivateKey = " + privateKey.String() +
+			"\n[Peer]\nPublicKey = kek\n",
+	})
+}
+
+func TestInterfaceSection(t *testing.T) {
+	t.Parallel()
+
+	config := []byte(`[Interface]
+PrivateKey = kek
+Address = 10.8.0.1/24
+
+[Peer]
+PublicKey = lol
+AllowedIPs = 10.8.0.2/32
+`)
+	want := "[Interface]\nPrivateKey = kek\nAddress = 10.8.0.1/24\n\n"
+	assert.Equal(t, want, string(InterfaceSection(config)))
+
+	changedPeers := []byte(strings.Replace(string(config),
+		"10.8.0.2/32", "10.8.0.3/32", 1))
+	assert.Equal(t, InterfaceSection(config), InterfaceSection(changedPeers),
+		"should not change with peers")
+}
@@ -37,3 +37,27 @@ func GeneratePeer(
 		Status: status,
 	}
 }
+
+func GenerateClient(
+	spec v1alpha1.WireguardClientSpec, status v1alpha1.WireguardClientStatus,
+) v1alpha1.WireguardClient {
+
+	name := names.SimpleNameGenerator.GenerateName("client-")
+	return v1alpha1.WireguardClient{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: corev1.NamespaceDefault,
+		},
+		Spec:   spec,
+		Status: status,
+	}
+}
+
+// Returns peer with the given name and public key set, so it's rendered
+// into the config of the wireguard
+func NamedPeer(name string, spec v1alpha1.WireguardPeerSpec) v1alpha1.WireguardPeer {
+	publicKey := "kekeke"
+	peer := GeneratePeer(spec, v1alpha1.WireguardPeerStatus{PublicKey: &publicKey})
+	peer.SetName(name)
+	return peer
+}